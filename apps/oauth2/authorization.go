@@ -41,3 +41,23 @@ func (controller *controller) succeedAuthorization(ctx *gin.Context) {
 	ctx.SetCookie("authorization", "", -1, "/", "", false, true)
 	ctx.Redirect(302, response.BuildResponseURI())
 }
+
+// denyAuthorization is called once the authenticated user denied the
+// authorization request, be it of a client or of a device.
+func (controller *controller) denyAuthorization(ctx *gin.Context) {
+	uuid, err := ctx.Cookie("authorization_id")
+	if err != nil {
+		ctx.Error(err)
+		ctx.Redirect(302, "/")
+		return
+	}
+	authenticationVerifier, err := ctx.Cookie("authentication_verifier")
+	if err != nil {
+		ctx.Error(err)
+		ctx.Redirect(302, "/")
+		return
+	}
+	response := controller.authorizationService.Deny(ctx.Request.Context(), uuid, authenticationVerifier)
+	ctx.SetCookie("authorization", "", -1, "/", "", false, true)
+	ctx.Redirect(302, response.BuildResponseURI())
+}
@@ -16,9 +16,12 @@ func (controller *controller) listClients(ctx *gin.Context) {
 	var dtos = make([]oauth2.ClientDTO, 0, len(clients))
 	for _, client := range clients {
 		dtos = append(dtos, oauth2.ClientDTO{
-			ID:           client.ID,
-			Scopes:       client.Scopes,
-			RedirectURIs: client.RedirectURIs,
+			ID:                      client.ID,
+			Scopes:                  client.Scopes,
+			RedirectURIs:            client.RedirectURIs,
+			TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+			JWKS:                    client.JWKS,
+			RotateRefreshTokens:     client.RotateRefreshTokens,
 		})
 	}
 	ctx.JSON(http.StatusOK, dtos)
@@ -33,9 +36,12 @@ func (controller *controller) getClient(ctx *gin.Context) {
 		return
 	}
 	ctx.JSON(http.StatusOK, &oauth2.ClientDTO{
-		ID:           client.ID,
-		Scopes:       client.Scopes,
-		RedirectURIs: client.RedirectURIs,
+		ID:                      client.ID,
+		Scopes:                  client.Scopes,
+		RedirectURIs:            client.RedirectURIs,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		JWKS:                    client.JWKS,
+		RotateRefreshTokens:     client.RotateRefreshTokens,
 	})
 }
 
@@ -48,16 +54,28 @@ func (controller *controller) createClient(ctx *gin.Context) {
 		return
 	}
 
-	client, err := controller.clientService.Create(ctx, dto)
+	client, secret, err := controller.clientService.Create(ctx, dto)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
-	ctx.JSON(http.StatusCreated, &oauth2.ClientDTO{
-		ID:           client.ID,
-		Scopes:       client.Scopes,
-		RedirectURIs: client.RedirectURIs,
-	})
+	clientDTO := oauth2.ClientDTO{
+		ID:                      client.ID,
+		Scopes:                  client.Scopes,
+		RedirectURIs:            client.RedirectURIs,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		JWKS:                    client.JWKS,
+		RotateRefreshTokens:     client.RotateRefreshTokens,
+	}
+	if secret != "" {
+		// the secret is only returned once and cannot be retrieved later
+		ctx.JSON(http.StatusCreated, &oauth2.ClientWithSecretDTO{
+			ClientDTO: clientDTO,
+			Secret:    secret,
+		})
+		return
+	}
+	ctx.JSON(http.StatusCreated, &clientDTO)
 }
 
 func (controller *controller) deleteClient(ctx *gin.Context) {
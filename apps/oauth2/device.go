@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Untanky/modern-auth/apps/oauth2/internal/oauth2"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (controller *controller) startDeviceAuthorization(ctx *gin.Context) {
+	client, err := controller.authenticateClient(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, &oauth2.TokenError{
+			ErrorType:        "invalid_client",
+			ErrorDescription: "client authentication failed",
+		})
+		return
+	}
+
+	request := &oauth2.DeviceAuthorizationRequest{}
+	err = ctx.ShouldBind(request)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &oauth2.TokenError{
+			ErrorType:        "invalid_request",
+			ErrorDescription: err.Error(),
+		})
+		return
+	}
+
+	response, err := controller.deviceService.Initiate(ctx.Request.Context(), client, request)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &oauth2.TokenError{
+			ErrorType:        "invalid_scope",
+			ErrorDescription: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// verifyDevice is called from the verification page once the user entered a
+// user code. The user then logs in like in the 'authorization_code' flow and
+// approves or denies the device. Wrong user codes are limited per IP address.
+func (controller *controller) verifyDevice(ctx *gin.Context) {
+	userCode := ctx.Query("user_code")
+	uuid, err := controller.authorizationService.AuthorizeDevice(ctx.Request.Context(), userCode, ctx.ClientIP())
+	if err != nil {
+		ctx.Error(err)
+		ctx.Redirect(302, fmt.Sprintf("%s?error=invalid_user_code", *deviceVerificationUri))
+		return
+	}
+	ctx.SetCookie("authorization_id", uuid, 0, "/", "", true, true)
+	ctx.Redirect(302, "/")
+}
@@ -0,0 +1,74 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+// AttemptStore counts the failed attempts by the source of the attempts.
+type AttemptStore = core.KeyValueStore[string, int]
+
+// errTooManyAttempts is returned while a source is limited.
+var errTooManyAttempts = errors.New("too many failed attempts")
+
+// maxAttemptRetries is the number of times counting a failed attempt is
+// retried, if it is counted concurrently.
+const maxAttemptRetries = 5
+
+// AttemptLimiter limits the failed attempts to guess a secret, e.g. a user
+// code, per source within a window. The window starts with the first failed
+// attempt. The store is shared by all replicas, so the limit cannot be
+// bypassed by spreading attempts across them.
+type AttemptLimiter struct {
+	store       AttemptStore
+	maxAttempts int
+	window      time.Duration
+}
+
+func NewAttemptLimiter(store AttemptStore, maxAttempts int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		store:       store,
+		maxAttempts: maxAttempts,
+		window:      window,
+	}
+}
+
+// Check returns errTooManyAttempts if the source failed too often.
+func (l *AttemptLimiter) Check(ctx context.Context, source string) error {
+	attempts, err := l.store.WithContext(ctx).Get(source)
+	if err == nil && attempts >= l.maxAttempts {
+		return errTooManyAttempts
+	}
+	return nil
+}
+
+// Fail counts a failed attempt of the source.
+func (l *AttemptLimiter) Fail(ctx context.Context, source string) error {
+	store := l.store.WithContext(ctx)
+	for i := 0; i < maxAttemptRetries; i++ {
+		set, err := store.SetIfAbsent(source, 1, l.window)
+		if err != nil {
+			return err
+		}
+		if set {
+			return nil
+		}
+		attempts, err := store.Get(source)
+		if err != nil {
+			// the window passed in the meantime
+			continue
+		}
+		swapped, err := store.CompareAndSet(source, attempts, attempts+1)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			return nil
+		}
+	}
+	return fmt.Errorf("counting failed attempt: %w", errTooManyAttempts)
+}
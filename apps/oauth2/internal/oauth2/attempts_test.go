@@ -0,0 +1,58 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+func newTestAttemptLimiter(t *testing.T, maxAttempts int) *AttemptLimiter {
+	t.Helper()
+	store := core.NewInMemoryKeyValueStore[int]()
+	t.Cleanup(store.Close)
+	return NewAttemptLimiter(store, maxAttempts, time.Minute)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestAttemptLimiter(t, 3)
+
+	for i := 0; i < 3; i++ {
+		if err := limiter.Check(ctx, "attacker"); err != nil {
+			t.Fatalf("Check() after %d attempts error = %v", i, err)
+		}
+		if err := limiter.Fail(ctx, "attacker"); err != nil {
+			t.Fatalf("Fail() error = %v", err)
+		}
+	}
+	if err := limiter.Check(ctx, "attacker"); !errors.Is(err, errTooManyAttempts) {
+		t.Errorf("Check() error = %v, want %v", err, errTooManyAttempts)
+	}
+	if err := limiter.Check(ctx, "user"); err != nil {
+		t.Errorf("Check() of other source error = %v", err)
+	}
+}
+
+func TestAttemptLimiterConcurrentFailures(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestAttemptLimiter(t, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Fail(ctx, "attacker")
+		}()
+	}
+	wg.Wait()
+
+	attempts, err := limiter.store.Get("attacker")
+	if err != nil || attempts != 4 {
+		t.Errorf("attempts = %d, error = %v, want 4", attempts, err)
+	}
+}
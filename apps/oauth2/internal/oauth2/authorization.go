@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
 	"github.com/Untanky/modern-auth/internal/utils"
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel/attribute"
@@ -15,13 +17,16 @@ import (
 type AuthorizationRequest struct {
 	id                 uuid.UUID
 	authenticationCode []byte
-	ClientId           string
-	RedirectUri        string
-	ResponseType       string
-	Scope              string
-	State              string
-	CodeChallenge      string
-	CodeMethod         string
+	authentication     *domain.Authentication
+	deviceKey          string
+	ClientId           string `form:"client_id"`
+	RedirectUri        string `form:"redirect_uri"`
+	ResponseType       string `form:"response_type"`
+	Scope              string `form:"scope"`
+	State              string `form:"state"`
+	Nonce              string `form:"nonce"`
+	CodeChallenge      string `form:"code_challenge"`
+	CodeMethod         string `form:"code_challenge_method"`
 }
 
 type ResponseUriBuilder interface {
@@ -51,25 +56,49 @@ func (e *AuthorizationError) BuildResponseURI() string {
 	return fmt.Sprintf("%s?error=%s&error_description=%s&state=%s&iss=%s", e.RedirectUri, e.ErrorType, e.Description, e.State, e.Issuer)
 }
 
+// DeviceApprovalResponse sends the user to a confirmation page after they
+// approved or denied a device authorization.
+type DeviceApprovalResponse struct {
+	VerificationUri string
+	Denied          bool
+}
+
+func (r *DeviceApprovalResponse) BuildResponseURI() string {
+	if r.Denied {
+		return fmt.Sprintf("%s?status=denied", r.VerificationUri)
+	}
+	return fmt.Sprintf("%s?status=approved", r.VerificationUri)
+}
+
 func (e *AuthorizationError) Error() string {
 	return fmt.Sprintf("AuthorizationError: %s", e.Description)
 }
 
+const (
+	// authorizationRequestLifetime is the time the user has to authenticate
+	authorizationRequestLifetime = 30 * time.Minute
+	// authorizationCodeLifetime is kept short, as recommended by RFC 6749,
+	// section 4.1.2
+	authorizationCodeLifetime = time.Minute
+)
+
 type AuthorizationStore = core.KeyValueStore[string, *AuthorizationRequest]
 type CodeStore = core.KeyValueStore[string, *AuthorizationRequest]
-type AuthenticationVerifierStore = core.KeyValueStore[string, []byte]
+type AuthenticationVerifierStore = domain.AuthenticationStore
 
 type AuthorizationService struct {
 	authorizationStore          AuthorizationStore
 	codeStore                   CodeStore
 	authenticationVerifierStore AuthenticationVerifierStore
 	clientService               *ClientService
+	deviceService               *DeviceAuthorizationService
+	issuer                      string
 	logger                      *slog.Logger
 	authorizationCodeInit       metric.Int64Counter
 	authorizationCodeSuccess    metric.Int64Counter
 }
 
-func NewAuthorizationService(authorizationStore AuthorizationStore, codeStore CodeStore, authenticationVerifierStore AuthenticationVerifierStore, clientService *ClientService, authorizationCodeInit metric.Int64Counter, authorizationCodeSuccess metric.Int64Counter) *AuthorizationService {
+func NewAuthorizationService(authorizationStore AuthorizationStore, codeStore CodeStore, authenticationVerifierStore AuthenticationVerifierStore, clientService *ClientService, deviceService *DeviceAuthorizationService, issuer string, authorizationCodeInit metric.Int64Counter, authorizationCodeSuccess metric.Int64Counter) *AuthorizationService {
 	logger := slog.Default().With(slog.String("service", "authorization"))
 
 	return &AuthorizationService{
@@ -77,6 +106,8 @@ func NewAuthorizationService(authorizationStore AuthorizationStore, codeStore Co
 		codeStore:                   codeStore,
 		authenticationVerifierStore: authenticationVerifierStore,
 		clientService:               clientService,
+		deviceService:               deviceService,
+		issuer:                      issuer,
 		logger:                      logger,
 		authorizationCodeInit:       authorizationCodeInit,
 		authorizationCodeSuccess:    authorizationCodeSuccess,
@@ -115,11 +146,11 @@ func (s *AuthorizationService) Authorize(ctx context.Context, request *Authoriza
 		}
 	}
 
-	request.authenticationCode = utils.HashShake256([]byte(randomString(32)))
+	request.authenticationCode = utils.HashShake256([]byte(utils.RandomString(32)))
 
 	stringUuid := uuid.String()
 	request.id = uuid
-	err = s.authorizationStore.WithContext(ctx).Set(stringUuid, request)
+	err = s.authorizationStore.WithContext(ctx).SetWithTTL(stringUuid, request, authorizationRequestLifetime)
 	if err != nil {
 		return "", &AuthorizationError{
 			RedirectUri: request.RedirectUri,
@@ -133,10 +164,57 @@ func (s *AuthorizationService) Authorize(ctx context.Context, request *Authoriza
 	return stringUuid, nil
 }
 
+// AuthorizeDevice starts the user facing part of a device authorization. The
+// user authenticates the same way as in the 'authorization_code' flow.
+func (s *AuthorizationService) AuthorizeDevice(ctx context.Context, userCode string, source string) (string, error) {
+	s.logger.Debug("Beginning device authorization approval")
+	deviceKey, deviceAuthorization, err := s.deviceService.VerifyUserCode(ctx, userCode, source)
+	if err != nil {
+		return "", err
+	}
+
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	request := &AuthorizationRequest{
+		id:        uuid,
+		deviceKey: deviceKey,
+		ClientId:  deviceAuthorization.ClientId,
+		Scope:     deviceAuthorization.Scope,
+	}
+	stringUuid := uuid.String()
+	err = s.authorizationStore.WithContext(ctx).SetWithTTL(stringUuid, request, authorizationRequestLifetime)
+	if err != nil {
+		return "", err
+	}
+	s.logger.Info("Initialized device authorization approval", "authorizationId", stringUuid)
+
+	return stringUuid, nil
+}
+
 func (s *AuthorizationService) VerifyAuthentication(ctx context.Context, uuid string, authenticationVerifier string) ResponseUriBuilder {
-	s.logger.Debug("Continuing 'authorization_code' flow", "authorizationId", uuid)
-	hashedAuthenticationVerifier, err := s.authenticationVerifierStore.Get(uuid)
+	authentication, authErr := s.claimAuthentication(ctx, uuid, authenticationVerifier)
+	if authErr != nil {
+		return authErr
+	}
+
+	return s.succeed(ctx, uuid, authentication)
+}
+
+// Deny rejects the authorization request once the user authenticated. A
+// device authorization is denied, so that the device stops polling, and a
+// client is sent access_denied.
+func (s *AuthorizationService) Deny(ctx context.Context, uuid string, authenticationVerifier string) ResponseUriBuilder {
+	_, authErr := s.claimAuthentication(ctx, uuid, authenticationVerifier)
+	if authErr != nil {
+		return authErr
+	}
+
+	request, err := s.authorizationStore.WithContext(ctx).GetAndDelete(uuid)
 	if err != nil {
+		s.logger.Warn("Authorization request not found, it expired or was replayed", "authorizationId", uuid)
 		return &AuthorizationError{
 			RedirectUri: "",
 			State:       "",
@@ -144,49 +222,87 @@ func (s *AuthorizationService) VerifyAuthentication(ctx context.Context, uuid st
 		}
 	}
 
+	if request.deviceKey != "" {
+		err = s.deviceService.Deny(ctx, request.deviceKey)
+		if err != nil {
+			return &AuthorizationError{
+				RedirectUri: s.deviceService.verificationUri,
+				ErrorType:   "access_denied",
+			}
+		}
+		return &DeviceApprovalResponse{VerificationUri: s.deviceService.verificationUri, Denied: true}
+	}
+
+	s.logger.Info("User denied authorization", "authorizationId", uuid)
+	return &AuthorizationError{
+		RedirectUri: request.RedirectUri,
+		State:       request.State,
+		Issuer:      s.issuer,
+		ErrorType:   "access_denied",
+	}
+}
+
+// claimAuthentication takes the authentication of the authorization request
+// from the store, if the verifier matches.
+func (s *AuthorizationService) claimAuthentication(ctx context.Context, uuid string, authenticationVerifier string) (*domain.Authentication, *AuthorizationError) {
+	s.logger.Debug("Continuing 'authorization_code' flow", "authorizationId", uuid)
+	// the authentication can only be claimed once, even if the verifier is wrong
+	authentication, err := s.authenticationVerifierStore.GetAndDelete(uuid)
+	if err != nil {
+		s.logger.Warn("Authentication not found, it expired or was replayed", "authorizationId", uuid)
+		return nil, &AuthorizationError{
+			RedirectUri: "",
+			State:       "",
+			ErrorType:   "server_error",
+		}
+	}
+
 	decodedVerifier, err := utils.DecodeBase64([]byte(authenticationVerifier))
 	if err != nil {
-		return &AuthorizationError{
+		return nil, &AuthorizationError{
 			RedirectUri: "",
 			State:       "",
 			ErrorType:   "bad_request",
 		}
 	}
 
-	if string(hashedAuthenticationVerifier) != string(utils.HashShake256(decodedVerifier)) {
-		return &AuthorizationError{
+	if string(authentication.VerifierHash) != string(utils.HashShake256(decodedVerifier)) {
+		return nil, &AuthorizationError{
 			RedirectUri: "",
 			State:       "",
 			ErrorType:   "unauthenticated",
 		}
 	}
 
-	return s.succeed(ctx, uuid)
+	return authentication, nil
 }
 
-func (s *AuthorizationService) succeed(ctx context.Context, uuid string) ResponseUriBuilder {
+func (s *AuthorizationService) succeed(ctx context.Context, uuid string, authentication *domain.Authentication) ResponseUriBuilder {
 	s.logger.Debug("Continuing 'authorization_code' flow", "authorizationId", uuid)
-	store := s.authorizationStore.WithContext(ctx)
-	request, err := store.Get(uuid)
+	request, err := s.authorizationStore.WithContext(ctx).GetAndDelete(uuid)
 	if err != nil {
+		s.logger.Warn("Authorization request not found, it expired or was replayed", "authorizationId", uuid)
 		return &AuthorizationError{
-			RedirectUri: request.RedirectUri,
-			State:       request.State,
+			RedirectUri: "",
+			State:       "",
 			ErrorType:   "server_error",
 		}
 	}
 
-	err = store.Delete(uuid)
-	if err != nil {
-		return &AuthorizationError{
-			RedirectUri: request.RedirectUri,
-			State:       request.State,
-			ErrorType:   "server_error",
+	if request.deviceKey != "" {
+		err = s.deviceService.Approve(ctx, request.deviceKey, authentication)
+		if err != nil {
+			return &AuthorizationError{
+				RedirectUri: s.deviceService.verificationUri,
+				ErrorType:   "access_denied",
+			}
 		}
+		return &DeviceApprovalResponse{VerificationUri: s.deviceService.verificationUri}
 	}
 
-	code := randomString(32)
-	err = s.codeStore.WithContext(ctx).Set(code, request)
+	request.authentication = authentication
+	code := authorizationCodeGenerator.Generate()
+	err = s.codeStore.WithContext(ctx).SetWithTTL(code, request, authorizationCodeLifetime)
 	if err != nil {
 		return &AuthorizationError{
 			RedirectUri: request.RedirectUri,
@@ -201,6 +317,6 @@ func (s *AuthorizationService) succeed(ctx context.Context, uuid string) Respons
 		RedirectUri: request.RedirectUri,
 		Code:        code,
 		State:       request.State,
-		Issuer:      "https://localhost:8080",
+		Issuer:      s.issuer,
 	}
 }
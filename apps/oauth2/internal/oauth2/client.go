@@ -2,22 +2,64 @@ package oauth2
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/jwt"
+)
+
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+
+	// ClientAssertionTypeJWT is the client assertion type of RFC 7523
+	ClientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
 )
 
 type ClientModel struct {
-	ID           string `gorm:"primaryKey"`
-	Scopes       string
-	RedirectURIs string
+	ID                      string `gorm:"primaryKey"`
+	Scopes                  string
+	RedirectURIs            string
+	TokenEndpointAuthMethod string `gorm:"not null;default:none"`
+	SecretHash              string
+	JWKS                    string
+	RotateRefreshTokens     bool `gorm:"not null;default:false"`
 }
 
 type Client struct {
-	ID           string
-	Scopes       []string
-	RedirectURIs []string
+	ID                      string
+	Scopes                  []string
+	RedirectURIs            []string
+	TokenEndpointAuthMethod string
+	secretHash              string
+	JWKS                    *jwt.JWKSet
+	// RotateRefreshTokens issues a new refresh token on every refresh and
+	// invalidates the used one
+	RotateRefreshTokens bool
+}
+
+// IsConfidential reports whether the client is able to authenticate at the
+// token endpoint.
+func (c *Client) IsConfidential() bool {
+	return c.TokenEndpointAuthMethod != AuthMethodNone
+}
+
+func (c *Client) resolveKey(header *jwt.Header) (*jwt.Key, error) {
+	if c.JWKS == nil {
+		return nil, fmt.Errorf("client has no registered keys")
+	}
+	for _, jwk := range c.JWKS.Keys {
+		if jwk.KeyID == header.KeyID || (header.KeyID == "" && len(c.JWKS.Keys) == 1) {
+			return jwk.Key()
+		}
+	}
+	return nil, fmt.Errorf("key %s not registered for client", header.KeyID)
 }
 
 func (c *Client) RestrictScopes(ctx context.Context, scopes []string) []string {
@@ -43,10 +85,17 @@ func (c *Client) ValidateRedirectURI(ctx context.Context, redirectURI string) bo
 
 type ClientRepository = core.Repository[string, *ClientModel]
 
+// AssertionStore remembers the ids of used client assertions until they
+// expire to prevent replays.
+type AssertionStore = core.KeyValueStore[string, time.Time]
+
 type ClientDTO struct {
-	ID           string   `json:"id"`
-	Scopes       []string `json:"scopes"`
-	RedirectURIs []string `json:"redirectURIs"`
+	ID                      string      `json:"id"`
+	Scopes                  []string    `json:"scopes"`
+	RedirectURIs            []string    `json:"redirectURIs"`
+	TokenEndpointAuthMethod string      `json:"tokenEndpointAuthMethod"`
+	JWKS                    *jwt.JWKSet `json:"jwks,omitempty"`
+	RotateRefreshTokens     bool        `json:"rotateRefreshTokens"`
 }
 
 type ClientWithSecretDTO struct {
@@ -54,15 +103,49 @@ type ClientWithSecretDTO struct {
 	Secret string `json:"secret"`
 }
 
+// ClientAuthentication holds the credentials a client presented at the token
+// endpoint.
+type ClientAuthentication struct {
+	Method              string
+	ClientId            string
+	ClientSecret        string
+	ClientAssertionType string
+	ClientAssertion     string
+}
+
 type ClientService struct {
-	repo   ClientRepository
-	logger *slog.Logger
+	repo               ClientRepository
+	assertionAudiences []string
+	assertionStore     AssertionStore
+	secretHasher       *core.SecretHasher
+	logger             *slog.Logger
 }
 
-func NewClientService(repo ClientRepository) *ClientService {
+func NewClientService(repo ClientRepository, assertionAudiences []string, assertionStore AssertionStore, secretHasher *core.SecretHasher) *ClientService {
 	logger := slog.Default().With(slog.String("service", "client"))
 
-	return &ClientService{repo: repo, logger: logger}
+	return &ClientService{repo: repo, assertionAudiences: assertionAudiences, assertionStore: assertionStore, secretHasher: secretHasher, logger: logger}
+}
+
+func toClient(model *ClientModel) (*Client, error) {
+	client := &Client{
+		ID:                      model.ID,
+		Scopes:                  strings.Split(model.Scopes, ","),
+		RedirectURIs:            strings.Split(model.RedirectURIs, ","),
+		TokenEndpointAuthMethod: model.TokenEndpointAuthMethod,
+		secretHash:              model.SecretHash,
+		RotateRefreshTokens:     model.RotateRefreshTokens,
+	}
+	if client.TokenEndpointAuthMethod == "" {
+		client.TokenEndpointAuthMethod = AuthMethodNone
+	}
+	if model.JWKS != "" {
+		client.JWKS = &jwt.JWKSet{}
+		if err := json.Unmarshal([]byte(model.JWKS), client.JWKS); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
 }
 
 func (s *ClientService) FindById(ctx context.Context, id string) (*Client, error) {
@@ -72,11 +155,112 @@ func (s *ClientService) FindById(ctx context.Context, id string) (*Client, error
 	}
 	s.logger.Info("Found client", "client_id", client.ID)
 
-	return &Client{
-		ID:           client.ID,
-		Scopes:       strings.Split(client.Scopes, ","),
-		RedirectURIs: strings.Split(client.RedirectURIs, ","),
-	}, nil
+	return toClient(client)
+}
+
+// rehashSecret replaces a secret hash created with a previous version. The
+// client is still authenticated if the hash cannot be replaced.
+func (s *ClientService) rehashSecret(ctx context.Context, client *Client, secret *core.SecretValue) {
+	model, err := s.repo.FindById(ctx, client.ID)
+	if err == nil {
+		model.SecretHash = secret.String()
+		err = s.repo.Update(ctx, model)
+	}
+	if err != nil {
+		s.logger.Error("Failed to rehash client secret", "err", err, "client_id", client.ID)
+		return
+	}
+	client.secretHash = model.SecretHash
+	s.logger.Info("Rehashed client secret", "client_id", client.ID)
+}
+
+// Authenticate verifies the credentials presented by a client at the token
+// endpoint. The method used must be the one registered for the client.
+func (s *ClientService) Authenticate(ctx context.Context, authentication *ClientAuthentication) (*Client, error) {
+	if authentication.ClientId == "" && authentication.Method == AuthMethodPrivateKeyJWT {
+		// the client id may be omitted in favor of the subject of the assertion, which is verified below
+		claims := &jwt.RegisteredClaims{}
+		if _, err := jwt.ParseUnverified(authentication.ClientAssertion, claims); err == nil {
+			authentication.ClientId = claims.Subject
+		}
+	}
+	if authentication.ClientId == "" {
+		return nil, fmt.Errorf("client id missing")
+	}
+
+	client, err := s.FindById(ctx, authentication.ClientId)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.TokenEndpointAuthMethod != authentication.Method {
+		return nil, fmt.Errorf("client must authenticate with %s", client.TokenEndpointAuthMethod)
+	}
+
+	switch authentication.Method {
+	case AuthMethodNone:
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost:
+		secret := s.secretHasher.Secret(authentication.ClientSecret)
+		if !secret.Verify(client.secretHash) {
+			return nil, fmt.Errorf("invalid client secret")
+		}
+		if secret.NeedsRehash(client.secretHash) {
+			s.rehashSecret(ctx, client, secret)
+		}
+	case AuthMethodPrivateKeyJWT:
+		if authentication.ClientAssertionType != ClientAssertionTypeJWT {
+			return nil, fmt.Errorf("unsupported client assertion type")
+		}
+		if err := s.verifyAssertion(ctx, client, authentication.ClientAssertion); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported authentication method")
+	}
+
+	s.logger.Info("Authenticated client", "client_id", client.ID, "method", authentication.Method)
+	return client, nil
+}
+
+// verifyAssertion validates a JWT client assertion according to RFC 7523,
+// section 3.
+func (s *ClientService) verifyAssertion(ctx context.Context, client *Client, assertion string) error {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.Parse(assertion, claims, client.resolveKey)
+	if err != nil {
+		return err
+	}
+
+	if claims.Issuer != client.ID || claims.Subject != client.ID {
+		return fmt.Errorf("assertion not issued by client")
+	}
+	audienceFound := false
+	for _, audience := range s.assertionAudiences {
+		audienceFound = audienceFound || claims.Audience.Contains(audience)
+	}
+	if !audienceFound {
+		return fmt.Errorf("assertion not issued for this server")
+	}
+	if claims.ExpiresAt == 0 || claims.ID == "" {
+		return fmt.Errorf("assertion must contain exp and jti")
+	}
+	now := time.Now()
+	if err := claims.ValidateTime(now); err != nil {
+		return err
+	}
+
+	// the id is remembered atomically, so that concurrent requests cannot
+	// both use the same assertion. The ttl is positive, as the assertion has
+	// not expired at now.
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	unused, err := s.assertionStore.WithContext(ctx).SetIfAbsent(client.ID+":"+claims.ID, expiresAt, expiresAt.Sub(now))
+	if err != nil {
+		return err
+	}
+	if !unused {
+		return fmt.Errorf("assertion has already been used")
+	}
+	return nil
 }
 
 func (s *ClientService) List(ctx context.Context) ([]*Client, error) {
@@ -88,33 +272,64 @@ func (s *ClientService) List(ctx context.Context) ([]*Client, error) {
 
 	var results []*Client
 	for _, client := range clients {
-		results = append(results, &Client{
-			ID:           client.ID,
-			Scopes:       strings.Split(client.Scopes, ","),
-			RedirectURIs: strings.Split(client.RedirectURIs, ","),
-		})
+		result, err := toClient(client)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
 	}
 	return results, nil
 }
 
-func (s *ClientService) Create(ctx context.Context, dto ClientDTO) (*Client, error) {
+// Create registers a new client. For clients authenticating with a client
+// secret, the secret is generated and returned once; only its hash is stored.
+func (s *ClientService) Create(ctx context.Context, dto ClientDTO) (*Client, string, error) {
 	clientModel := &ClientModel{
-		ID:           dto.ID,
-		Scopes:       strings.Join(dto.Scopes, ","),
-		RedirectURIs: strings.Join(dto.RedirectURIs, ","),
+		ID:                      dto.ID,
+		Scopes:                  strings.Join(dto.Scopes, ","),
+		RedirectURIs:            strings.Join(dto.RedirectURIs, ","),
+		TokenEndpointAuthMethod: dto.TokenEndpointAuthMethod,
+		RotateRefreshTokens:     dto.RotateRefreshTokens,
+	}
+	if clientModel.TokenEndpointAuthMethod == "" {
+		clientModel.TokenEndpointAuthMethod = AuthMethodNone
 	}
+
+	var secret string
+	switch clientModel.TokenEndpointAuthMethod {
+	case AuthMethodNone:
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost:
+		secret = clientSecretGenerator.Generate()
+		clientModel.SecretHash = s.secretHasher.Secret(secret).String()
+	case AuthMethodPrivateKeyJWT:
+		if dto.JWKS == nil || len(dto.JWKS.Keys) == 0 {
+			return nil, "", fmt.Errorf("private_key_jwt requires a key set")
+		}
+		for _, jwk := range dto.JWKS.Keys {
+			if _, err := jwk.Key(); err != nil {
+				return nil, "", err
+			}
+		}
+		jwks, err := json.Marshal(dto.JWKS)
+		if err != nil {
+			return nil, "", err
+		}
+		clientModel.JWKS = string(jwks)
+	default:
+		return nil, "", fmt.Errorf("unsupported token endpoint auth method %s", clientModel.TokenEndpointAuthMethod)
+	}
+
 	err := s.repo.Save(ctx, clientModel)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	s.logger.Info("Created client", "client_id", dto.ID)
+	s.logger.Info("Created client", "client_id", dto.ID, "method", clientModel.TokenEndpointAuthMethod)
 
-	client := &Client{
-		ID:           dto.ID,
-		Scopes:       dto.Scopes,
-		RedirectURIs: dto.RedirectURIs,
+	client, err := toClient(clientModel)
+	if err != nil {
+		return nil, "", err
 	}
-	return client, nil
+	return client, secret, nil
 }
 
 func (s *ClientService) Update(ctx context.Context, dto ClientDTO) (*Client, error) {
@@ -132,11 +347,7 @@ func (s *ClientService) Update(ctx context.Context, dto ClientDTO) (*Client, err
 	}
 	s.logger.Info("Updated client", "client_id", dto.ID)
 
-	return &Client{
-		ID:           dto.ID,
-		Scopes:       dto.Scopes,
-		RedirectURIs: dto.RedirectURIs,
-	}, nil
+	return toClient(client)
 }
 
 func (s *ClientService) Delete(ctx context.Context, id string) error {
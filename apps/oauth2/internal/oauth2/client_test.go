@@ -0,0 +1,335 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+type memoryClientRepository struct {
+	mutex   sync.Mutex
+	clients map[string]ClientModel
+}
+
+func (r *memoryClientRepository) FindAll(ctx context.Context) ([]*ClientModel, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var clients []*ClientModel
+	for _, client := range r.clients {
+		client := client
+		clients = append(clients, &client)
+	}
+	return clients, nil
+}
+
+func (r *memoryClientRepository) FindById(ctx context.Context, id string) (*ClientModel, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("client not found")
+	}
+	return &client, nil
+}
+
+func (r *memoryClientRepository) Save(ctx context.Context, client *ClientModel) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.clients[client.ID] = *client
+	return nil
+}
+
+func (r *memoryClientRepository) Update(ctx context.Context, client *ClientModel) error {
+	return r.Save(ctx, client)
+}
+
+func (r *memoryClientRepository) DeleteById(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.clients, id)
+	return nil
+}
+
+func newTestClientService(t *testing.T) *ClientService {
+	t.Helper()
+	assertionStore := core.NewInMemoryKeyValueStore[time.Time]()
+	t.Cleanup(assertionStore.Close)
+	repo := &memoryClientRepository{clients: make(map[string]ClientModel)}
+	return NewClientService(repo, []string{testIssuer, testIssuer + "/token"}, assertionStore, newTestSecretHasher(t))
+}
+
+func newTestAssertion(t *testing.T, key *jwt.Key, claims *jwt.RegisteredClaims) string {
+	t.Helper()
+	assertion, err := jwt.Sign(key, "JWT", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assertion
+}
+
+func TestClientServiceAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	service := newTestClientService(t)
+
+	_, _, err := service.Create(ctx, ClientDTO{ID: "public", TokenEndpointAuthMethod: AuthMethodNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, basicSecret, err := service.Create(ctx, ClientDTO{ID: "basic", TokenEndpointAuthMethod: AuthMethodClientSecretBasic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, postSecret, err := service.Create(ctx, ClientDTO{ID: "post", TokenEndpointAuthMethod: AuthMethodClientSecretPost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := newTestKeySet(t).SigningKey(ctx)
+	_, _, err = service.Create(ctx, ClientDTO{ID: "jwt", TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT, JWKS: &jwt.JWKSet{Keys: []jwt.JWK{key.JWK()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := newTestKeySet(t).SigningKey(ctx)
+
+	claims := func(modify func(claims *jwt.RegisteredClaims)) *jwt.RegisteredClaims {
+		claims := &jwt.RegisteredClaims{
+			Issuer:    "jwt",
+			Subject:   "jwt",
+			Audience:  jwt.Audience{testIssuer + "/token"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			ID:        uuid.New().String(),
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		return claims
+	}
+	assertion := func(key *jwt.Key, claims *jwt.RegisteredClaims) *ClientAuthentication {
+		return &ClientAuthentication{
+			Method:              AuthMethodPrivateKeyJWT,
+			ClientId:            "jwt",
+			ClientAssertionType: ClientAssertionTypeJWT,
+			ClientAssertion:     newTestAssertion(t, key, claims),
+		}
+	}
+
+	tests := []struct {
+		name           string
+		authentication *ClientAuthentication
+		wantErr        bool
+	}{
+		{
+			name:           "Public client",
+			authentication: &ClientAuthentication{Method: AuthMethodNone, ClientId: "public"},
+		},
+		{
+			name:           "Public client with secret",
+			authentication: &ClientAuthentication{Method: AuthMethodClientSecretPost, ClientId: "public", ClientSecret: "secret"},
+			wantErr:        true,
+		},
+		{
+			name:           "Missing client id",
+			authentication: &ClientAuthentication{Method: AuthMethodNone},
+			wantErr:        true,
+		},
+		{
+			name:           "Unknown client",
+			authentication: &ClientAuthentication{Method: AuthMethodNone, ClientId: "unknown"},
+			wantErr:        true,
+		},
+		{
+			name:           "Client secret basic",
+			authentication: &ClientAuthentication{Method: AuthMethodClientSecretBasic, ClientId: "basic", ClientSecret: basicSecret},
+		},
+		{
+			name:           "Client secret basic with wrong secret",
+			authentication: &ClientAuthentication{Method: AuthMethodClientSecretBasic, ClientId: "basic", ClientSecret: postSecret},
+			wantErr:        true,
+		},
+		{
+			name:           "Client secret basic without authentication",
+			authentication: &ClientAuthentication{Method: AuthMethodNone, ClientId: "basic"},
+			wantErr:        true,
+		},
+		{
+			name:           "Client secret post",
+			authentication: &ClientAuthentication{Method: AuthMethodClientSecretPost, ClientId: "post", ClientSecret: postSecret},
+		},
+		{
+			name:           "Client secret post with other method",
+			authentication: &ClientAuthentication{Method: AuthMethodClientSecretBasic, ClientId: "post", ClientSecret: postSecret},
+			wantErr:        true,
+		},
+		{
+			name:           "Private key JWT",
+			authentication: assertion(key, claims(nil)),
+		},
+		{
+			name: "Private key JWT without client id",
+			authentication: func() *ClientAuthentication {
+				authentication := assertion(key, claims(nil))
+				authentication.ClientId = ""
+				return authentication
+			}(),
+		},
+		{
+			name: "Private key JWT with other assertion type",
+			authentication: func() *ClientAuthentication {
+				authentication := assertion(key, claims(nil))
+				authentication.ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:saml2-bearer"
+				return authentication
+			}(),
+			wantErr: true,
+		},
+		{
+			name:           "Private key JWT signed with other key",
+			authentication: assertion(otherKey, claims(nil)),
+			wantErr:        true,
+		},
+		{
+			name:           "Private key JWT issued by other client",
+			authentication: assertion(key, claims(func(claims *jwt.RegisteredClaims) { claims.Issuer = "basic" })),
+			wantErr:        true,
+		},
+		{
+			name:           "Private key JWT for other audience",
+			authentication: assertion(key, claims(func(claims *jwt.RegisteredClaims) { claims.Audience = jwt.Audience{"https://other.example.com"} })),
+			wantErr:        true,
+		},
+		{
+			name:           "Private key JWT without jti",
+			authentication: assertion(key, claims(func(claims *jwt.RegisteredClaims) { claims.ID = "" })),
+			wantErr:        true,
+		},
+		{
+			name:           "Private key JWT without expiry",
+			authentication: assertion(key, claims(func(claims *jwt.RegisteredClaims) { claims.ExpiresAt = 0 })),
+			wantErr:        true,
+		},
+		{
+			name:           "Expired private key JWT",
+			authentication: assertion(key, claims(func(claims *jwt.RegisteredClaims) { claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() })),
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := service.Authenticate(ctx, tt.authentication)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && client.ID != tt.authentication.ClientId {
+				t.Errorf("Authenticate() client = %s, want %s", client.ID, tt.authentication.ClientId)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionReplay(t *testing.T) {
+	ctx := context.Background()
+	service := newTestClientService(t)
+	key, _ := newTestKeySet(t).SigningKey(ctx)
+	client := &Client{ID: "jwt", TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT, JWKS: &jwt.JWKSet{Keys: []jwt.JWK{key.JWK()}}}
+
+	assertion := newTestAssertion(t, key, &jwt.RegisteredClaims{
+		Issuer:    "jwt",
+		Subject:   "jwt",
+		Audience:  jwt.Audience{testIssuer},
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		ID:        uuid.New().String(),
+	})
+
+	// concurrent requests with the same assertion must not both succeed
+	const workers = 8
+	var errs [workers]error
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			errs[worker] = service.verifyAssertion(ctx, client, assertion)
+		}(worker)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("verifyAssertion() succeeded %d times, want 1", succeeded)
+	}
+}
+
+func TestClientCredentialsToken(t *testing.T) {
+	ctx := context.Background()
+	service := newTestTokenService(t)
+	confidential := &Client{ID: "service", Scopes: []string{"read", "write"}, TokenEndpointAuthMethod: AuthMethodClientSecretBasic}
+	request := func(scope string) *ClientCredentialsTokenRequest {
+		return &ClientCredentialsTokenRequest{tokenRequest: tokenRequest{GrantType: "client_credentials"}, Scope: scope}
+	}
+
+	t.Run("All client scopes", func(t *testing.T) {
+		response, err := service.Token(ctx, confidential, request(""))
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if response.Scope != "read write" {
+			t.Errorf("Scope = %s, want read write", response.Scope)
+		}
+		if response.RefreshToken != "" || response.IDToken != "" {
+			t.Errorf("Token() = %+v, want access token only", response)
+		}
+		grant, validateErr := service.accessTokenHandler.Validate(ctx, response.AccessToken)
+		if validateErr != nil || grant.SubjectId != "service" {
+			t.Errorf("Validate() = %+v, error = %v", grant, validateErr)
+		}
+	})
+
+	t.Run("Requested scope", func(t *testing.T) {
+		response, err := service.Token(ctx, confidential, request("read"))
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if response.Scope != "read" {
+			t.Errorf("Scope = %s, want read", response.Scope)
+		}
+	})
+
+	t.Run("Scope not allowed", func(t *testing.T) {
+		_, err := service.Token(ctx, confidential, request("read admin"))
+		if err == nil || err.ErrorType != "invalid_scope" {
+			t.Errorf("Token() error = %v, want invalid_scope", err)
+		}
+	})
+
+	t.Run("OpenID", func(t *testing.T) {
+		withOpenID := &Client{ID: "service", Scopes: []string{OpenIDScope, "read"}, TokenEndpointAuthMethod: AuthMethodClientSecretBasic}
+		response, err := service.Token(ctx, withOpenID, request(""))
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if response.Scope != "read" || response.IDToken != "" {
+			t.Errorf("Token() = %+v, want access token for read only", response)
+		}
+
+		_, err = service.Token(ctx, withOpenID, request("openid read"))
+		if err == nil || err.ErrorType != "invalid_scope" {
+			t.Errorf("Token() with scope openid error = %v, want invalid_scope", err)
+		}
+	})
+
+	t.Run("Public client", func(t *testing.T) {
+		_, err := service.Token(ctx, testClient, request(""))
+		if err == nil || err.ErrorType != "unauthorized_client" {
+			t.Errorf("Token() error = %v, want unauthorized_client", err)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package oauth2
+
+import (
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+)
+
+// authorizationRequestData is the encoded form of an AuthorizationRequest,
+// which includes its unexported fields.
+type authorizationRequestData struct {
+	ID                 uuid.UUID
+	AuthenticationCode []byte
+	Authentication     *domain.Authentication
+	DeviceKey          string
+	ClientId           string
+	RedirectUri        string
+	ResponseType       string
+	Scope              string
+	State              string
+	Nonce              string
+	CodeChallenge      string
+	CodeMethod         string
+}
+
+// NewAuthorizationRequestCodec returns a codec for authorization requests
+// in the given format, for use with an AuthorizationStore or CodeStore.
+func NewAuthorizationRequestCodec(format string) (core.Codec[*AuthorizationRequest], error) {
+	codec, err := core.NewCodec[*authorizationRequestData](format)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewMappedCodec[*AuthorizationRequest, *authorizationRequestData](
+		codec,
+		func(request *AuthorizationRequest) *authorizationRequestData {
+			return &authorizationRequestData{
+				ID:                 request.id,
+				AuthenticationCode: request.authenticationCode,
+				Authentication:     request.authentication,
+				DeviceKey:          request.deviceKey,
+				ClientId:           request.ClientId,
+				RedirectUri:        request.RedirectUri,
+				ResponseType:       request.ResponseType,
+				Scope:              request.Scope,
+				State:              request.State,
+				Nonce:              request.Nonce,
+				CodeChallenge:      request.CodeChallenge,
+				CodeMethod:         request.CodeMethod,
+			}
+		},
+		func(data *authorizationRequestData) *AuthorizationRequest {
+			return &AuthorizationRequest{
+				id:                 data.ID,
+				authenticationCode: data.AuthenticationCode,
+				authentication:     data.Authentication,
+				deviceKey:          data.DeviceKey,
+				ClientId:           data.ClientId,
+				RedirectUri:        data.RedirectUri,
+				ResponseType:       data.ResponseType,
+				Scope:              data.Scope,
+				State:              data.State,
+				Nonce:              data.Nonce,
+				CodeChallenge:      data.CodeChallenge,
+				CodeMethod:         data.CodeMethod,
+			}
+		},
+	), nil
+}
+
+// authorizationGrantData is the encoded form of an AuthorizationGrant. The
+// JSON representation of the grant itself omits the authentication details.
+type authorizationGrantData struct {
+	ID                    uuid.UUID
+	Scope                 string
+	ClientId              string
+	SubjectId             string
+	IssuedAt              time.Time
+	ExpiresAt             time.Time
+	NotBefore             time.Time
+	Nonce                 string
+	AuthenticatedAt       time.Time
+	AuthenticationMethods []string
+	AuthenticationContext string
+}
+
+// NewAuthorizationGrantCodec returns a codec for grants in the given format,
+// for use with a TokenStore.
+func NewAuthorizationGrantCodec(format string) (core.Codec[*AuthorizationGrant], error) {
+	codec, err := core.NewCodec[*authorizationGrantData](format)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewMappedCodec[*AuthorizationGrant, *authorizationGrantData](
+		codec,
+		func(grant *AuthorizationGrant) *authorizationGrantData {
+			return &authorizationGrantData{
+				ID:                    grant.ID,
+				Scope:                 grant.Scope,
+				ClientId:              grant.ClientId,
+				SubjectId:             grant.SubjectId,
+				IssuedAt:              time.Time(grant.IssuedAt),
+				ExpiresAt:             time.Time(grant.ExpiresAt),
+				NotBefore:             time.Time(grant.NotBefore),
+				Nonce:                 grant.Nonce,
+				AuthenticatedAt:       grant.AuthenticatedAt,
+				AuthenticationMethods: grant.AuthenticationMethods,
+				AuthenticationContext: grant.AuthenticationContext,
+			}
+		},
+		func(data *authorizationGrantData) *AuthorizationGrant {
+			return &AuthorizationGrant{
+				ID:                    data.ID,
+				Scope:                 data.Scope,
+				ClientId:              data.ClientId,
+				SubjectId:             data.SubjectId,
+				IssuedAt:              timestamp(data.IssuedAt),
+				ExpiresAt:             timestamp(data.ExpiresAt),
+				NotBefore:             timestamp(data.NotBefore),
+				Nonce:                 data.Nonce,
+				AuthenticatedAt:       data.AuthenticatedAt,
+				AuthenticationMethods: data.AuthenticationMethods,
+				AuthenticationContext: data.AuthenticationContext,
+			}
+		},
+	), nil
+}
+
+// deviceAuthorizationData is the encoded form of a DeviceAuthorization,
+// which includes its unexported fields.
+type deviceAuthorizationData struct {
+	ID             uuid.UUID
+	Authentication *domain.Authentication
+	ClientId       string
+	Scope          string
+	UserCode       string
+	Status         string
+	ExpiresAt      time.Time
+	Interval       time.Duration
+	LastPolledAt   time.Time
+}
+
+// NewDeviceAuthorizationCodec returns a codec for device authorizations in
+// the given format, for use with a DeviceStore.
+func NewDeviceAuthorizationCodec(format string) (core.Codec[*DeviceAuthorization], error) {
+	codec, err := core.NewCodec[*deviceAuthorizationData](format)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewMappedCodec[*DeviceAuthorization, *deviceAuthorizationData](
+		codec,
+		func(authorization *DeviceAuthorization) *deviceAuthorizationData {
+			return &deviceAuthorizationData{
+				ID:             authorization.id,
+				Authentication: authorization.authentication,
+				ClientId:       authorization.ClientId,
+				Scope:          authorization.Scope,
+				UserCode:       authorization.UserCode,
+				Status:         authorization.Status,
+				ExpiresAt:      authorization.ExpiresAt,
+				Interval:       authorization.Interval,
+				LastPolledAt:   authorization.LastPolledAt,
+			}
+		},
+		func(data *deviceAuthorizationData) *DeviceAuthorization {
+			return &DeviceAuthorization{
+				id:             data.ID,
+				authentication: data.Authentication,
+				ClientId:       data.ClientId,
+				Scope:          data.Scope,
+				UserCode:       data.UserCode,
+				Status:         data.Status,
+				ExpiresAt:      data.ExpiresAt,
+				Interval:       data.Interval,
+				LastPolledAt:   data.LastPolledAt,
+			}
+		},
+	), nil
+}
@@ -0,0 +1,329 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/Untanky/modern-auth/internal/utils"
+	"github.com/google/uuid"
+)
+
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+const (
+	deviceCodeLifetime = 10 * time.Minute
+	// slowDownIncrement is added to the polling interval each time a client
+	// polls too fast (RFC 8628, section 3.5)
+	slowDownIncrement = 5 * time.Second
+	// userCodeAlphabet omits vowels and similar looking characters (RFC 8628, section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeAttempts is the number of wrong user codes a source may enter
+	// within the lifetime of a device code, since user codes are short enough
+	// to be guessed otherwise (RFC 8628, section 5.1)
+	userCodeAttempts = 10
+)
+
+// DeviceAuthorization is the state of a device authorization request while
+// the user approves it on a secondary device.
+type DeviceAuthorization struct {
+	id             uuid.UUID
+	authentication *domain.Authentication
+	ClientId       string
+	Scope          string
+	UserCode       string
+	Status         string
+	ExpiresAt      time.Time
+	Interval       time.Duration
+	LastPolledAt   time.Time
+}
+
+type DeviceAuthorizationRequest struct {
+	Scope string `form:"scope"`
+}
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type DeviceCodeTokenRequest struct {
+	tokenRequest
+	DeviceCode string `form:"device_code" binding:"required"`
+}
+
+// DeviceStore holds device authorizations by the hash of their device code.
+type DeviceStore = core.KeyValueStore[string, *DeviceAuthorization]
+
+// UserCodeStore maps user codes to the hash of the device code.
+type UserCodeStore = core.KeyValueStore[string, string]
+
+type DeviceAuthorizationService struct {
+	deviceStore     DeviceStore
+	userCodeStore   UserCodeStore
+	attempts        *AttemptLimiter
+	secretHasher    *core.SecretHasher
+	verificationUri string
+	interval        time.Duration
+	logger          *slog.Logger
+}
+
+func NewDeviceAuthorizationService(deviceStore DeviceStore, userCodeStore UserCodeStore, attemptStore AttemptStore, secretHasher *core.SecretHasher, verificationUri string, interval time.Duration) *DeviceAuthorizationService {
+	logger := slog.Default().With(slog.String("service", "device-authorization"))
+
+	return &DeviceAuthorizationService{
+		deviceStore:     deviceStore,
+		userCodeStore:   userCodeStore,
+		attempts:        NewAttemptLimiter(attemptStore, userCodeAttempts, deviceCodeLifetime),
+		secretHasher:    secretHasher,
+		verificationUri: verificationUri,
+		interval:        interval,
+		logger:          logger,
+	}
+}
+
+// Initiate starts a device authorization for the client (RFC 8628, section 3.1).
+func (s *DeviceAuthorizationService) Initiate(ctx context.Context, client *Client, request *DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error) {
+	scopes := strings.Fields(request.Scope)
+	if len(client.RestrictScopes(ctx, scopes)) != len(scopes) {
+		return nil, fmt.Errorf("scope not allowed for client")
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode := deviceCodeGenerator.Generate()
+	userCode := generateUserCode()
+	deviceKey := s.secretHasher.Secret(deviceCode).String()
+	authorization := &DeviceAuthorization{
+		id:        id,
+		ClientId:  client.ID,
+		Scope:     request.Scope,
+		UserCode:  userCode,
+		Status:    DeviceAuthorizationPending,
+		ExpiresAt: time.Now().Add(deviceCodeLifetime),
+		Interval:  s.interval,
+	}
+
+	err = s.deviceStore.WithContext(ctx).SetWithTTL(deviceKey, authorization, deviceCodeLifetime)
+	if err != nil {
+		return nil, err
+	}
+	err = s.userCodeStore.WithContext(ctx).SetWithTTL(normalizeUserCode(userCode), deviceKey, deviceCodeLifetime)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("Initialized device authorization", "client_id", client.ID, "authorization_id", id)
+
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         s.verificationUri,
+		VerificationURIComplete: fmt.Sprintf("%s?user_code=%s", s.verificationUri, userCode),
+		ExpiresIn:               int(deviceCodeLifetime.Seconds()),
+		Interval:                int(s.interval.Seconds()),
+	}, nil
+}
+
+// FindByUserCode resolves the pending device authorization a user entered
+// the code of. It returns the key of the device authorization.
+func (s *DeviceAuthorizationService) FindByUserCode(ctx context.Context, userCode string) (string, *DeviceAuthorization, error) {
+	deviceKey, err := s.userCodeStore.WithContext(ctx).Get(normalizeUserCode(userCode))
+	if err != nil {
+		return "", nil, err
+	}
+	authorization, err := s.deviceStore.WithContext(ctx).Get(deviceKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if authorization.Status != DeviceAuthorizationPending || !time.Now().Before(authorization.ExpiresAt) {
+		return "", nil, fmt.Errorf("device authorization is no longer pending")
+	}
+	return deviceKey, authorization, nil
+}
+
+// VerifyUserCode resolves the pending device authorization like
+// FindByUserCode, but limits the wrong user codes entered from the source,
+// e.g. the IP address of the user.
+func (s *DeviceAuthorizationService) VerifyUserCode(ctx context.Context, userCode string, source string) (string, *DeviceAuthorization, error) {
+	err := s.attempts.Check(ctx, source)
+	if err != nil {
+		s.logger.Warn("Security event: user code attempts exceeded",
+			"event", "user_code_attempts_exceeded",
+			"source", source,
+		)
+		return "", nil, err
+	}
+	deviceKey, authorization, err := s.FindByUserCode(ctx, userCode)
+	if err != nil {
+		if failErr := s.attempts.Fail(ctx, source); failErr != nil {
+			s.logger.Error("Failed to count wrong user code", "err", failErr)
+		}
+		return "", nil, err
+	}
+	return deviceKey, authorization, nil
+}
+
+// Approve marks the device authorization as approved by the authenticated
+// user, so that the next poll of the device succeeds.
+func (s *DeviceAuthorizationService) Approve(ctx context.Context, deviceKey string, authentication *domain.Authentication) error {
+	store := s.deviceStore.WithContext(ctx)
+	authorization, err := store.Get(deviceKey)
+	if err != nil {
+		return err
+	}
+	if authorization.Status != DeviceAuthorizationPending {
+		return fmt.Errorf("device authorization is no longer pending")
+	}
+
+	approved := *authorization
+	approved.Status = DeviceAuthorizationApproved
+	approved.authentication = authentication
+	// the authorization may only be approved once, even on concurrent requests
+	swapped, err := store.CompareAndSet(deviceKey, authorization, &approved)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		s.logger.Warn("Device authorization changed concurrently, rejecting approval", "authorization_id", authorization.id)
+		return fmt.Errorf("device authorization is no longer pending")
+	}
+	err = s.userCodeStore.WithContext(ctx).Delete(normalizeUserCode(authorization.UserCode))
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Approved device authorization", "client_id", authorization.ClientId, "authorization_id", authorization.id)
+	return nil
+}
+
+// Deny marks the device authorization as denied by the user, so that the
+// next poll of the device fails with access_denied.
+func (s *DeviceAuthorizationService) Deny(ctx context.Context, deviceKey string) error {
+	store := s.deviceStore.WithContext(ctx)
+	authorization, err := store.Get(deviceKey)
+	if err != nil {
+		return err
+	}
+	if authorization.Status != DeviceAuthorizationPending {
+		return fmt.Errorf("device authorization is no longer pending")
+	}
+
+	denied := *authorization
+	denied.Status = DeviceAuthorizationDenied
+	swapped, err := store.CompareAndSet(deviceKey, authorization, &denied)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return fmt.Errorf("device authorization is no longer pending")
+	}
+	err = s.userCodeStore.WithContext(ctx).Delete(normalizeUserCode(authorization.UserCode))
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Denied device authorization", "client_id", authorization.ClientId, "authorization_id", authorization.id)
+	return nil
+}
+
+// Poll handles a token request of a device (RFC 8628, section 3.4 and 3.5).
+func (s *DeviceAuthorizationService) Poll(ctx context.Context, client *Client, deviceCode string) (*DeviceAuthorization, *TokenError) {
+	store := s.deviceStore.WithContext(ctx)
+	deviceKey := s.secretHasher.Secret(deviceCode).String()
+	authorization, err := store.Get(deviceKey)
+	if err != nil {
+		return nil, &TokenError{
+			ErrorType:        "invalid_grant",
+			ErrorDescription: "device code not found",
+		}
+	}
+
+	if authorization.ClientId != client.ID {
+		return nil, &TokenError{
+			ErrorType:        "invalid_grant",
+			ErrorDescription: "client id does not match",
+		}
+	}
+
+	now := time.Now()
+	if !now.Before(authorization.ExpiresAt) {
+		store.Delete(deviceKey)
+		s.userCodeStore.WithContext(ctx).Delete(normalizeUserCode(authorization.UserCode))
+		return nil, &TokenError{
+			ErrorType:        "expired_token",
+			ErrorDescription: "device code expired",
+		}
+	}
+
+	if authorization.Status == DeviceAuthorizationApproved {
+		// the device code can only be redeemed once
+		authorization, err = store.GetAndDelete(deviceKey)
+		if err != nil {
+			s.logger.Warn("Device code redeemed concurrently", "client_id", client.ID)
+			return nil, &TokenError{
+				ErrorType:        "invalid_grant",
+				ErrorDescription: "device code already redeemed",
+			}
+		}
+		return authorization, nil
+	}
+
+	if authorization.Status == DeviceAuthorizationDenied {
+		store.Delete(deviceKey)
+		return nil, &TokenError{
+			ErrorType:        "access_denied",
+			ErrorDescription: "user denied the device",
+		}
+	}
+
+	tokenError := &TokenError{
+		ErrorType:        "authorization_pending",
+		ErrorDescription: "user has not yet approved the device",
+	}
+	polled := *authorization
+	if now.Sub(authorization.LastPolledAt) < authorization.Interval {
+		polled.Interval += slowDownIncrement
+		tokenError = &TokenError{
+			ErrorType:        "slow_down",
+			ErrorDescription: fmt.Sprintf("polling interval increased to %d seconds", int(polled.Interval.Seconds())),
+		}
+	}
+	polled.LastPolledAt = now
+	// a concurrent approval must not be overwritten, the next poll picks it up
+	_, err = store.CompareAndSet(deviceKey, authorization, &polled)
+	if err != nil {
+		return nil, &TokenError{
+			ErrorType:        "server_error",
+			ErrorDescription: "failed to update device authorization",
+		}
+	}
+	return nil, tokenError
+}
+
+func generateUserCode() string {
+	code := utils.RandomStringFromAlphabet(userCodeAlphabet, 8)
+	return code[:4] + "-" + code[4:]
+}
+
+// normalizeUserCode removes formatting the user may have typed, as the code
+// is case-insensitive and dashes are only for readability.
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.ReplaceAll(userCode, "-", "")
+	return strings.ReplaceAll(userCode, " ", "")
+}
@@ -0,0 +1,266 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+)
+
+type testDeviceService struct {
+	*DeviceAuthorizationService
+	deviceStore *core.InMemoryKeyValueStore[*DeviceAuthorization]
+}
+
+func newTestDeviceService(t *testing.T) *testDeviceService {
+	t.Helper()
+	deviceStore := core.NewInMemoryKeyValueStore[*DeviceAuthorization]()
+	userCodeStore := core.NewInMemoryKeyValueStore[string]()
+	attemptStore := core.NewInMemoryKeyValueStore[int]()
+	t.Cleanup(deviceStore.Close)
+	t.Cleanup(userCodeStore.Close)
+	t.Cleanup(attemptStore.Close)
+	return &testDeviceService{
+		DeviceAuthorizationService: NewDeviceAuthorizationService(deviceStore, userCodeStore, attemptStore, newTestSecretHasher(t), "https://example.com/device", time.Second),
+		deviceStore:                deviceStore,
+	}
+}
+
+// initiate starts a device authorization and returns its device code and the
+// key the user approves or denies it by.
+func (s *testDeviceService) initiate(t *testing.T) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	response, err := s.Initiate(ctx, testClient, &DeviceAuthorizationRequest{Scope: "api"})
+	if err != nil {
+		t.Fatalf("Initiate() error = %v", err)
+	}
+	deviceKey, _, err := s.FindByUserCode(ctx, response.UserCode)
+	if err != nil {
+		t.Fatalf("FindByUserCode() error = %v", err)
+	}
+	return response.DeviceCode, deviceKey
+}
+
+func TestDeviceAuthorizationInitiate(t *testing.T) {
+	ctx := context.Background()
+	service := newTestDeviceService(t)
+
+	response, err := service.Initiate(ctx, testClient, &DeviceAuthorizationRequest{Scope: "openid api"})
+	if err != nil {
+		t.Fatalf("Initiate() error = %v", err)
+	}
+	if response.VerificationURIComplete != "https://example.com/device?user_code="+response.UserCode {
+		t.Errorf("VerificationURIComplete = %s", response.VerificationURIComplete)
+	}
+	if response.Interval != 1 || response.ExpiresIn != int(deviceCodeLifetime.Seconds()) {
+		t.Errorf("Initiate() = %+v", response)
+	}
+
+	// users may type the code in lower case and without the dash
+	typed := normalizeUserCode(response.UserCode)
+	_, authorization, err := service.FindByUserCode(ctx, typed[:4]+" "+typed[4:])
+	if err != nil {
+		t.Fatalf("FindByUserCode() error = %v", err)
+	}
+	if authorization.ClientId != testClient.ID || authorization.Status != DeviceAuthorizationPending {
+		t.Errorf("FindByUserCode() = %+v", authorization)
+	}
+
+	_, err = service.Initiate(ctx, testClient, &DeviceAuthorizationRequest{Scope: "admin"})
+	if err == nil {
+		t.Errorf("Initiate() with scope not allowed for the client succeeded")
+	}
+}
+
+func TestDeviceAuthorizationVerifyUserCode(t *testing.T) {
+	ctx := context.Background()
+	service := newTestDeviceService(t)
+	response, err := service.Initiate(ctx, testClient, &DeviceAuthorizationRequest{Scope: "api"})
+	if err != nil {
+		t.Fatalf("Initiate() error = %v", err)
+	}
+
+	for i := 0; i < userCodeAttempts; i++ {
+		_, _, err = service.VerifyUserCode(ctx, "WRONGCODE", "attacker")
+		if err == nil {
+			t.Fatalf("VerifyUserCode() with wrong user code succeeded")
+		}
+	}
+	// once the limit is reached, even the right user code is rejected
+	_, _, err = service.VerifyUserCode(ctx, response.UserCode, "attacker")
+	if !errors.Is(err, errTooManyAttempts) {
+		t.Errorf("VerifyUserCode() error = %v, want %v", err, errTooManyAttempts)
+	}
+
+	_, authorization, err := service.VerifyUserCode(ctx, response.UserCode, "user")
+	if err != nil || authorization.ClientId != testClient.ID {
+		t.Errorf("VerifyUserCode() = %+v, error = %v", authorization, err)
+	}
+}
+
+func TestDeviceAuthorizationPoll(t *testing.T) {
+	ctx := context.Background()
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now(), Methods: []string{"hwk"}}
+
+	t.Run("Pending and slow down", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		deviceCode, deviceKey := service.initiate(t)
+
+		_, err := service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "authorization_pending" {
+			t.Fatalf("Poll() error = %v, want authorization_pending", err)
+		}
+		_, err = service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "slow_down" {
+			t.Fatalf("Poll() error = %v, want slow_down", err)
+		}
+		authorization, _ := service.deviceStore.Get(deviceKey)
+		if authorization.Interval != time.Second+slowDownIncrement {
+			t.Errorf("Interval = %s, want %s", authorization.Interval, time.Second+slowDownIncrement)
+		}
+		_, err = service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "slow_down" {
+			t.Fatalf("Poll() error = %v, want slow_down", err)
+		}
+		authorization, _ = service.deviceStore.Get(deviceKey)
+		if authorization.Interval != time.Second+2*slowDownIncrement {
+			t.Errorf("Interval = %s, want %s", authorization.Interval, time.Second+2*slowDownIncrement)
+		}
+	})
+
+	t.Run("Approved", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		deviceCode, deviceKey := service.initiate(t)
+
+		if err := service.Approve(ctx, deviceKey, authentication); err != nil {
+			t.Fatalf("Approve() error = %v", err)
+		}
+		if err := service.Approve(ctx, deviceKey, authentication); err == nil {
+			t.Errorf("second Approve() succeeded")
+		}
+		if err := service.Deny(ctx, deviceKey); err == nil {
+			t.Errorf("Deny() after Approve() succeeded")
+		}
+
+		authorization, err := service.Poll(ctx, testClient, deviceCode)
+		if err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+		if authorization.authentication != authentication || authorization.Scope != "api" {
+			t.Errorf("Poll() = %+v", authorization)
+		}
+		_, err = service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Poll() after redemption error = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		deviceCode, deviceKey := service.initiate(t)
+
+		if err := service.Deny(ctx, deviceKey); err != nil {
+			t.Fatalf("Deny() error = %v", err)
+		}
+		if err := service.Approve(ctx, deviceKey, authentication); err == nil {
+			t.Errorf("Approve() after Deny() succeeded")
+		}
+
+		_, err := service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "access_denied" {
+			t.Fatalf("Poll() error = %v, want access_denied", err)
+		}
+		_, err = service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Poll() after denial error = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("User code resolves once", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		response, err := service.Initiate(ctx, testClient, &DeviceAuthorizationRequest{Scope: "api"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deviceKey, _, _ := service.FindByUserCode(ctx, response.UserCode)
+		service.Deny(ctx, deviceKey)
+
+		if _, _, err := service.FindByUserCode(ctx, response.UserCode); err == nil {
+			t.Errorf("FindByUserCode() after Deny() succeeded")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		deviceCode, deviceKey := service.initiate(t)
+		authorization, _ := service.deviceStore.Get(deviceKey)
+		expired := *authorization
+		expired.ExpiresAt = time.Now().Add(-time.Second)
+		service.deviceStore.Set(deviceKey, &expired)
+
+		_, err := service.Poll(ctx, testClient, deviceCode)
+		if err == nil || err.ErrorType != "expired_token" {
+			t.Errorf("Poll() error = %v, want expired_token", err)
+		}
+	})
+
+	t.Run("Other client", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		deviceCode, deviceKey := service.initiate(t)
+		service.Approve(ctx, deviceKey, authentication)
+
+		_, err := service.Poll(ctx, &Client{ID: "other"}, deviceCode)
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Poll() error = %v, want invalid_grant", err)
+		}
+		// the device code is not consumed by the other client
+		if _, err := service.Poll(ctx, testClient, deviceCode); err != nil {
+			t.Errorf("Poll() error = %v", err)
+		}
+	})
+
+	t.Run("Unknown device code", func(t *testing.T) {
+		service := newTestDeviceService(t)
+		_, err := service.Poll(ctx, testClient, deviceCodeGenerator.Generate())
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Poll() error = %v, want invalid_grant", err)
+		}
+	})
+}
+
+func TestDeviceAuthorizationCodec(t *testing.T) {
+	codec, err := NewDeviceAuthorizationCodec(core.JSONCodecFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorization := &DeviceAuthorization{
+		id:             uuid.New(),
+		authentication: &domain.Authentication{SubjectID: uuid.New(), Methods: []string{"hwk"}},
+		ClientId:       "client",
+		Scope:          "api",
+		UserCode:       "BCDF-GHJK",
+		Status:         DeviceAuthorizationApproved,
+		ExpiresAt:      time.Now().Add(time.Minute).UTC().Truncate(time.Second),
+		Interval:       5 * time.Second,
+	}
+
+	data, err := codec.Encode(authorization)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	decoded, err := codec.Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if decoded.id != authorization.id || decoded.authentication == nil || decoded.authentication.SubjectID != authorization.authentication.SubjectID {
+		t.Errorf("Decode() lost unexported fields: %+v", decoded)
+	}
+	if decoded.Status != authorization.Status || decoded.Interval != authorization.Interval || !decoded.ExpiresAt.Equal(authorization.ExpiresAt) {
+		t.Errorf("Decode() = %+v, want %+v", decoded, authorization)
+	}
+}
@@ -0,0 +1,398 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AuthorizationRequestKind = "authorization"
+	AuthorizationCodeKind    = "code"
+	AccessTokenKind          = "access_token"
+	RefreshTokenKind         = "refresh_token"
+)
+
+// AuthorizationRequestModel persists pending authorization requests and
+// issued authorization codes. Keys are stored hashed, so the table does not
+// contain usable codes.
+type AuthorizationRequestModel struct {
+	Kind                  string    `gorm:"primaryKey"`
+	KeyHash               string    `gorm:"primaryKey"`
+	ID                    uuid.UUID `gorm:"type:uuid;index;not null"`
+	ClientId              string    `gorm:"not null"`
+	RedirectUri           string
+	ResponseType          string
+	Scope                 string
+	State                 string
+	Nonce                 string
+	CodeChallenge         string
+	CodeMethod            string
+	AuthenticationCode    []byte `gorm:"type:bytea"`
+	DeviceKey             string
+	SubjectID             *uuid.UUID `gorm:"type:uuid"`
+	AuthenticatedAt       time.Time
+	AuthenticationMethods string
+	AuthenticationContext string
+	ExpiresAt             time.Time `gorm:"index;not null"`
+}
+
+func (AuthorizationRequestModel) TableName() string {
+	return "authorization_requests"
+}
+
+// GormAuthorizationRequestStore is an AuthorizationStore or CodeStore backed
+// by the database. Entries expire after the configured lifetime.
+type GormAuthorizationRequestStore struct {
+	db           *gorm.DB
+	kind         string
+	lifetime     time.Duration
+	secretHasher *core.SecretHasher
+}
+
+func NewGormAuthorizationRequestStore(db *gorm.DB, kind string, lifetime time.Duration, secretHasher *core.SecretHasher) *GormAuthorizationRequestStore {
+	return &GormAuthorizationRequestStore{db: db, kind: kind, lifetime: lifetime, secretHasher: secretHasher}
+}
+
+func (s *GormAuthorizationRequestStore) Get(key string) (*AuthorizationRequest, error) {
+	var model AuthorizationRequestModel
+	err := s.db.Where("kind = ? AND key_hash = ? AND expires_at > ?", s.kind, s.hashKey(key), time.Now()).First(&model).Error
+	if err != nil {
+		return nil, fmt.Errorf("key not found: %w", err)
+	}
+	return model.toAuthorizationRequest(), nil
+}
+
+func (s *GormAuthorizationRequestStore) Set(key string, request *AuthorizationRequest) error {
+	return s.SetWithTTL(key, request, s.lifetime)
+}
+
+func (s *GormAuthorizationRequestStore) SetWithTTL(key string, request *AuthorizationRequest, ttl time.Duration) error {
+	return s.db.Save(s.toModel(key, request, time.Now().Add(ttl))).Error
+}
+
+func (s *GormAuthorizationRequestStore) Delete(key string) error {
+	return s.db.Where("kind = ? AND key_hash = ?", s.kind, s.hashKey(key)).Delete(&AuthorizationRequestModel{}).Error
+}
+
+func (s *GormAuthorizationRequestStore) GetAndDelete(key string) (*AuthorizationRequest, error) {
+	var model AuthorizationRequestModel
+	result := s.db.Clauses(clause.Returning{}).
+		Where("kind = ? AND key_hash = ? AND expires_at > ?", s.kind, s.hashKey(key), time.Now()).
+		Delete(&model)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("key not found")
+	}
+	return model.toAuthorizationRequest(), nil
+}
+
+func (s *GormAuthorizationRequestStore) CompareAndSet(key string, expected *AuthorizationRequest, request *AuthorizationRequest) (bool, error) {
+	swapped := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current AuthorizationRequestModel
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND key_hash = ? AND expires_at > ?", s.kind, s.hashKey(key), time.Now()).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(current.normalized(), s.toModel(key, expected, current.ExpiresAt).normalized()) {
+			return nil
+		}
+		swapped = true
+		return tx.Save(s.toModel(key, request, current.ExpiresAt)).Error
+	})
+	return swapped, err
+}
+
+// SetIfAbsent replaces an expired entry, but not an existing one. The insert
+// fails on the primary key if a concurrent caller set the value first.
+func (s *GormAuthorizationRequestStore) SetIfAbsent(key string, request *AuthorizationRequest, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	err := s.db.Where("kind = ? AND key_hash = ? AND expires_at <= ?", s.kind, s.hashKey(key), time.Now()).Delete(&AuthorizationRequestModel{}).Error
+	if err != nil {
+		return false, err
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(s.toModel(key, request, time.Now().Add(ttl)))
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *GormAuthorizationRequestStore) WithContext(ctx context.Context) core.KeyValueStore[string, *AuthorizationRequest] {
+	return &GormAuthorizationRequestStore{db: s.db.WithContext(ctx), kind: s.kind, lifetime: s.lifetime, secretHasher: s.secretHasher}
+}
+
+func (s *GormAuthorizationRequestStore) toModel(key string, request *AuthorizationRequest, expiresAt time.Time) *AuthorizationRequestModel {
+	model := &AuthorizationRequestModel{
+		Kind:               s.kind,
+		KeyHash:            s.hashKey(key),
+		ID:                 request.id,
+		ClientId:           request.ClientId,
+		RedirectUri:        request.RedirectUri,
+		ResponseType:       request.ResponseType,
+		Scope:              request.Scope,
+		State:              request.State,
+		Nonce:              request.Nonce,
+		CodeChallenge:      request.CodeChallenge,
+		CodeMethod:         request.CodeMethod,
+		AuthenticationCode: request.authenticationCode,
+		DeviceKey:          request.deviceKey,
+		ExpiresAt:          expiresAt,
+	}
+	if request.authentication != nil {
+		// the verifier hash is not persisted, it has been checked already
+		subjectId := request.authentication.SubjectID
+		model.SubjectID = &subjectId
+		model.AuthenticatedAt = request.authentication.AuthenticatedAt
+		model.AuthenticationMethods = strings.Join(request.authentication.Methods, " ")
+		model.AuthenticationContext = request.authentication.ContextClass
+	}
+	return model
+}
+
+func (m *AuthorizationRequestModel) toAuthorizationRequest() *AuthorizationRequest {
+	request := &AuthorizationRequest{
+		id:                 m.ID,
+		authenticationCode: m.AuthenticationCode,
+		deviceKey:          m.DeviceKey,
+		ClientId:           m.ClientId,
+		RedirectUri:        m.RedirectUri,
+		ResponseType:       m.ResponseType,
+		Scope:              m.Scope,
+		State:              m.State,
+		Nonce:              m.Nonce,
+		CodeChallenge:      m.CodeChallenge,
+		CodeMethod:         m.CodeMethod,
+	}
+	if m.SubjectID != nil {
+		request.authentication = &domain.Authentication{
+			SubjectID:       *m.SubjectID,
+			AuthenticatedAt: m.AuthenticatedAt,
+			Methods:         strings.Fields(m.AuthenticationMethods),
+			ContextClass:    m.AuthenticationContext,
+		}
+	}
+	return request
+}
+
+// normalized returns a copy of the model, which can be compared to a model
+// loaded from the database.
+func (m *AuthorizationRequestModel) normalized() AuthorizationRequestModel {
+	normalized := *m
+	normalized.AuthenticatedAt = normalizeTime(m.AuthenticatedAt)
+	normalized.ExpiresAt = normalizeTime(m.ExpiresAt)
+	if len(m.AuthenticationCode) == 0 {
+		normalized.AuthenticationCode = nil
+	}
+	return normalized
+}
+
+// DeleteExpired removes all expired entries of the store.
+func (s *GormAuthorizationRequestStore) DeleteExpired(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("kind = ? AND expires_at <= ?", s.kind, time.Now()).Delete(&AuthorizationRequestModel{}).Error
+}
+
+// TokenModel persists the grant of an opaque token. The key is the hash of
+// the token computed by the RandomTokenHandler.
+type TokenModel struct {
+	Kind                  string    `gorm:"primaryKey"`
+	KeyHash               string    `gorm:"primaryKey"`
+	GrantID               uuid.UUID `gorm:"type:uuid;index;not null"`
+	ClientId              string    `gorm:"index;not null"`
+	SubjectId             string
+	Scope                 string
+	IssuedAt              time.Time
+	ExpiresAt             time.Time
+	NotBefore             time.Time
+	Nonce                 string
+	AuthenticatedAt       time.Time
+	AuthenticationMethods string
+	AuthenticationContext string
+	// StoredUntil is the time the token itself expires, which differs from
+	// the expiry of the grant for refresh tokens
+	StoredUntil time.Time `gorm:"index;not null"`
+}
+
+func (TokenModel) TableName() string {
+	return "tokens"
+}
+
+// GormTokenStore is a TokenStore backed by the database. Tokens are stored
+// until their grant expires or, if a lifetime is configured, until the
+// lifetime has passed.
+type GormTokenStore struct {
+	db       *gorm.DB
+	kind     string
+	lifetime time.Duration
+}
+
+func NewGormTokenStore(db *gorm.DB, kind string, lifetime time.Duration) *GormTokenStore {
+	return &GormTokenStore{db: db, kind: kind, lifetime: lifetime}
+}
+
+func (s *GormTokenStore) Get(key string) (*AuthorizationGrant, error) {
+	var model TokenModel
+	err := s.db.Where("kind = ? AND key_hash = ? AND stored_until > ?", s.kind, key, time.Now()).First(&model).Error
+	if err != nil {
+		return nil, fmt.Errorf("key not found: %w", err)
+	}
+	return model.toAuthorizationGrant(), nil
+}
+
+func (s *GormTokenStore) Set(key string, grant *AuthorizationGrant) error {
+	storedUntil := time.Time(grant.ExpiresAt)
+	if s.lifetime > 0 {
+		storedUntil = time.Now().Add(s.lifetime)
+	}
+	return s.db.Save(s.toModel(key, grant, storedUntil)).Error
+}
+
+func (s *GormTokenStore) SetWithTTL(key string, grant *AuthorizationGrant, ttl time.Duration) error {
+	return s.db.Save(s.toModel(key, grant, time.Now().Add(ttl))).Error
+}
+
+func (s *GormTokenStore) Delete(key string) error {
+	return s.db.Where("kind = ? AND key_hash = ?", s.kind, key).Delete(&TokenModel{}).Error
+}
+
+func (s *GormTokenStore) GetAndDelete(key string) (*AuthorizationGrant, error) {
+	var model TokenModel
+	result := s.db.Clauses(clause.Returning{}).
+		Where("kind = ? AND key_hash = ? AND stored_until > ?", s.kind, key, time.Now()).
+		Delete(&model)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("key not found")
+	}
+	return model.toAuthorizationGrant(), nil
+}
+
+func (s *GormTokenStore) CompareAndSet(key string, expected *AuthorizationGrant, grant *AuthorizationGrant) (bool, error) {
+	swapped := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current TokenModel
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND key_hash = ? AND stored_until > ?", s.kind, key, time.Now()).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(current.normalized(), s.toModel(key, expected, current.StoredUntil).normalized()) {
+			return nil
+		}
+		swapped = true
+		return tx.Save(s.toModel(key, grant, current.StoredUntil)).Error
+	})
+	return swapped, err
+}
+
+// SetIfAbsent replaces an expired token, but not an existing one. The insert
+// fails on the primary key if a concurrent caller set the token first.
+func (s *GormTokenStore) SetIfAbsent(key string, grant *AuthorizationGrant, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	err := s.db.Where("kind = ? AND key_hash = ? AND stored_until <= ?", s.kind, key, time.Now()).Delete(&TokenModel{}).Error
+	if err != nil {
+		return false, err
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(s.toModel(key, grant, time.Now().Add(ttl)))
+	return result.RowsAffected == 1, result.Error
+}
+
+func (s *GormTokenStore) WithContext(ctx context.Context) core.KeyValueStore[string, *AuthorizationGrant] {
+	return &GormTokenStore{db: s.db.WithContext(ctx), kind: s.kind, lifetime: s.lifetime}
+}
+
+func (s *GormTokenStore) toModel(key string, grant *AuthorizationGrant, storedUntil time.Time) *TokenModel {
+	return &TokenModel{
+		Kind:                  s.kind,
+		KeyHash:               key,
+		GrantID:               grant.ID,
+		ClientId:              grant.ClientId,
+		SubjectId:             grant.SubjectId,
+		Scope:                 grant.Scope,
+		IssuedAt:              time.Time(grant.IssuedAt),
+		ExpiresAt:             time.Time(grant.ExpiresAt),
+		NotBefore:             time.Time(grant.NotBefore),
+		Nonce:                 grant.Nonce,
+		AuthenticatedAt:       grant.AuthenticatedAt,
+		AuthenticationMethods: strings.Join(grant.AuthenticationMethods, " "),
+		AuthenticationContext: grant.AuthenticationContext,
+		StoredUntil:           storedUntil,
+	}
+}
+
+func (m *TokenModel) toAuthorizationGrant() *AuthorizationGrant {
+	return &AuthorizationGrant{
+		ID:                    m.GrantID,
+		Scope:                 m.Scope,
+		ClientId:              m.ClientId,
+		SubjectId:             m.SubjectId,
+		IssuedAt:              timestamp(m.IssuedAt),
+		ExpiresAt:             timestamp(m.ExpiresAt),
+		NotBefore:             timestamp(m.NotBefore),
+		Nonce:                 m.Nonce,
+		AuthenticatedAt:       m.AuthenticatedAt,
+		AuthenticationMethods: strings.Fields(m.AuthenticationMethods),
+		AuthenticationContext: m.AuthenticationContext,
+	}
+}
+
+// normalized returns a copy of the model, which can be compared to a model
+// loaded from the database.
+func (m *TokenModel) normalized() TokenModel {
+	normalized := *m
+	normalized.IssuedAt = normalizeTime(m.IssuedAt)
+	normalized.ExpiresAt = normalizeTime(m.ExpiresAt)
+	normalized.NotBefore = normalizeTime(m.NotBefore)
+	normalized.AuthenticatedAt = normalizeTime(m.AuthenticatedAt)
+	normalized.StoredUntil = normalizeTime(m.StoredUntil)
+	return normalized
+}
+
+// DeleteExpired removes all expired tokens of the store.
+func (s *GormTokenStore) DeleteExpired(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("kind = ? AND stored_until <= ?", s.kind, time.Now()).Delete(&TokenModel{}).Error
+}
+
+// normalizeTime drops the location and the precision the database does not
+// store.
+func normalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func (s *GormAuthorizationRequestStore) hashKey(key string) string {
+	return s.secretHasher.Secret(key).String()
+}
+
+// WithHashedKeys returns a store that hashes its keys like the gorm stores,
+// for backends that store the keys they are given.
+func WithHashedKeys[Type interface{}](store core.KeyValueStore[string, Type], secretHasher *core.SecretHasher) core.KeyValueStore[string, Type] {
+	return core.WithHashedKeys(store, func(key string) string {
+		return secretHasher.Secret(key).String()
+	})
+}
@@ -0,0 +1,274 @@
+package oauth2
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	// a shared cache lets all connections of the pool see the same database
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared&_busy_timeout=5000"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestAuthorizationRequest(authentication *domain.Authentication) *AuthorizationRequest {
+	return &AuthorizationRequest{
+		id:             uuid.New(),
+		authentication: authentication,
+		ClientId:       "client",
+		RedirectUri:    "https://client.example.com/callback",
+		ResponseType:   "code",
+		Scope:          "openid api",
+		State:          "state",
+		Nonce:          "nonce",
+		CodeChallenge:  "challenge",
+		CodeMethod:     "S256",
+	}
+}
+
+func TestGormAuthorizationRequestStore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &AuthorizationRequestModel{})
+	store := NewGormAuthorizationRequestStore(db, AuthorizationCodeKind, time.Minute, newTestSecretHasher(t)).WithContext(ctx)
+	otherKind := NewGormAuthorizationRequestStore(db, AuthorizationRequestKind, time.Minute, newTestSecretHasher(t))
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now().UTC().Truncate(time.Second), Methods: []string{"hwk", "pin"}}
+
+	t.Run("Round trip", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		request := newTestAuthorizationRequest(authentication)
+		if err := store.Set(code, request); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+
+		found, err := store.Get(code)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if found.id != request.id || found.ClientId != request.ClientId || found.CodeChallenge != request.CodeChallenge || found.Nonce != request.Nonce {
+			t.Errorf("Get() = %+v, want %+v", found, request)
+		}
+		if found.authentication == nil || found.authentication.SubjectID != authentication.SubjectID || len(found.authentication.Methods) != 2 {
+			t.Errorf("authentication = %+v, want %+v", found.authentication, authentication)
+		}
+		if _, err := otherKind.Get(code); err == nil {
+			t.Errorf("Get() from store of other kind succeeded")
+		}
+
+		var model AuthorizationRequestModel
+		db.Where("id = ?", request.id).First(&model)
+		if model.KeyHash == code {
+			t.Errorf("code stored unhashed")
+		}
+	})
+
+	t.Run("Without authentication", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		store.Set(code, newTestAuthorizationRequest(nil))
+		found, err := store.Get(code)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if found.authentication != nil {
+			t.Errorf("authentication = %+v, want nil", found.authentication)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		store.SetWithTTL(code, newTestAuthorizationRequest(authentication), 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		if _, err := store.Get(code); err == nil {
+			t.Errorf("Get() of expired request succeeded")
+		}
+		if _, err := store.GetAndDelete(code); err == nil {
+			t.Errorf("GetAndDelete() of expired request succeeded")
+		}
+		if set, err := store.SetIfAbsent(code, newTestAuthorizationRequest(authentication), time.Minute); err != nil || !set {
+			t.Errorf("SetIfAbsent() over expired request = %v, error = %v, want true", set, err)
+		}
+	})
+
+	t.Run("Redeem once", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		request := newTestAuthorizationRequest(authentication)
+		store.Set(code, request)
+
+		found, err := store.GetAndDelete(code)
+		if err != nil || found.id != request.id {
+			t.Fatalf("GetAndDelete() = %+v, error = %v", found, err)
+		}
+		if _, err := store.GetAndDelete(code); err == nil {
+			t.Errorf("second GetAndDelete() succeeded")
+		}
+	})
+
+	t.Run("Compare and set", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		pending := newTestAuthorizationRequest(nil)
+		store.Set(code, pending)
+		authenticated := *pending
+		authenticated.authentication = authentication
+
+		swapped, err := store.CompareAndSet(code, &authenticated, pending)
+		if err != nil || swapped {
+			t.Errorf("CompareAndSet() with other expected request = %v, error = %v, want false", swapped, err)
+		}
+		swapped, err = store.CompareAndSet(code, pending, &authenticated)
+		if err != nil || !swapped {
+			t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+		}
+		found, _ := store.Get(code)
+		if found == nil || found.authentication == nil {
+			t.Errorf("CompareAndSet() did not store the request")
+		}
+	})
+
+	t.Run("Error does not contain the code", func(t *testing.T) {
+		code := authorizationCodeGenerator.Generate()
+		_, err := store.Get(code)
+		if err == nil || strings.Contains(err.Error(), code) {
+			t.Errorf("Get() error = %v", err)
+		}
+		_, err = store.GetAndDelete(code)
+		if err == nil || strings.Contains(err.Error(), code) {
+			t.Errorf("GetAndDelete() error = %v", err)
+		}
+	})
+
+	t.Run("Delete expired", func(t *testing.T) {
+		store.SetWithTTL(authorizationCodeGenerator.Generate(), newTestAuthorizationRequest(nil), -time.Second)
+		if err := NewGormAuthorizationRequestStore(db, AuthorizationCodeKind, time.Minute, newTestSecretHasher(t)).DeleteExpired(ctx); err != nil {
+			t.Fatalf("DeleteExpired() error = %v", err)
+		}
+		var count int64
+		db.Model(&AuthorizationRequestModel{}).Where("expires_at <= ?", time.Now()).Count(&count)
+		if count != 0 {
+			t.Errorf("%d expired requests left after DeleteExpired()", count)
+		}
+	})
+}
+
+func TestGormTokenStore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &TokenModel{})
+	grant := &AuthorizationGrant{
+		ID:                    uuid.New(),
+		Scope:                 "openid api",
+		ClientId:              "client",
+		SubjectId:             uuid.NewString(),
+		IssuedAt:              timestamp(time.Now()),
+		ExpiresAt:             timestamp(time.Now().Add(time.Hour)),
+		Nonce:                 "nonce",
+		AuthenticatedAt:       time.Now(),
+		AuthenticationMethods: []string{"hwk"},
+	}
+
+	t.Run("Stored until the grant expires", func(t *testing.T) {
+		store := NewGormTokenStore(db, AccessTokenKind, 0).WithContext(ctx)
+		expiring := *grant
+		expiring.ExpiresAt = timestamp(time.Now().Add(10 * time.Millisecond))
+		store.Set("key", grant)
+		store.Set("expiring", &expiring)
+		time.Sleep(20 * time.Millisecond)
+
+		found, err := store.Get("key")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if found.ID != grant.ID || found.SubjectId != grant.SubjectId || found.Scope != grant.Scope || found.AuthenticationMethods[0] != "hwk" {
+			t.Errorf("Get() = %+v, want %+v", found, grant)
+		}
+		if _, err := store.Get("expiring"); err == nil {
+			t.Errorf("Get() of token of expired grant succeeded")
+		}
+	})
+
+	t.Run("Stored for the lifetime", func(t *testing.T) {
+		// refresh tokens outlive the access token expiry of their grant
+		store := NewGormTokenStore(db, RefreshTokenKind, time.Hour).WithContext(ctx)
+		expired := *grant
+		expired.ExpiresAt = timestamp(time.Now().Add(-time.Minute))
+		store.Set("refresh", &expired)
+		if _, err := store.Get("refresh"); err != nil {
+			t.Errorf("Get() error = %v", err)
+		}
+		if _, err := NewGormTokenStore(db, AccessTokenKind, 0).Get("refresh"); err == nil {
+			t.Errorf("Get() from store of other kind found the refresh token")
+		}
+	})
+
+	t.Run("Revoke and consume", func(t *testing.T) {
+		store := NewGormTokenStore(db, AccessTokenKind, 0).WithContext(ctx)
+		store.Set("revoked", grant)
+		store.Delete("revoked")
+		if _, err := store.Get("revoked"); err == nil {
+			t.Errorf("Get() after Delete() succeeded")
+		}
+
+		store.Set("consumed", grant)
+		if _, err := store.GetAndDelete("consumed"); err != nil {
+			t.Errorf("GetAndDelete() error = %v", err)
+		}
+		if _, err := store.GetAndDelete("consumed"); err == nil {
+			t.Errorf("second GetAndDelete() succeeded")
+		}
+	})
+
+	t.Run("Compare and set", func(t *testing.T) {
+		store := NewGormTokenStore(db, AccessTokenKind, 0).WithContext(ctx)
+		store.Set("swapped", grant)
+		narrowed := *grant
+		narrowed.Scope = "api"
+
+		swapped, err := store.CompareAndSet("swapped", &narrowed, grant)
+		if err != nil || swapped {
+			t.Errorf("CompareAndSet() with other expected grant = %v, error = %v, want false", swapped, err)
+		}
+		swapped, err = store.CompareAndSet("swapped", grant, &narrowed)
+		if err != nil || !swapped {
+			t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+		}
+		if found, _ := store.Get("swapped"); found == nil || found.Scope != "api" {
+			t.Errorf("Get() after CompareAndSet() = %+v", found)
+		}
+
+		set, err := store.SetIfAbsent("swapped", grant, time.Hour)
+		if err != nil || set {
+			t.Errorf("SetIfAbsent() on existing token = %v, error = %v, want false", set, err)
+		}
+	})
+
+	t.Run("Delete expired", func(t *testing.T) {
+		store := NewGormTokenStore(db, AccessTokenKind, 0)
+		store.SetWithTTL("expired", grant, -time.Second)
+		if err := store.DeleteExpired(ctx); err != nil {
+			t.Fatalf("DeleteExpired() error = %v", err)
+		}
+		var count int64
+		db.Model(&TokenModel{}).Where("stored_until <= ?", time.Now()).Count(&count)
+		if count != 0 {
+			t.Errorf("%d expired tokens left after DeleteExpired()", count)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// accessTokenType is the "typ" header of JWT access tokens (RFC 9068).
+	accessTokenType = "at+jwt"
+	// refreshTokenType is the "typ" header of JWT refresh tokens, so they
+	// cannot be used as access tokens.
+	refreshTokenType = "rt+jwt"
+)
+
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientId string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	GrantId  string `json:"grant_id"`
+}
+
+// JWTTokenHandler issues self-contained JWT access tokens according to
+// RFC 9068. Tokens are validated by their signature, only revoked tokens
+// and grants are looked up in the revocation list. Refresh tokens are issued
+// with their own type and lifetime.
+type JWTTokenHandler struct {
+	tokenType       string
+	lifetime        time.Duration
+	issuer          string
+	audience        string
+	keys            jwt.KeySet
+	revocations     *RevocationList
+	logger          *slog.Logger
+	tokensGenerated metric.Int64Counter
+}
+
+func NewJWTTokenHandler(tokenType string, lifetime time.Duration, issuer string, audience string, keys jwt.KeySet, revocations *RevocationList, tokensGenerated metric.Int64Counter) *JWTTokenHandler {
+	logger := slog.Default().With(slog.String("service", "token-handler"), slog.String("type", tokenType))
+
+	jwtType := accessTokenType
+	if tokenType == RefreshTokenKind {
+		jwtType = refreshTokenType
+	}
+
+	return &JWTTokenHandler{
+		tokenType:       jwtType,
+		lifetime:        lifetime,
+		issuer:          issuer,
+		audience:        audience,
+		keys:            keys,
+		revocations:     revocations,
+		logger:          logger,
+		tokensGenerated: tokensGenerated,
+	}
+}
+
+func (h *JWTTokenHandler) GenerateToken(ctx context.Context, grant *AuthorizationGrant) (string, error) {
+	key, err := h.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	notBefore := time.Time(grant.NotBefore)
+	if notBefore.IsZero() {
+		notBefore = time.Time(grant.IssuedAt)
+	}
+	expiresAt := time.Time(grant.ExpiresAt)
+	if h.tokenType == refreshTokenType {
+		expiresAt = time.Time(grant.IssuedAt).Add(h.lifetime)
+	}
+
+	claims := &accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    h.issuer,
+			Subject:   grant.SubjectId,
+			Audience:  jwt.Audience{h.audience},
+			ExpiresAt: expiresAt.Unix(),
+			NotBefore: notBefore.Unix(),
+			IssuedAt:  time.Time(grant.IssuedAt).Unix(),
+			ID:        uuid.New().String(),
+		},
+		ClientId: grant.ClientId,
+		Scope:    grant.Scope,
+		GrantId:  grant.ID.String(),
+	}
+
+	token, err := jwt.Sign(key, h.tokenType, claims)
+	if err != nil {
+		return "", err
+	}
+	h.logger.Debug("Generated JWT token", "authorization_id", grant.ID, "kid", key.ID)
+	h.tokensGenerated.Add(context.Background(), 1, metric.WithAttributes(attribute.Key("client_id").String(grant.ClientId)))
+	return token, nil
+}
+
+func (h *JWTTokenHandler) Validate(ctx context.Context, token string) (*AuthorizationGrant, error) {
+	claims, err := h.parse(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	grantId, err := uuid.Parse(claims.GrantId)
+	if err != nil {
+		return nil, err
+	}
+	if h.revocations.IsRevoked(ctx, grantId.String()) || h.revocations.IsRevoked(ctx, claims.ID) {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
+	h.logger.Debug("Successfully validated token", "authorization_id", grantId)
+	return &AuthorizationGrant{
+		ID:        grantId,
+		Scope:     claims.Scope,
+		ClientId:  claims.ClientId,
+		SubjectId: claims.Subject,
+		IssuedAt:  timestamp(time.Unix(claims.IssuedAt, 0)),
+		ExpiresAt: timestamp(time.Unix(claims.ExpiresAt, 0)),
+		NotBefore: timestamp(time.Unix(claims.NotBefore, 0)),
+	}, nil
+}
+
+// Revoke adds the id of the token to the revocation list, as a signed token
+// cannot be taken back.
+func (h *JWTTokenHandler) Revoke(ctx context.Context, token string) error {
+	claims, err := h.parse(ctx, token)
+	if err != nil {
+		return err
+	}
+	return h.revocations.RevokeToken(ctx, claims.ID, time.Unix(claims.ExpiresAt, 0))
+}
+
+func (h *JWTTokenHandler) parse(ctx context.Context, token string) (*accessTokenClaims, error) {
+	claims := &accessTokenClaims{}
+	header, err := jwt.Parse(token, claims, func(header *jwt.Header) (*jwt.Key, error) {
+		return h.keys.VerificationKey(ctx, header.KeyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Type != h.tokenType {
+		return nil, fmt.Errorf("invalid token type %s", header.Type)
+	}
+	if claims.Issuer != h.issuer {
+		return nil, fmt.Errorf("invalid issuer %s", claims.Issuer)
+	}
+	if !claims.Audience.Contains(h.audience) {
+		return nil, fmt.Errorf("token not issued for audience %s", h.audience)
+	}
+	if err := claims.ValidateTime(time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
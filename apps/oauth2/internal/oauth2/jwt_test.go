@@ -0,0 +1,186 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+const testIssuer = "https://auth.example.com"
+
+func newTestCounter(t *testing.T) metric.Int64Counter {
+	t.Helper()
+	counter, err := noop.NewMeterProvider().Meter("test").Int64Counter("tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counter
+}
+
+// newTestSecretHasher returns a hasher with a pepper, like it is configured
+// in production.
+func newTestSecretHasher(t *testing.T) *core.SecretHasher {
+	t.Helper()
+	hasher, err := core.NewSecretHasher("1", map[string][]byte{"1": []byte("pepper of the tests, at least 32 bytes")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func newTestRevocationList(t *testing.T) *RevocationList {
+	t.Helper()
+	store := core.NewInMemoryKeyValueStore[time.Time]()
+	t.Cleanup(store.Close)
+	return NewRevocationList(store, time.Hour)
+}
+
+func newTestKeySet(t *testing.T) *jwt.StaticKeySet {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.NewSigningKey("test", jwt.ES256, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwt.NewStaticKeySet(key)
+}
+
+func newTestGrant(lifetime time.Duration) *AuthorizationGrant {
+	now := time.Now().Truncate(time.Second)
+	return &AuthorizationGrant{
+		ID:        uuid.New(),
+		Scope:     "openid",
+		ClientId:  "client",
+		SubjectId: "user",
+		IssuedAt:  timestamp(now),
+		ExpiresAt: timestamp(now.Add(lifetime)),
+	}
+}
+
+func TestJWTTokenHandler(t *testing.T) {
+	keys := newTestKeySet(t)
+	revocations := newTestRevocationList(t)
+	accessTokens := NewJWTTokenHandler(AccessTokenKind, time.Hour, testIssuer, "api", keys, revocations, newTestCounter(t))
+	refreshTokens := NewJWTTokenHandler(RefreshTokenKind, 24*time.Hour, testIssuer, testIssuer, keys, revocations, newTestCounter(t))
+	otherAudience := NewJWTTokenHandler(AccessTokenKind, time.Hour, testIssuer, "other", keys, revocations, newTestCounter(t))
+	ctx := context.Background()
+
+	t.Run("Access token", func(t *testing.T) {
+		grant := newTestGrant(time.Hour)
+		token, err := accessTokens.GenerateToken(ctx, grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validated, err := accessTokens.Validate(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if validated.ID != grant.ID || validated.SubjectId != grant.SubjectId || validated.ClientId != grant.ClientId || validated.Scope != grant.Scope {
+			t.Errorf("Validate() = %+v, want %+v", validated, grant)
+		}
+		if !time.Time(validated.ExpiresAt).Equal(time.Time(grant.ExpiresAt)) {
+			t.Errorf("ExpiresAt = %v, want %v", time.Time(validated.ExpiresAt), time.Time(grant.ExpiresAt))
+		}
+		if _, err := refreshTokens.Validate(ctx, token); err == nil {
+			t.Errorf("access token accepted as refresh token")
+		}
+		if _, err := otherAudience.Validate(ctx, token); err == nil {
+			t.Errorf("access token accepted for other audience")
+		}
+	})
+
+	t.Run("Refresh token", func(t *testing.T) {
+		grant := newTestGrant(time.Hour)
+		token, err := refreshTokens.GenerateToken(ctx, grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := jwt.ParseUnverified(token, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Type != refreshTokenType {
+			t.Errorf("typ = %s, want %s", header.Type, refreshTokenType)
+		}
+		validated, err := refreshTokens.Validate(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantExpiry := time.Time(grant.IssuedAt).Add(24 * time.Hour)
+		if !time.Time(validated.ExpiresAt).Equal(wantExpiry) {
+			t.Errorf("ExpiresAt = %v, want %v", time.Time(validated.ExpiresAt), wantExpiry)
+		}
+		if _, err := accessTokens.Validate(ctx, token); err == nil {
+			t.Errorf("refresh token accepted as access token")
+		}
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		grant := newTestGrant(-time.Minute)
+		token, err := accessTokens.GenerateToken(ctx, grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := accessTokens.Validate(ctx, token); err != jwt.ErrExpired {
+			t.Errorf("Validate() error = %v, want %v", err, jwt.ErrExpired)
+		}
+	})
+
+	t.Run("Token without expiry", func(t *testing.T) {
+		key, _ := keys.SigningKey(ctx)
+		token, err := jwt.Sign(key, accessTokenType, &accessTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:   testIssuer,
+				Subject:  "user",
+				Audience: jwt.Audience{"api"},
+				IssuedAt: time.Now().Unix(),
+				ID:       uuid.New().String(),
+			},
+			GrantId: uuid.New().String(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := accessTokens.Validate(ctx, token); err != jwt.ErrMissingExpiry {
+			t.Errorf("Validate() error = %v, want %v", err, jwt.ErrMissingExpiry)
+		}
+	})
+
+	t.Run("Revoked token", func(t *testing.T) {
+		grant := newTestGrant(time.Hour)
+		token, _ := accessTokens.GenerateToken(ctx, grant)
+		other, _ := accessTokens.GenerateToken(ctx, grant)
+		if err := accessTokens.Revoke(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := accessTokens.Validate(ctx, token); err == nil {
+			t.Errorf("revoked token accepted")
+		}
+		if _, err := accessTokens.Validate(ctx, other); err != nil {
+			t.Errorf("other token of grant rejected: %v", err)
+		}
+	})
+
+	t.Run("Revoked grant", func(t *testing.T) {
+		grant := newTestGrant(time.Hour)
+		token, _ := accessTokens.GenerateToken(ctx, grant)
+		if err := revocations.RevokeGrant(ctx, grant.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := accessTokens.Validate(ctx, token); err == nil {
+			t.Errorf("token of revoked grant accepted")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+const OpenIDScope = "openid"
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthTime        int64    `json:"auth_time,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	ACR             string   `json:"acr,omitempty"`
+	AMR             []string `json:"amr,omitempty"`
+	AccessTokenHash string   `json:"at_hash,omitempty"`
+}
+
+type UserInfo struct {
+	Subject string `json:"sub"`
+}
+
+// ProviderMetadata is the OpenID Connect discovery document.
+type ProviderMetadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint"`
+	JWKSURI                                    string   `json:"jwks_uri"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	ResponseModesSupported                     []string `json:"response_modes_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	ACRValuesSupported                         []string `json:"acr_values_supported"`
+	ClaimsSupported                            []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter          bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// OpenIDProvider implements the OpenID Connect layer on top of the OAuth 2.0
+// grants: it issues ID tokens and answers userinfo requests.
+type OpenIDProvider struct {
+	issuer      string
+	keys        jwt.KeySet
+	userService *domain.UserService
+	logger      *slog.Logger
+}
+
+func NewOpenIDProvider(issuer string, keys jwt.KeySet, userService *domain.UserService) *OpenIDProvider {
+	logger := slog.Default().With(slog.String("service", "openid"))
+
+	return &OpenIDProvider{
+		issuer:      issuer,
+		keys:        keys,
+		userService: userService,
+		logger:      logger,
+	}
+}
+
+// IssueIDToken signs an ID token for the grant. The access token issued
+// alongside is bound to the ID token through the "at_hash" claim.
+func (p *OpenIDProvider) IssueIDToken(ctx context.Context, grant *AuthorizationGrant, accessToken string) (string, error) {
+	key, err := p.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	accessTokenHash, err := halfHash(key.Algorithm, accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.issuer,
+			Subject:   grant.SubjectId,
+			Audience:  jwt.Audience{grant.ClientId},
+			ExpiresAt: time.Time(grant.ExpiresAt).Unix(),
+			IssuedAt:  time.Time(grant.IssuedAt).Unix(),
+			ID:        uuid.New().String(),
+		},
+		Nonce:           grant.Nonce,
+		ACR:             grant.AuthenticationContext,
+		AMR:             grant.AuthenticationMethods,
+		AccessTokenHash: accessTokenHash,
+	}
+	if !grant.AuthenticatedAt.IsZero() {
+		claims.AuthTime = grant.AuthenticatedAt.Unix()
+	}
+
+	idToken, err := jwt.Sign(key, "JWT", claims)
+	if err != nil {
+		return "", err
+	}
+	p.logger.Debug("Issued ID token", "authorization_id", grant.ID, "kid", key.ID)
+	return idToken, nil
+}
+
+func (p *OpenIDProvider) UserInfo(ctx context.Context, grant *AuthorizationGrant) (*UserInfo, error) {
+	if !grant.HasScope(OpenIDScope) {
+		return nil, fmt.Errorf("grant does not include scope %s", OpenIDScope)
+	}
+
+	user, err := p.userService.GetUserById(ctx, grant.SubjectId)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != "active" {
+		return nil, fmt.Errorf("user is not active")
+	}
+
+	return &UserInfo{
+		Subject: user.ID.String(),
+	}, nil
+}
+
+// halfHash computes the left-most half of the hash of the value, base64url
+// encoded, as required for "at_hash".
+func halfHash(algorithm string, value string) (string, error) {
+	hashFunc, err := jwt.HashFunc(algorithm)
+	if err != nil {
+		return "", err
+	}
+	hash := hashFunc.New()
+	hash.Write([]byte(value))
+	sum := hash.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
@@ -0,0 +1,95 @@
+package oauth2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+func TestIssueIDToken(t *testing.T) {
+	user := &domain.User{ID: uuid.New(), Status: "active"}
+	service := newTestTokenService(t, user)
+	ctx := context.Background()
+	authenticatedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	authentication := &domain.Authentication{
+		SubjectID:       user.ID,
+		AuthenticatedAt: authenticatedAt,
+		Methods:         []string{"hwk", "user"},
+		ContextClass:    "phr",
+	}
+
+	code := newTestCode(t, service, authentication, "openid api")
+	response, tokenErr := service.Token(ctx, testClient, newCodeTokenRequest(code))
+	if tokenErr != nil {
+		t.Fatalf("Token() error = %v", tokenErr)
+	}
+	if response.IDToken == "" {
+		t.Fatalf("no ID token issued for scope openid")
+	}
+
+	claims := &idTokenClaims{}
+	_, err := jwt.Parse(response.IDToken, claims, func(header *jwt.Header) (*jwt.Key, error) {
+		return service.openIDProvider.keys.VerificationKey(ctx, header.KeyID)
+	})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if claims.Issuer != testIssuer || claims.Subject != user.ID.String() || !claims.Audience.Contains("client") {
+		t.Errorf("unexpected claims %+v", claims.RegisteredClaims)
+	}
+	if claims.Nonce != "nonce" || claims.ACR != "phr" || len(claims.AMR) != 2 || claims.AuthTime != authenticatedAt.Unix() {
+		t.Errorf("unexpected authentication claims %+v", claims)
+	}
+	key, _ := service.openIDProvider.keys.SigningKey(ctx)
+	wantHash, _ := halfHash(key.Algorithm, response.AccessToken)
+	if claims.AccessTokenHash != wantHash {
+		t.Errorf("at_hash = %s, want %s", claims.AccessTokenHash, wantHash)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	active := &domain.User{ID: uuid.New(), Status: "active"}
+	inactive := &domain.User{ID: uuid.New(), Status: "inactive"}
+	provider := newTestTokenService(t, active, inactive).openIDProvider
+
+	tests := []struct {
+		name    string
+		grant   *AuthorizationGrant
+		wantErr bool
+	}{
+		{
+			name:  "Active user",
+			grant: &AuthorizationGrant{Scope: "openid", SubjectId: active.ID.String()},
+		},
+		{
+			name:    "Without scope openid",
+			grant:   &AuthorizationGrant{Scope: "api", SubjectId: active.ID.String()},
+			wantErr: true,
+		},
+		{
+			name:    "Inactive user",
+			grant:   &AuthorizationGrant{Scope: "openid", SubjectId: inactive.ID.String()},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown user",
+			grant:   &AuthorizationGrant{Scope: "openid", SubjectId: uuid.New().String()},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userInfo, err := provider.UserInfo(context.Background(), tt.grant)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UserInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && userInfo.Subject != tt.grant.SubjectId {
+				t.Errorf("Subject = %s, want %s", userInfo.Subject, tt.grant.SubjectId)
+			}
+		})
+	}
+}
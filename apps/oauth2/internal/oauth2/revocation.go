@@ -0,0 +1,72 @@
+package oauth2
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/google/uuid"
+)
+
+type RevocationRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+}
+
+// RevocationStore holds the time a grant or a single token was revoked by
+// the id of the grant or token.
+type RevocationStore = core.KeyValueStore[string, time.Time]
+
+// RevocationList keeps track of revoked grants and tokens. It is shared by
+// all token handlers, so that revoking a grant invalidates every token
+// issued for it, regardless of the handler that issued the token.
+type RevocationList struct {
+	store RevocationStore
+	// retention is the time revoked grants are remembered, which must be at
+	// least the lifetime of the longest lived token
+	retention time.Duration
+	logger    *slog.Logger
+}
+
+func NewRevocationList(store RevocationStore, retention time.Duration) *RevocationList {
+	logger := slog.Default().With(slog.String("service", "revocation-list"))
+
+	return &RevocationList{
+		store:     store,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// RevokeGrant invalidates all tokens issued for the grant.
+func (l *RevocationList) RevokeGrant(ctx context.Context, grantId uuid.UUID) error {
+	err := l.store.WithContext(ctx).SetWithTTL(grantId.String(), time.Now(), l.retention)
+	if err != nil {
+		return err
+	}
+	l.logger.Info("Revoked grant", "authorization_id", grantId)
+	return nil
+}
+
+// RevokeToken invalidates a single token by its id until it expires. Only
+// needed for tokens that cannot be removed from a store, e.g. JWTs.
+func (l *RevocationList) RevokeToken(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	now := time.Now()
+	if !now.Before(expiresAt) {
+		// the token is rejected for its expiry anyway
+		l.logger.Debug("Token already expired, skipping revocation", "token_id", tokenId)
+		return nil
+	}
+	err := l.store.WithContext(ctx).SetWithTTL(tokenId, now, expiresAt.Sub(now))
+	if err != nil {
+		return err
+	}
+	l.logger.Debug("Revoked token", "token_id", tokenId)
+	return nil
+}
+
+func (l *RevocationList) IsRevoked(ctx context.Context, id string) bool {
+	_, err := l.store.WithContext(ctx).Get(id)
+	return err == nil
+}
@@ -0,0 +1,187 @@
+package oauth2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/google/uuid"
+)
+
+// recordingRevocationStore records the ttls values are stored with.
+type recordingRevocationStore struct {
+	RevocationStore
+	ttls []time.Duration
+}
+
+func (s *recordingRevocationStore) SetWithTTL(key string, value time.Time, ttl time.Duration) error {
+	s.ttls = append(s.ttls, ttl)
+	return s.RevocationStore.SetWithTTL(key, value, ttl)
+}
+
+func (s *recordingRevocationStore) WithContext(ctx context.Context) core.KeyValueStore[string, time.Time] {
+	return s
+}
+
+func TestRevocationList(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Grant", func(t *testing.T) {
+		revocations := newTestRevocationList(t)
+		grantId := uuid.New()
+		if revocations.IsRevoked(ctx, grantId.String()) {
+			t.Fatalf("IsRevoked() before RevokeGrant() = true")
+		}
+		if err := revocations.RevokeGrant(ctx, grantId); err != nil {
+			t.Fatalf("RevokeGrant() error = %v", err)
+		}
+		if !revocations.IsRevoked(ctx, grantId.String()) {
+			t.Errorf("IsRevoked() = false, want true")
+		}
+		if revocations.IsRevoked(ctx, uuid.NewString()) {
+			t.Errorf("IsRevoked() of other grant = true")
+		}
+	})
+
+	t.Run("Token until expiry", func(t *testing.T) {
+		revocations := newTestRevocationList(t)
+		if err := revocations.RevokeToken(ctx, "token", time.Now().Add(20*time.Millisecond)); err != nil {
+			t.Fatalf("RevokeToken() error = %v", err)
+		}
+		if !revocations.IsRevoked(ctx, "token") {
+			t.Errorf("IsRevoked() = false, want true")
+		}
+		// the token is rejected for its expiry anyway
+		time.Sleep(40 * time.Millisecond)
+		if revocations.IsRevoked(ctx, "token") {
+			t.Errorf("IsRevoked() after expiry = true, want false")
+		}
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		store := core.NewInMemoryKeyValueStore[time.Time]()
+		t.Cleanup(store.Close)
+		recording := &recordingRevocationStore{RevocationStore: store}
+		revocations := NewRevocationList(recording, time.Hour)
+		if err := revocations.RevokeToken(ctx, "token", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("RevokeToken() error = %v", err)
+		}
+		for _, ttl := range recording.ttls {
+			if ttl <= 0 {
+				t.Errorf("RevokeToken() stored the token with ttl %s", ttl)
+			}
+		}
+	})
+
+	t.Run("Grant until retention", func(t *testing.T) {
+		store := core.NewInMemoryKeyValueStore[time.Time]()
+		t.Cleanup(store.Close)
+		revocations := NewRevocationList(store, 20*time.Millisecond)
+		grantId := uuid.New()
+		revocations.RevokeGrant(ctx, grantId)
+		time.Sleep(40 * time.Millisecond)
+		if revocations.IsRevoked(ctx, grantId.String()) {
+			t.Errorf("IsRevoked() after retention = true, want false")
+		}
+	})
+
+	t.Run("Shared store", func(t *testing.T) {
+		db := newTestDB(t, &gormLocal.KeyValueModel{})
+
+		// replicas do not share memory, only the database
+		replicas := []*RevocationList{
+			NewRevocationList(gormLocal.NewGormKeyValueStore[time.Time](db, "revocation", core.JSONCodec[time.Time]{}), time.Hour),
+			NewRevocationList(gormLocal.NewGormKeyValueStore[time.Time](db, "revocation", core.JSONCodec[time.Time]{}), time.Hour),
+		}
+		grantId := uuid.New()
+		if err := replicas[0].RevokeGrant(ctx, grantId); err != nil {
+			t.Fatalf("RevokeGrant() error = %v", err)
+		}
+		if err := replicas[0].RevokeToken(ctx, "token", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RevokeToken() error = %v", err)
+		}
+		if !replicas[1].IsRevoked(ctx, grantId.String()) || !replicas[1].IsRevoked(ctx, "token") {
+			t.Errorf("revocation not visible to other replica")
+		}
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now(), Methods: []string{"hwk"}}
+	issue := func(t *testing.T, service *testTokenService) *TokenResponse {
+		t.Helper()
+		response, err := service.Token(ctx, testClient, newCodeTokenRequest(newTestCode(t, service, authentication, "api")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("Access token", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		if err := service.Revoke(ctx, testClient, &RevocationRequest{Token: response.AccessToken}); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if _, err := service.accessTokenHandler.Validate(ctx, response.AccessToken); err == nil {
+			t.Errorf("revoked access token is valid")
+		}
+		// revoking an access token does not end the grant
+		if _, err := service.refreshTokenHandler.Validate(ctx, response.RefreshToken); err != nil {
+			t.Errorf("refresh token of the grant is invalid: %v", err)
+		}
+	})
+
+	t.Run("Refresh token", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		if err := service.Revoke(ctx, testClient, &RevocationRequest{Token: response.RefreshToken, TokenTypeHint: "refresh_token"}); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if _, err := service.refreshTokenHandler.Validate(ctx, response.RefreshToken); err == nil {
+			t.Errorf("revoked refresh token is valid")
+		}
+		if _, err := service.accessTokenHandler.Validate(ctx, response.AccessToken); err == nil {
+			t.Errorf("access token of the revoked grant is valid")
+		}
+	})
+
+	t.Run("Refresh token with wrong hint", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		if err := service.Revoke(ctx, testClient, &RevocationRequest{Token: response.RefreshToken, TokenTypeHint: "access_token"}); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if _, err := service.accessTokenHandler.Validate(ctx, response.AccessToken); err == nil {
+			t.Errorf("access token of the revoked grant is valid")
+		}
+	})
+
+	t.Run("Token of other client", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		other := &Client{ID: "other", TokenEndpointAuthMethod: AuthMethodClientSecretBasic}
+		err := service.Revoke(ctx, other, &RevocationRequest{Token: response.RefreshToken})
+		if err == nil || err.ErrorType != "unauthorized_client" {
+			t.Errorf("Revoke() error = %v, want unauthorized_client", err)
+		}
+		if _, err := service.refreshTokenHandler.Validate(ctx, response.RefreshToken); err != nil {
+			t.Errorf("refresh token revoked by other client: %v", err)
+		}
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		service := newTestTokenService(t)
+		if err := service.Revoke(ctx, testClient, &RevocationRequest{Token: "mat_unknown"}); err != nil {
+			t.Errorf("Revoke() error = %v, want nil", err)
+		}
+	})
+}
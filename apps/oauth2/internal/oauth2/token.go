@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/Untanky/modern-auth/internal/core"
@@ -18,7 +19,6 @@ type TokenRequest interface {
 
 type tokenRequest struct {
 	GrantType string `form:"grant_type" binding:"required"`
-	ClientId  string `form:"client_id" binding:"required"`
 }
 
 func (r *tokenRequest) GetGrantType() string {
@@ -37,6 +37,11 @@ type RefreshTokenRequest struct {
 	RefreshToken string `form:"refresh_token" binding:"required"`
 }
 
+type ClientCredentialsTokenRequest struct {
+	tokenRequest
+	Scope string `form:"scope"`
+}
+
 type timestamp time.Time
 
 func (t *timestamp) MarshalJSON() ([]byte, error) {
@@ -52,6 +57,20 @@ type AuthorizationGrant struct {
 	IssuedAt          timestamp `json:"iat"`
 	ExpiresAt         timestamp `json:"exp"`
 	NotBefore         timestamp `json:"nbf,omitempty"`
+	// OpenID Connect properties of the authentication the grant is based on
+	Nonce                 string    `json:"-"`
+	AuthenticatedAt       time.Time `json:"-"`
+	AuthenticationMethods []string  `json:"-"`
+	AuthenticationContext string    `json:"-"`
+}
+
+func (g *AuthorizationGrant) HasScope(scope string) bool {
+	for _, s := range strings.Fields(g.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
 type TokenResponse struct {
@@ -60,6 +79,23 @@ type TokenResponse struct {
 	ExpiresIn    int    `json:"expires_in" binding:"required"`
 	Scope        string `json:"scope" binding:"required"`
 	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+type IntrospectionRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+}
+
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
 }
 
 type TokenError struct {
@@ -71,27 +107,56 @@ func (e *TokenError) Error() string {
 	return e.ErrorDescription
 }
 
+// AccessTokenLifetime is the time access tokens and ID tokens remain valid
+const AccessTokenLifetime = time.Hour
+
+// redeemedCodeRetention is the time redeemed authorization codes are
+// remembered to detect replays
+const redeemedCodeRetention = 10 * time.Minute
+
+// UsedRefreshTokenStore maps the hash of exchanged refresh tokens to the id
+// of their grant.
+type UsedRefreshTokenStore = core.KeyValueStore[string, uuid.UUID]
+
 type OAuthTokenService struct {
-	codeStore              CodeStore
-	accessTokenHandler     TokenHandler
-	refreshTokenHandler    TokenHandler
-	logger                 *slog.Logger
-	tokenRequestInstrument metric.Int64Counter
+	codeStore                   CodeStore
+	accessTokenHandler          TokenHandler
+	refreshTokenHandler         TokenHandler
+	openIDProvider              *OpenIDProvider
+	deviceService               *DeviceAuthorizationService
+	revocations                 *RevocationList
+	usedRefreshTokenStore       UsedRefreshTokenStore
+	usedRefreshTokenRetention   time.Duration
+	secretHasher                *core.SecretHasher
+	logger                      *slog.Logger
+	tokenRequestInstrument      metric.Int64Counter
+	refreshTokenReuseInstrument metric.Int64Counter
 }
 
-func NewOAuthTokenService(codeStore CodeStore, accessTokenHandler TokenHandler, refreshTokenHandler TokenHandler, tokenRequestInstrument metric.Int64Counter) *OAuthTokenService {
+// NewOAuthTokenService creates a token service. Exchanged refresh tokens are
+// remembered for usedRefreshTokenRetention, which must be at least the
+// lifetime of refresh tokens to detect their reuse.
+func NewOAuthTokenService(codeStore CodeStore, accessTokenHandler TokenHandler, refreshTokenHandler TokenHandler, openIDProvider *OpenIDProvider, deviceService *DeviceAuthorizationService, revocations *RevocationList, usedRefreshTokenStore UsedRefreshTokenStore, usedRefreshTokenRetention time.Duration, secretHasher *core.SecretHasher, tokenRequestInstrument metric.Int64Counter, refreshTokenReuseInstrument metric.Int64Counter) *OAuthTokenService {
 	logger := slog.Default().With(slog.String("service", "oauth-token"))
 
 	return &OAuthTokenService{
-		codeStore:              codeStore,
-		accessTokenHandler:     accessTokenHandler,
-		refreshTokenHandler:    refreshTokenHandler,
-		logger:                 logger,
-		tokenRequestInstrument: tokenRequestInstrument,
+		codeStore:                   codeStore,
+		accessTokenHandler:          accessTokenHandler,
+		refreshTokenHandler:         refreshTokenHandler,
+		openIDProvider:              openIDProvider,
+		deviceService:               deviceService,
+		revocations:                 revocations,
+		usedRefreshTokenStore:       usedRefreshTokenStore,
+		usedRefreshTokenRetention:   usedRefreshTokenRetention,
+		secretHasher:                secretHasher,
+		logger:                      logger,
+		tokenRequestInstrument:      tokenRequestInstrument,
+		refreshTokenReuseInstrument: refreshTokenReuseInstrument,
 	}
 }
 
-func (s *OAuthTokenService) Token(ctx context.Context, request TokenRequest) (*TokenResponse, *TokenError) {
+// Token handles a token request of the given, already authenticated client.
+func (s *OAuthTokenService) Token(ctx context.Context, client *Client, request TokenRequest) (*TokenResponse, *TokenError) {
 	// validate request
 	var grant *AuthorizationGrant
 	var err *TokenError
@@ -99,9 +164,13 @@ func (s *OAuthTokenService) Token(ctx context.Context, request TokenRequest) (*T
 
 	switch actualRequest := request.(type) {
 	case *AuthorizationCodeTokenRequest:
-		grant, err = s.authorizationCodeToken(ctx, actualRequest)
+		grant, err = s.authorizationCodeToken(ctx, client, actualRequest)
 	case *RefreshTokenRequest:
-		grant, err = s.refreshToken(ctx, actualRequest)
+		grant, err = s.refreshToken(ctx, client, actualRequest)
+	case *ClientCredentialsTokenRequest:
+		grant, err = s.clientCredentialsToken(ctx, client, actualRequest)
+	case *DeviceCodeTokenRequest:
+		grant, err = s.deviceCodeToken(ctx, client, actualRequest)
 	default:
 		return nil, &TokenError{
 			ErrorType:        "unsupported_grant_type",
@@ -133,6 +202,18 @@ func (s *OAuthTokenService) Token(ctx context.Context, request TokenRequest) (*T
 			s.logger.Debug("Generated refresh token", "authorization_id", grant.ID)
 		}
 	}
+
+	var idToken string
+	if grant.HasScope(OpenIDScope) {
+		idToken, e = s.openIDProvider.IssueIDToken(ctx, grant, accessToken)
+		if e != nil {
+			return nil, &TokenError{
+				ErrorType:        "server_error",
+				ErrorDescription: "failed to generate id token",
+			}
+		}
+		s.logger.Debug("Generated ID token", "authorization_id", grant.ID)
+	}
 	s.tokenRequestInstrument.Add(ctx, 1, metric.WithAttributes(attribute.Key("client_id").String(grant.ClientId), attribute.Key("grant_type").String(request.GetGrantType())))
 
 	return &TokenResponse{
@@ -141,10 +222,11 @@ func (s *OAuthTokenService) Token(ctx context.Context, request TokenRequest) (*T
 		ExpiresIn:    int(-time.Since(time.Time(grant.ExpiresAt)).Seconds()),
 		RefreshToken: refreshToken,
 		Scope:        grant.Scope,
+		IDToken:      idToken,
 	}, nil
 }
 
-func (s *OAuthTokenService) authorizationCodeToken(ctx context.Context, tokenRequest *AuthorizationCodeTokenRequest) (*AuthorizationGrant, *TokenError) {
+func (s *OAuthTokenService) authorizationCodeToken(ctx context.Context, client *Client, tokenRequest *AuthorizationCodeTokenRequest) (*AuthorizationGrant, *TokenError) {
 	if tokenRequest.GrantType != "authorization_code" {
 		return nil, &TokenError{
 			ErrorType:        "unsupported_grant_type",
@@ -152,15 +234,23 @@ func (s *OAuthTokenService) authorizationCodeToken(ctx context.Context, tokenReq
 		}
 	}
 
-	authorizationRequest, err := s.codeStore.WithContext(ctx).Get(tokenRequest.Code)
+	codeStore := s.codeStore.WithContext(ctx)
+	authorizationRequest, err := codeStore.GetAndDelete(tokenRequest.Code)
 	if err != nil {
+		s.detectCodeReplay(ctx, client, tokenRequest.Code)
 		return nil, &TokenError{
 			ErrorType:        "invalid_grant",
 			ErrorDescription: "authorization code not found",
 		}
 	}
 
-	if authorizationRequest.ClientId != tokenRequest.ClientId {
+	// remember the redeemed code, so that a replay can be detected
+	err = codeStore.SetWithTTL(redeemedCodeKey(tokenRequest.Code), authorizationRequest, redeemedCodeRetention)
+	if err != nil {
+		s.logger.Warn("Failed to remember redeemed code", "err", err, "authorization_id", authorizationRequest.id)
+	}
+
+	if authorizationRequest.ClientId != client.ID {
 		return nil, &TokenError{
 			ErrorType:        "invalid_grant",
 			ErrorDescription: "client id does not match",
@@ -181,24 +271,36 @@ func (s *OAuthTokenService) authorizationCodeToken(ctx context.Context, tokenReq
 		}
 	}
 
+	authentication := authorizationRequest.authentication
+	if authentication == nil {
+		return nil, &TokenError{
+			ErrorType:        "invalid_grant",
+			ErrorDescription: "authorization code not authenticated",
+		}
+	}
+
 	s.logger.Info("Successfully validated 'authorization_code' token request",
-		"client_id", tokenRequest.ClientId,
+		"client_id", client.ID,
 		"grant_type", "authorization_code",
 		"authorization_id", authorizationRequest.id,
 	)
 
 	return &AuthorizationGrant{
-		IssueRefreshToken: true,
-		ID:                authorizationRequest.id,
-		Scope:             authorizationRequest.Scope,
-		ClientId:          authorizationRequest.ClientId,
-		SubjectId:         "xyz",
-		IssuedAt:          timestamp(time.Now()),
-		ExpiresAt:         timestamp(time.Now().Add(time.Hour)),
+		IssueRefreshToken:     true,
+		ID:                    authorizationRequest.id,
+		Scope:                 authorizationRequest.Scope,
+		ClientId:              authorizationRequest.ClientId,
+		SubjectId:             authentication.SubjectID.String(),
+		IssuedAt:              timestamp(time.Now()),
+		ExpiresAt:             timestamp(time.Now().Add(AccessTokenLifetime)),
+		Nonce:                 authorizationRequest.Nonce,
+		AuthenticatedAt:       authentication.AuthenticatedAt,
+		AuthenticationMethods: authentication.Methods,
+		AuthenticationContext: authentication.ContextClass,
 	}, nil
 }
 
-func (s *OAuthTokenService) refreshToken(ctx context.Context, tokenRequest *RefreshTokenRequest) (*AuthorizationGrant, *TokenError) {
+func (s *OAuthTokenService) refreshToken(ctx context.Context, client *Client, tokenRequest *RefreshTokenRequest) (*AuthorizationGrant, *TokenError) {
 	if tokenRequest.GrantType != "refresh_token" {
 		return nil, &TokenError{
 			ErrorType:        "unsupported_grant_type",
@@ -208,64 +310,369 @@ func (s *OAuthTokenService) refreshToken(ctx context.Context, tokenRequest *Refr
 
 	grant, err := s.refreshTokenHandler.Validate(ctx, tokenRequest.RefreshToken)
 	if err != nil {
+		s.detectRefreshTokenReuse(ctx, client, tokenRequest.RefreshToken)
 		return nil, &TokenError{
 			ErrorType:        "invalid_grant",
 			ErrorDescription: "refresh token not found",
 		}
 	}
 
-	if grant.ClientId != tokenRequest.ClientId {
+	if grant.ClientId != client.ID {
 		return nil, &TokenError{
 			ErrorType:        "invalid_grant",
 			ErrorDescription: "client id does not match",
 		}
 	}
 
+	if client.RotateRefreshTokens {
+		// remember the used token, so that a replay can be detected. Marking
+		// it as used is atomic, so that concurrent requests with the same
+		// token cannot both exchange it.
+		usedTokenKey := s.secretHasher.Secret(tokenRequest.RefreshToken).String()
+		var firstUse bool
+		firstUse, err = s.usedRefreshTokenStore.WithContext(ctx).SetIfAbsent(usedTokenKey, grant.ID, s.usedRefreshTokenRetention)
+		if err == nil && !firstUse {
+			s.detectRefreshTokenReuse(ctx, client, tokenRequest.RefreshToken)
+			return nil, &TokenError{
+				ErrorType:        "invalid_grant",
+				ErrorDescription: "refresh token not found",
+			}
+		}
+		if err == nil {
+			err = s.refreshTokenHandler.Revoke(ctx, tokenRequest.RefreshToken)
+		}
+		if err != nil {
+			return nil, &TokenError{
+				ErrorType:        "server_error",
+				ErrorDescription: "failed to rotate refresh token",
+			}
+		}
+	}
+
 	s.logger.Info("Successfully validated 'refresh_token' token request",
-		"client_id", tokenRequest.ClientId,
+		"client_id", client.ID,
 		"grant_type", "refresh_token",
 		"authorization_id", grant.ID,
 	)
 
+	// the new refresh token keeps the grant id, so that all tokens of the
+	// family are revoked together
+	return &AuthorizationGrant{
+		IssueRefreshToken:     client.RotateRefreshTokens,
+		ID:                    grant.ID,
+		Scope:                 grant.Scope,
+		ClientId:              grant.ClientId,
+		SubjectId:             grant.SubjectId,
+		IssuedAt:              timestamp(time.Now()),
+		ExpiresAt:             timestamp(time.Now().Add(AccessTokenLifetime)),
+		NotBefore:             timestamp(time.Now()),
+		AuthenticatedAt:       grant.AuthenticatedAt,
+		AuthenticationMethods: grant.AuthenticationMethods,
+		AuthenticationContext: grant.AuthenticationContext,
+	}, nil
+}
+
+// detectCodeReplay revokes all tokens issued for an authorization code that
+// is redeemed a second time (RFC 6749, section 4.1.2).
+func (s *OAuthTokenService) detectCodeReplay(ctx context.Context, client *Client, code string) {
+	authorizationRequest, err := s.codeStore.WithContext(ctx).Get(redeemedCodeKey(code))
+	if err != nil {
+		return
+	}
+
+	s.logger.Warn("Security event: authorization code replayed, revoking grant",
+		"event", "authorization_code_replay",
+		"client_id", client.ID,
+		"authorization_id", authorizationRequest.id,
+	)
+	err = s.revocations.RevokeGrant(ctx, authorizationRequest.id)
+	if err != nil {
+		s.logger.Error("Failed to revoke grant", "err", err, "authorization_id", authorizationRequest.id)
+	}
+}
+
+func redeemedCodeKey(code string) string {
+	return "redeemed:" + code
+}
+
+// detectRefreshTokenReuse revokes the whole token family when a refresh
+// token is presented that has already been exchanged. Either the client or an
+// attacker holds a stolen token, and it is impossible to tell which one.
+func (s *OAuthTokenService) detectRefreshTokenReuse(ctx context.Context, client *Client, refreshToken string) {
+	var grantId uuid.UUID
+	var err error
+	for _, usedTokenKey := range s.secretHasher.Secret(refreshToken).Hashes() {
+		grantId, err = s.usedRefreshTokenStore.WithContext(ctx).Get(usedTokenKey)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	s.logger.Warn("Security event: refresh token reused, revoking token family",
+		"event", "refresh_token_reuse",
+		"client_id", client.ID,
+		"authorization_id", grantId,
+	)
+	s.refreshTokenReuseInstrument.Add(ctx, 1, metric.WithAttributes(attribute.Key("client_id").String(client.ID)))
+	err = s.revocations.RevokeGrant(ctx, grantId)
+	if err != nil {
+		s.logger.Error("Failed to revoke token family", "err", err, "authorization_id", grantId)
+	}
+}
+
+func (s *OAuthTokenService) clientCredentialsToken(ctx context.Context, client *Client, tokenRequest *ClientCredentialsTokenRequest) (*AuthorizationGrant, *TokenError) {
+	if tokenRequest.GrantType != "client_credentials" {
+		return nil, &TokenError{
+			ErrorType:        "unsupported_grant_type",
+			ErrorDescription: "grant type not supported",
+		}
+	}
+
+	if !client.IsConfidential() {
+		return nil, &TokenError{
+			ErrorType:        "unauthorized_client",
+			ErrorDescription: "public clients may not use client credentials",
+		}
+	}
+
+	// there is no end-user authentication to issue an ID token for, so
+	// openid is neither granted by default nor allowed to be requested
+	scopes := make([]string, 0, len(client.Scopes))
+	for _, scope := range client.Scopes {
+		if scope != OpenIDScope {
+			scopes = append(scopes, scope)
+		}
+	}
+	if tokenRequest.Scope != "" {
+		requestedScopes := strings.Fields(tokenRequest.Scope)
+		for _, scope := range requestedScopes {
+			if scope == OpenIDScope {
+				return nil, &TokenError{
+					ErrorType:        "invalid_scope",
+					ErrorDescription: "scope openid not allowed for client credentials",
+				}
+			}
+		}
+		scopes = client.RestrictScopes(ctx, requestedScopes)
+		if len(scopes) != len(requestedScopes) {
+			return nil, &TokenError{
+				ErrorType:        "invalid_scope",
+				ErrorDescription: "scope not allowed for client",
+			}
+		}
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, &TokenError{
+			ErrorType:        "server_error",
+			ErrorDescription: "failed to create grant",
+		}
+	}
+
+	s.logger.Info("Successfully validated 'client_credentials' token request",
+		"client_id", client.ID,
+		"grant_type", "client_credentials",
+		"authorization_id", id,
+	)
+
+	// RFC 6749, section 4.4.3: a refresh token should not be included
 	return &AuthorizationGrant{
 		IssueRefreshToken: false,
-		ID:                grant.ID,
-		Scope:             grant.Scope,
-		ClientId:          grant.ClientId,
-		SubjectId:         grant.SubjectId,
+		ID:                id,
+		Scope:             strings.Join(scopes, " "),
+		ClientId:          client.ID,
+		SubjectId:         client.ID,
 		IssuedAt:          timestamp(time.Now()),
-		ExpiresAt:         timestamp(time.Now().Add(time.Hour)),
+		ExpiresAt:         timestamp(time.Now().Add(AccessTokenLifetime)),
 		NotBefore:         timestamp(time.Now()),
 	}, nil
 }
 
+func (s *OAuthTokenService) deviceCodeToken(ctx context.Context, client *Client, tokenRequest *DeviceCodeTokenRequest) (*AuthorizationGrant, *TokenError) {
+	if tokenRequest.GrantType != DeviceCodeGrantType {
+		return nil, &TokenError{
+			ErrorType:        "unsupported_grant_type",
+			ErrorDescription: "grant type not supported",
+		}
+	}
+
+	deviceAuthorization, err := s.deviceService.Poll(ctx, client, tokenRequest.DeviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Successfully validated 'device_code' token request",
+		"client_id", client.ID,
+		"grant_type", DeviceCodeGrantType,
+		"authorization_id", deviceAuthorization.id,
+	)
+
+	authentication := deviceAuthorization.authentication
+	return &AuthorizationGrant{
+		IssueRefreshToken:     true,
+		ID:                    deviceAuthorization.id,
+		Scope:                 deviceAuthorization.Scope,
+		ClientId:              deviceAuthorization.ClientId,
+		SubjectId:             authentication.SubjectID.String(),
+		IssuedAt:              timestamp(time.Now()),
+		ExpiresAt:             timestamp(time.Now().Add(AccessTokenLifetime)),
+		AuthenticatedAt:       authentication.AuthenticatedAt,
+		AuthenticationMethods: authentication.Methods,
+		AuthenticationContext: authentication.ContextClass,
+	}, nil
+}
+
 func (s *OAuthTokenService) Validate(ctx context.Context, token string) (*AuthorizationGrant, error) {
 	return s.accessTokenHandler.Validate(ctx, token)
 }
 
+// Introspect returns the state of an access or refresh token (RFC 7662).
+// Unknown, expired or otherwise invalid tokens are reported as inactive.
+func (s *OAuthTokenService) Introspect(ctx context.Context, client *Client, request *IntrospectionRequest) *IntrospectionResponse {
+	// the hint only determines which token type is looked up first
+	lookupRefreshTokenFirst := request.TokenTypeHint == "refresh_token"
+
+	response := s.introspectAccessToken(ctx, request.Token)
+	if lookupRefreshTokenFirst || !response.Active {
+		refreshResponse := s.introspectRefreshToken(ctx, request.Token)
+		if refreshResponse.Active {
+			response = refreshResponse
+		}
+	}
+
+	s.logger.Info("Introspected token", "client_id", client.ID, "active", response.Active, "token_type", response.TokenType)
+	return response
+}
+
+func (s *OAuthTokenService) introspectAccessToken(ctx context.Context, token string) *IntrospectionResponse {
+	grant, err := s.accessTokenHandler.Validate(ctx, token)
+	if err != nil || !time.Now().Before(time.Time(grant.ExpiresAt)) {
+		return &IntrospectionResponse{Active: false}
+	}
+
+	response := &IntrospectionResponse{
+		Active:    true,
+		Scope:     grant.Scope,
+		ClientId:  grant.ClientId,
+		Subject:   grant.SubjectId,
+		TokenType: "Bearer",
+		ExpiresAt: time.Time(grant.ExpiresAt).Unix(),
+		IssuedAt:  time.Time(grant.IssuedAt).Unix(),
+	}
+	if !time.Time(grant.NotBefore).IsZero() {
+		response.NotBefore = time.Time(grant.NotBefore).Unix()
+	}
+	return response
+}
+
+func (s *OAuthTokenService) introspectRefreshToken(ctx context.Context, token string) *IntrospectionResponse {
+	grant, err := s.refreshTokenHandler.Validate(ctx, token)
+	if err != nil {
+		return &IntrospectionResponse{Active: false}
+	}
+
+	// refresh tokens do not expire with the access token of their grant
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     grant.Scope,
+		ClientId:  grant.ClientId,
+		Subject:   grant.SubjectId,
+		TokenType: "refresh_token",
+		IssuedAt:  time.Time(grant.IssuedAt).Unix(),
+	}
+}
+
+// Revoke revokes an access or refresh token of the client (RFC 7009).
+// Revoking a refresh token revokes the whole grant, which invalidates all
+// access tokens issued for it. Unknown tokens are ignored.
+func (s *OAuthTokenService) Revoke(ctx context.Context, client *Client, request *RevocationRequest) *TokenError {
+	handlers := []TokenHandler{s.accessTokenHandler, s.refreshTokenHandler}
+	if request.TokenTypeHint == "refresh_token" {
+		handlers = []TokenHandler{s.refreshTokenHandler, s.accessTokenHandler}
+	}
+
+	for _, handler := range handlers {
+		grant, err := handler.Validate(ctx, request.Token)
+		if err != nil {
+			continue
+		}
+
+		if grant.ClientId != client.ID {
+			s.logger.Warn("Client tried to revoke token of another client", "client_id", client.ID, "authorization_id", grant.ID)
+			return &TokenError{
+				ErrorType:        "unauthorized_client",
+				ErrorDescription: "token was not issued to the client",
+			}
+		}
+
+		err = handler.Revoke(ctx, request.Token)
+		if err == nil && handler == s.refreshTokenHandler {
+			err = s.revocations.RevokeGrant(ctx, grant.ID)
+		}
+		if err != nil {
+			return &TokenError{
+				ErrorType:        "server_error",
+				ErrorDescription: "failed to revoke token",
+			}
+		}
+
+		s.logger.Info("Revoked token", "client_id", client.ID, "authorization_id", grant.ID)
+		return nil
+	}
+
+	s.logger.Debug("Token to revoke not found", "client_id", client.ID)
+	return nil
+}
+
 type TokenHandler interface {
 	GenerateToken(ctx context.Context, grant *AuthorizationGrant) (string, error)
 	Validate(ctx context.Context, token string) (*AuthorizationGrant, error)
+	// Revoke invalidates a single token. Tokens of the same grant stay valid.
+	Revoke(ctx context.Context, token string) error
 }
 
 type TokenStore = core.KeyValueStore[string, *AuthorizationGrant]
 
+type grantExpiryTokenStore struct {
+	TokenStore
+}
+
+// WithGrantExpiry returns a token store that keeps tokens set with Set until
+// their grant expires, like a GormTokenStore without a lifetime.
+func WithGrantExpiry(store TokenStore) TokenStore {
+	return &grantExpiryTokenStore{TokenStore: store}
+}
+
+func (s *grantExpiryTokenStore) Set(key string, grant *AuthorizationGrant) error {
+	return s.TokenStore.SetWithTTL(key, grant, time.Until(time.Time(grant.ExpiresAt)))
+}
+
+func (s *grantExpiryTokenStore) WithContext(ctx context.Context) core.KeyValueStore[string, *AuthorizationGrant] {
+	return &grantExpiryTokenStore{TokenStore: s.TokenStore.WithContext(ctx)}
+}
+
 type RandomTokenHandler struct {
-	tokenSize       int
+	generator       *core.TokenGenerator
+	secretHasher    *core.SecretHasher
 	store           TokenStore
+	revocations     *RevocationList
 	logger          *slog.Logger
 	tokensGenerated metric.Int64Counter
 }
 
-func NewRandomTokenHandler(tokenType string, tokenSize int, store TokenStore, tokensGenerated metric.Int64Counter) *RandomTokenHandler {
+func NewRandomTokenHandler(tokenType string, generator *core.TokenGenerator, secretHasher *core.SecretHasher, store TokenStore, revocations *RevocationList, tokensGenerated metric.Int64Counter) *RandomTokenHandler {
 	logger := slog.Default().With(slog.String("service", "token-handler"), slog.String("type", tokenType))
 
-	return &RandomTokenHandler{tokenSize: tokenSize, store: store, logger: logger, tokensGenerated: tokensGenerated}
+	return &RandomTokenHandler{generator: generator, secretHasher: secretHasher, store: store, revocations: revocations, logger: logger, tokensGenerated: tokensGenerated}
 }
 
 func (h *RandomTokenHandler) GenerateToken(ctx context.Context, grant *AuthorizationGrant) (string, error) {
-	token := randomString(h.tokenSize)
-	secret := core.NewSecretValue(token)
+	token := h.generator.Generate()
+	secret := h.secretHasher.Secret(token)
 	err := h.store.WithContext(ctx).Set(secret.String(), grant)
 	if err != nil {
 		return "", err
@@ -276,11 +683,45 @@ func (h *RandomTokenHandler) GenerateToken(ctx context.Context, grant *Authoriza
 }
 
 func (h *RandomTokenHandler) Validate(ctx context.Context, token string) (*AuthorizationGrant, error) {
-	secret := core.NewSecretValue(token)
-	grant, err := h.store.WithContext(ctx).Get(secret.String())
+	grant, err := h.find(ctx, token)
 	if err != nil {
 		return nil, err
 	}
+	if h.revocations.IsRevoked(ctx, grant.ID.String()) {
+		return nil, fmt.Errorf("grant %s has been revoked", grant.ID)
+	}
 	h.logger.Debug("Successfully validated token", "authorization_id", grant.ID)
 	return grant, err
 }
+
+// find looks up the grant by the hash of the token. Tokens issued before
+// the secret hashing was changed are stored by a hash in an older version.
+// Tokens issued before they had a prefix and checksum are only rejected by
+// the lookup.
+func (h *RandomTokenHandler) find(ctx context.Context, token string) (*AuthorizationGrant, error) {
+	if h.generator.HasPrefix(token) && !h.generator.IsWellFormed(token) {
+		return nil, fmt.Errorf("malformed token")
+	}
+	store := h.store.WithContext(ctx)
+	var err error
+	for _, hash := range h.secretHasher.Secret(token).Hashes() {
+		var grant *AuthorizationGrant
+		grant, err = store.Get(hash)
+		if err == nil {
+			return grant, nil
+		}
+	}
+	return nil, err
+}
+
+func (h *RandomTokenHandler) Revoke(ctx context.Context, token string) error {
+	store := h.store.WithContext(ctx)
+	for _, hash := range h.secretHasher.Secret(token).Hashes() {
+		err := store.Delete(hash)
+		if err != nil {
+			return err
+		}
+	}
+	h.logger.Debug("Revoked token")
+	return nil
+}
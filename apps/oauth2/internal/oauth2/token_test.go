@@ -0,0 +1,495 @@
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+)
+
+type memoryUserRepository struct {
+	mutex sync.Mutex
+	users map[string]*domain.User
+}
+
+func newMemoryUserRepository(users ...*domain.User) *memoryUserRepository {
+	repo := &memoryUserRepository{users: make(map[string]*domain.User)}
+	for _, user := range users {
+		repo.users[user.ID.String()] = user
+	}
+	return repo
+}
+
+func (r *memoryUserRepository) FindAll(ctx context.Context) ([]*domain.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var users []*domain.User
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) FindById(ctx context.Context, id string) (*domain.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepository) Save(ctx context.Context, user *domain.User) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.users[user.ID.String()] = user
+	return nil
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, user *domain.User) error {
+	return r.Save(ctx, user)
+}
+
+func (r *memoryUserRepository) DeleteById(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.users, id)
+	return nil
+}
+
+func (r *memoryUserRepository) FindByUserId(ctx context.Context, userId []byte) (*domain.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+func (r *memoryUserRepository) FindByHandle(ctx context.Context, handle []byte) (*domain.User, error) {
+	return nil, fmt.Errorf("user not found")
+}
+
+func (r *memoryUserRepository) ExistsUserId(ctx context.Context, userId []byte) (bool, error) {
+	return false, nil
+}
+
+type testTokenService struct {
+	*OAuthTokenService
+	codeStore             *core.InMemoryKeyValueStore[*AuthorizationRequest]
+	usedRefreshTokenStore *core.InMemoryKeyValueStore[uuid.UUID]
+}
+
+// newTestTokenService creates a token service issuing opaque access and
+// refresh tokens, with all stores in memory.
+func newTestTokenService(t *testing.T, users ...*domain.User) *testTokenService {
+	t.Helper()
+	codeStore := core.NewInMemoryKeyValueStore[*AuthorizationRequest]()
+	usedRefreshTokenStore := core.NewInMemoryKeyValueStore[uuid.UUID]()
+	accessTokenStore := core.NewInMemoryKeyValueStore[*AuthorizationGrant]()
+	refreshTokenStore := core.NewInMemoryKeyValueStore[*AuthorizationGrant]()
+	deviceStore := core.NewInMemoryKeyValueStore[*DeviceAuthorization]()
+	userCodeStore := core.NewInMemoryKeyValueStore[string]()
+	attemptStore := core.NewInMemoryKeyValueStore[int]()
+	for _, store := range []interface{ Close() }{codeStore, usedRefreshTokenStore, accessTokenStore, refreshTokenStore, deviceStore, userCodeStore, attemptStore} {
+		t.Cleanup(store.Close)
+	}
+
+	revocations := newTestRevocationList(t)
+	secretHasher := newTestSecretHasher(t)
+	accessTokens := NewRandomTokenHandler(AccessTokenKind, core.NewTokenGenerator("mat", 256, true), secretHasher, accessTokenStore, revocations, newTestCounter(t))
+	refreshTokens := NewRandomTokenHandler(RefreshTokenKind, core.NewTokenGenerator("mrt", 256, true), secretHasher, refreshTokenStore, revocations, newTestCounter(t))
+	openIDProvider := NewOpenIDProvider(testIssuer, newTestKeySet(t), domain.NewUserService(newMemoryUserRepository(users...)))
+	deviceService := NewDeviceAuthorizationService(deviceStore, userCodeStore, attemptStore, secretHasher, "https://example.com/device", time.Second)
+
+	return &testTokenService{
+		OAuthTokenService:     NewOAuthTokenService(codeStore, accessTokens, refreshTokens, openIDProvider, deviceService, revocations, usedRefreshTokenStore, time.Hour, secretHasher, newTestCounter(t), newTestCounter(t)),
+		codeStore:             codeStore,
+		usedRefreshTokenStore: usedRefreshTokenStore,
+	}
+}
+
+func newTestCode(t *testing.T, service *testTokenService, authentication *domain.Authentication, scope string) string {
+	t.Helper()
+	code := authorizationCodeGenerator.Generate()
+	err := service.codeStore.Set(code, &AuthorizationRequest{
+		id:             uuid.New(),
+		authentication: authentication,
+		ClientId:       "client",
+		RedirectUri:    "https://client.example.com/callback",
+		Scope:          scope,
+		Nonce:          "nonce",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func newCodeTokenRequest(code string) *AuthorizationCodeTokenRequest {
+	return &AuthorizationCodeTokenRequest{
+		tokenRequest: tokenRequest{GrantType: "authorization_code"},
+		RedirectUri:  "https://client.example.com/callback",
+		Code:         code,
+	}
+}
+
+var testClient = &Client{ID: "client", Scopes: []string{"openid", "api"}, TokenEndpointAuthMethod: AuthMethodNone}
+
+func TestAuthorizationCodeToken(t *testing.T) {
+	service := newTestTokenService(t)
+	ctx := context.Background()
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now(), Methods: []string{"hwk"}}
+
+	t.Run("Success", func(t *testing.T) {
+		code := newTestCode(t, service, authentication, "api")
+		response, err := service.Token(ctx, testClient, newCodeTokenRequest(code))
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if response.AccessToken == "" || response.RefreshToken == "" {
+			t.Errorf("Token() = %+v, want access and refresh token", response)
+		}
+		if response.IDToken != "" {
+			t.Errorf("ID token issued without scope openid")
+		}
+
+		_, err = service.Token(ctx, testClient, newCodeTokenRequest(code))
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Token() with redeemed code error = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("Code without authentication", func(t *testing.T) {
+		code := newTestCode(t, service, nil, "api")
+		_, err := service.Token(ctx, testClient, newCodeTokenRequest(code))
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Token() error = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("Other client", func(t *testing.T) {
+		code := newTestCode(t, service, authentication, "api")
+		other := &Client{ID: "other", TokenEndpointAuthMethod: AuthMethodNone}
+		_, err := service.Token(ctx, other, newCodeTokenRequest(code))
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Token() error = %v, want invalid_grant", err)
+		}
+	})
+
+	t.Run("Other redirect uri", func(t *testing.T) {
+		code := newTestCode(t, service, authentication, "api")
+		request := newCodeTokenRequest(code)
+		request.RedirectUri = "https://attacker.example.com/callback"
+		_, err := service.Token(ctx, testClient, request)
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Errorf("Token() error = %v, want invalid_grant", err)
+		}
+	})
+}
+
+func TestIntrospect(t *testing.T) {
+	service := newTestTokenService(t)
+	ctx := context.Background()
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now(), Methods: []string{"hwk"}}
+	resourceServer := &Client{ID: "resource-server", TokenEndpointAuthMethod: AuthMethodClientSecretBasic}
+
+	response, tokenErr := service.Token(ctx, testClient, newCodeTokenRequest(newTestCode(t, service, authentication, "api")))
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+	expiredToken, err := service.accessTokenHandler.GenerateToken(ctx, &AuthorizationGrant{
+		ID:        uuid.New(),
+		Scope:     "api",
+		ClientId:  "client",
+		SubjectId: authentication.SubjectID.String(),
+		IssuedAt:  timestamp(time.Now().Add(-2 * time.Hour)),
+		ExpiresAt: timestamp(time.Now().Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		tokenTypeHint string
+		wantActive    bool
+		wantTokenType string
+	}{
+		{
+			name:          "Access token",
+			token:         response.AccessToken,
+			wantActive:    true,
+			wantTokenType: "Bearer",
+		},
+		{
+			name:          "Access token with refresh token hint",
+			token:         response.AccessToken,
+			tokenTypeHint: "refresh_token",
+			wantActive:    true,
+			wantTokenType: "Bearer",
+		},
+		{
+			name:          "Refresh token",
+			token:         response.RefreshToken,
+			wantActive:    true,
+			wantTokenType: "refresh_token",
+		},
+		{
+			name:          "Refresh token with access token hint",
+			token:         response.RefreshToken,
+			tokenTypeHint: "access_token",
+			wantActive:    true,
+			wantTokenType: "refresh_token",
+		},
+		{
+			name:          "Refresh token with unknown hint",
+			token:         response.RefreshToken,
+			tokenTypeHint: "id_token",
+			wantActive:    true,
+			wantTokenType: "refresh_token",
+		},
+		{
+			name:  "Expired access token",
+			token: expiredToken,
+		},
+		{
+			name:  "Unknown token",
+			token: "mat_unknown",
+		},
+		{
+			name:          "Unknown token with refresh token hint",
+			token:         "mrt_unknown",
+			tokenTypeHint: "refresh_token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			introspection := service.Introspect(ctx, resourceServer, &IntrospectionRequest{Token: tt.token, TokenTypeHint: tt.tokenTypeHint})
+			if introspection.Active != tt.wantActive {
+				t.Fatalf("Active = %v, want %v", introspection.Active, tt.wantActive)
+			}
+			if !tt.wantActive {
+				// inactive tokens must not disclose anything about the token
+				if *introspection != (IntrospectionResponse{}) {
+					t.Errorf("Introspect() = %+v, want only active false", introspection)
+				}
+				return
+			}
+			if introspection.TokenType != tt.wantTokenType {
+				t.Errorf("TokenType = %s, want %s", introspection.TokenType, tt.wantTokenType)
+			}
+			if introspection.ClientId != "client" || introspection.Subject != authentication.SubjectID.String() || introspection.Scope != "api" {
+				t.Errorf("Introspect() = %+v", introspection)
+			}
+		})
+	}
+
+	t.Run("Revoked grant", func(t *testing.T) {
+		tokenErr := service.Revoke(ctx, testClient, &RevocationRequest{Token: response.RefreshToken, TokenTypeHint: "refresh_token"})
+		if tokenErr != nil {
+			t.Fatalf("Revoke() error = %v", tokenErr)
+		}
+		for _, token := range []string{response.AccessToken, response.RefreshToken} {
+			if service.Introspect(ctx, resourceServer, &IntrospectionRequest{Token: token}).Active {
+				t.Errorf("token of revoked grant is active")
+			}
+		}
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	authentication := &domain.Authentication{SubjectID: uuid.New(), AuthenticatedAt: time.Now(), Methods: []string{"hwk"}}
+	rotatingClient := &Client{ID: "client", Scopes: []string{"openid", "api"}, TokenEndpointAuthMethod: AuthMethodNone, RotateRefreshTokens: true}
+	refresh := func(service *testTokenService, client *Client, refreshToken string) (*TokenResponse, *TokenError) {
+		return service.Token(ctx, client, &RefreshTokenRequest{tokenRequest: tokenRequest{GrantType: "refresh_token"}, RefreshToken: refreshToken})
+	}
+	issue := func(t *testing.T, service *testTokenService) *TokenResponse {
+		t.Helper()
+		response, err := service.Token(ctx, rotatingClient, newCodeTokenRequest(newTestCode(t, service, authentication, "api")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		rotated, err := refresh(service, rotatingClient, response.RefreshToken)
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if rotated.RefreshToken == "" || rotated.RefreshToken == response.RefreshToken {
+			t.Fatalf("refresh token not rotated")
+		}
+		if _, err := service.refreshTokenHandler.Validate(ctx, response.RefreshToken); err == nil {
+			t.Errorf("exchanged refresh token is valid")
+		}
+		if _, err := refresh(service, rotatingClient, rotated.RefreshToken); err != nil {
+			t.Errorf("Token() with rotated refresh token error = %v", err)
+		}
+	})
+
+	t.Run("Without rotation", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		refreshed, err := refresh(service, testClient, response.RefreshToken)
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if refreshed.RefreshToken != "" {
+			t.Errorf("refresh token issued without rotation")
+		}
+		if _, err := refresh(service, testClient, response.RefreshToken); err != nil {
+			t.Errorf("second Token() error = %v", err)
+		}
+	})
+
+	t.Run("Reuse revokes token family", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+		rotated, err := refresh(service, rotatingClient, response.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = refresh(service, rotatingClient, response.RefreshToken)
+		if err == nil || err.ErrorType != "invalid_grant" {
+			t.Fatalf("Token() with reused refresh token error = %v, want invalid_grant", err)
+		}
+		for _, token := range []string{response.AccessToken, rotated.AccessToken} {
+			if _, err := service.accessTokenHandler.Validate(ctx, token); err == nil {
+				t.Errorf("access token of revoked family is valid")
+			}
+		}
+		if _, err := refresh(service, rotatingClient, rotated.RefreshToken); err == nil {
+			t.Errorf("Token() with refresh token of revoked family succeeded")
+		}
+	})
+
+	t.Run("Concurrent exchange", func(t *testing.T) {
+		service := newTestTokenService(t)
+		response := issue(t, service)
+
+		const workers = 8
+		var errs [workers]*TokenError
+		var wg sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				_, errs[worker] = refresh(service, rotatingClient, response.RefreshToken)
+			}(worker)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("refresh token exchanged %d times, want once", succeeded)
+		}
+	})
+
+	t.Run("Used tokens expire", func(t *testing.T) {
+		service := newTestTokenService(t)
+		service.usedRefreshTokenRetention = 20 * time.Millisecond
+		response := issue(t, service)
+		if _, err := refresh(service, rotatingClient, response.RefreshToken); err != nil {
+			t.Fatal(err)
+		}
+
+		usedTokenKey := service.secretHasher.Secret(response.RefreshToken).String()
+		if _, err := service.usedRefreshTokenStore.Get(usedTokenKey); err != nil {
+			t.Fatalf("used refresh token not remembered: %v", err)
+		}
+		time.Sleep(40 * time.Millisecond)
+		if _, err := service.usedRefreshTokenStore.Get(usedTokenKey); err == nil {
+			t.Errorf("used refresh token remembered after retention")
+		}
+	})
+}
+
+func TestWithGrantExpiry(t *testing.T) {
+	backend := core.NewInMemoryKeyValueStore[*AuthorizationGrant]()
+	t.Cleanup(backend.Close)
+	store := WithGrantExpiry(backend).WithContext(context.Background())
+
+	grant := &AuthorizationGrant{ID: uuid.New(), ExpiresAt: timestamp(time.Now().Add(20 * time.Millisecond))}
+	if err := store.Set("token", grant); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := store.Get("token"); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := store.Get("token"); err == nil {
+		t.Errorf("Get() after the grant expired succeeded")
+	}
+}
+
+func TestRandomTokenHandlerValidate(t *testing.T) {
+	ctx := context.Background()
+	store := core.NewInMemoryKeyValueStore[*AuthorizationGrant]()
+	t.Cleanup(store.Close)
+	secretHasher := newTestSecretHasher(t)
+	handler := NewRandomTokenHandler(AccessTokenKind, core.NewTokenGenerator("mat", 256, true), secretHasher, store, newTestRevocationList(t), newTestCounter(t))
+	grant := &AuthorizationGrant{ID: uuid.New(), ClientId: "client", ExpiresAt: timestamp(time.Now().Add(time.Hour))}
+
+	token, err := handler.GenerateToken(ctx, grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens issued before tokens had a prefix and checksum
+	legacyToken := "Xh3kP0qLm9ZtR2vB7nW4yC8dF1gJ6sA5"
+	store.Set(secretHasher.Secret(legacyToken).String(), grant)
+	tampered := token[:len(token)-1] + "x"
+	if tampered == token {
+		tampered = token[:len(token)-1] + "y"
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "Generated token",
+			token: token,
+		},
+		{
+			name:  "Legacy token",
+			token: legacyToken,
+		},
+		{
+			name:    "Tampered token",
+			token:   tampered,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown legacy token",
+			token:   "unknown",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := handler.Validate(ctx, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.ID != grant.ID {
+				t.Errorf("Validate() = %v, want grant %v", got.ID, grant.ID)
+			}
+		})
+	}
+}
@@ -2,20 +2,17 @@ package oauth2
 
 import (
 	"crypto"
-	"math/rand"
+
+	"github.com/Untanky/modern-auth/internal/core"
 )
 
-// function to generate a random string
-//
-// Deprecated: use utils.RandomString instead
-func randomString(size int) string {
-	const randomStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, size)
-	for i := range b {
-		b[i] = randomStringChars[rand.Intn(len(randomStringChars))]
-	}
-	return string(b)
-}
+var (
+	authorizationCodeGenerator = core.NewTokenGenerator("", 256, false)
+	deviceCodeGenerator        = core.NewTokenGenerator("", 256, false)
+	// client secrets are long-lived and end up in configuration files, so
+	// they are identifiable by secret scanners
+	clientSecretGenerator = core.NewTokenGenerator("mcs", 256, true)
+)
 
 // function to hash a string
 //
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func (controller *controller) publishKeys(ctx *gin.Context) {
+	keySet := controller.keyManager.PublicKeys(ctx.Request.Context())
+
+	// resource servers cache the key set, but must pick up rotated keys in time
+	ctx.Header(CacheControlHeader, "public, max-age=300")
+	ctx.JSON(http.StatusOK, keySet)
+}
@@ -1,34 +1,81 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/Untanky/modern-auth/apps/oauth2/internal/oauth2"
 	"github.com/Untanky/modern-auth/internal/app"
 	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
 	ginApp "github.com/Untanky/modern-auth/internal/gin"
 	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/Untanky/modern-auth/internal/keys"
+	"github.com/Untanky/modern-auth/internal/storage"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/plugin/opentelemetry/tracing"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
 	ContextPath        = "/api/v1/oauth2"
 	CacheControlHeader = "cache-control"
+	Issuer             = "https://localhost:8080" + ContextPath
+)
+
+const (
+	OpaqueTokenFormat = "opaque"
+	JWTTokenFormat    = "jwt"
+)
+
+// prefixes of opaque tokens, which make leaked tokens identifiable
+const (
+	AccessTokenPrefix  = "mat"
+	RefreshTokenPrefix = "mrt"
 )
 
 var (
-	db *gorm.DB
+	db             *gorm.DB
+	redisClient    *redis.Client
+	encryptionKeys *core.KeyEncryptionKeys
+	secretHasher   *core.SecretHasher
+	stores         *storage.Stores
+
+	accessTokenFormat     = flag.String("accessTokenFormat", OpaqueTokenFormat, "the format of issued access tokens ('opaque' or 'jwt')")
+	refreshTokenFormat    = flag.String("refreshTokenFormat", OpaqueTokenFormat, "the format of issued refresh tokens ('opaque' or 'jwt')")
+	accessTokenAudience   = flag.String("accessTokenAudience", Issuer, "the audience of JWT access tokens")
+	signingAlgorithm      = flag.String("signingAlgorithm", jwt.ES256, "the algorithm of newly generated signing keys (ES256, RS256 or EdDSA)")
+	signingKeyRotation    = flag.Duration("signingKeyRotation", 30*24*time.Hour, "the interval after which signing keys are rotated")
+	signingKeyOverlap     = flag.Duration("signingKeyOverlap", 24*time.Hour, "the time rotated signing keys remain valid for verification; at least the lifetime of signed tokens, including JWT refresh tokens")
+	tokenEntropy          = flag.Int("tokenEntropy", 256, "the entropy in bits of opaque access and refresh tokens")
+	refreshTokenLifetime  = flag.Duration("refreshTokenLifetime", 30*24*time.Hour, "the time opaque refresh tokens remain valid")
+	redisAddress          = flag.String("redisAddress", "", "the address of the Redis server to store authorization requests, codes and tokens in; the database is used if empty")
+	redisCodec            = flag.String("redisCodec", core.JSONCodecFormat, "the encoding of values stored in Redis or encrypted in the database ('json', 'gob' or 'cbor')")
+	encryptionKeyfile     = flag.String("encryptionKeys", "", "the keyfile of the key-encryption keys to encrypt signing keys and stored authorization requests, codes, tokens and other values with; they are stored unencrypted if empty")
+	secretPeppers         = flag.String("secretPeppers", "", "the keyfile of the peppers to hash tokens and client secrets with; secrets are hashed without a pepper if empty")
+	deviceVerificationUri = flag.String("deviceVerificationUri", "http://localhost:3000/device", "the page users enter the user code of a device authorization on")
 )
 
 func main() {
+	flag.Parse()
+
 	err := app.Sequence(
 		"Application initialization",
 		app.Step("Database initialization", initializeDatabase),
+		app.Step("Database migration", migrateDatabase),
+		app.Step("Redis initialization", initializeRedis),
+		app.Step("Encryption configuration", configureEncryption),
+		app.Step("Secret hashing configuration", configureSecretHashing),
+		app.Step("Signing key configuration", configureSigningKeys),
 		app.Step("Service initialization", initializeServices),
 		app.Step("Gin configuration", ginApp.ConfigureGin),
 		app.Step("Telemetry configuration", ginApp.ConfigureTelemetry),
@@ -58,7 +105,67 @@ func initializeDatabase() error {
 	return nil
 }
 
+func initializeRedis() error {
+	if *redisAddress == "" {
+		return nil
+	}
+
+	redisClient = redis.NewClient(&redis.Options{Addr: *redisAddress})
+	return redisClient.Ping(context.Background()).Err()
+}
+
+func configureEncryption() error {
+	if *encryptionKeyfile == "" {
+		return nil
+	}
+
+	var err error
+	encryptionKeys, err = core.LoadKeyEncryptionKeys(*encryptionKeyfile)
+	return err
+}
+
+func configureSecretHashing() error {
+	var err error
+	if *secretPeppers == "" {
+		secretHasher, err = core.NewSecretHasher("", nil)
+		return err
+	}
+
+	secretHasher, err = core.LoadSecretHasher(*secretPeppers)
+	return err
+}
+
+func configureSigningKeys() error {
+	return verifySigningKeyOverlap(*signingKeyOverlap, *refreshTokenFormat, *refreshTokenLifetime)
+}
+
+// verifySigningKeyOverlap checks rotated signing keys remain valid until the
+// last token they signed expires, so signed tokens are not rejected early.
+func verifySigningKeyOverlap(overlap time.Duration, refreshTokenFormat string, refreshTokenLifetime time.Duration) error {
+	// ID tokens are signed even if access tokens are opaque
+	lifetime := oauth2.AccessTokenLifetime
+	if refreshTokenFormat == JWTTokenFormat && refreshTokenLifetime > lifetime {
+		lifetime = refreshTokenLifetime
+	}
+	if overlap < lifetime {
+		return fmt.Errorf("signing key overlap %s is shorter than the lifetime of signed tokens %s", overlap, lifetime)
+	}
+	return nil
+}
+
+func migrateDatabase() error {
+	return db.AutoMigrate(
+		&oauth2.ClientModel{},
+		&keys.SigningKeyModel{},
+		&oauth2.AuthorizationRequestModel{},
+		&oauth2.TokenModel{},
+		&gormLocal.KeyValueModel{},
+	)
+}
+
 func initializeServices() error {
+	stores = storage.NewStores("oauth2", db, redisClient, encryptionKeys)
+
 	clientRepo := gormLocal.NewGormRepository[string, *oauth2.ClientModel, *oauth2.ClientModel](
 		db,
 		func(a *oauth2.ClientModel) *oauth2.ClientModel {
@@ -68,14 +175,28 @@ func initializeServices() error {
 			return a
 		},
 	)
-	clientService := oauth2.NewClientService(clientRepo)
+	// used assertions must be rejected by all replicas, so they share the store
+	assertionStore := storage.NewKeyValueStore[time.Time](stores, "client_assertion", core.JSONCodec[time.Time]{})
+	clientService := oauth2.NewClientService(clientRepo, []string{Issuer, Issuer + "/token"}, assertionStore, secretHasher)
 
-	authenticationVerifierStore := core.NewInMemoryKeyValueStore[[]byte]()
+	// the authenticator hands successful authentications over in its store,
+	// so both must be configured with the same codec and encryption keys
+	authenticationCodec, err := core.NewCodec[*domain.Authentication](*redisCodec)
+	if err != nil {
+		return err
+	}
+	authenticationVerifierStore := storage.NewKeyValueStore[*domain.Authentication](stores.Namespace("webauthn"), "authentication", authenticationCodec)
 
 	meter := otel.GetMeterProvider().Meter("github.com/Untanky/modern-auth/oauth2")
 
-	authorizationStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationRequest]()
-	codeStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationRequest]()
+	authorizationStore, err := newAuthorizationRequestStore(oauth2.AuthorizationRequestKind, 30*time.Minute)
+	if err != nil {
+		return err
+	}
+	codeStore, err := newAuthorizationRequestStore(oauth2.AuthorizationCodeKind, 10*time.Minute)
+	if err != nil {
+		return err
+	}
 	authorizationCodeInit, err := meter.Int64Counter("authorization_code_init")
 	if err != nil {
 		return err
@@ -84,39 +205,143 @@ func initializeServices() error {
 	if err != nil {
 		return err
 	}
-	authorizationService := oauth2.NewAuthorizationService(authorizationStore, codeStore, authenticationVerifierStore, clientService, authorizationCodeInit, authorizationCodeSuccess)
+	deviceCodec, err := oauth2.NewDeviceAuthorizationCodec(*redisCodec)
+	if err != nil {
+		return err
+	}
+	deviceStore := storage.NewKeyValueStore[*oauth2.DeviceAuthorization](stores, "device", deviceCodec)
+	userCodeStore := storage.NewKeyValueStore[string](stores, "user_code", core.JSONCodec[string]{})
+	userCodeAttemptStore := storage.NewKeyValueStore[int](stores, "user_code_attempt", core.JSONCodec[int]{})
+	deviceService := oauth2.NewDeviceAuthorizationService(deviceStore, userCodeStore, userCodeAttemptStore, secretHasher, *deviceVerificationUri, 5*time.Second)
+
+	authorizationService := oauth2.NewAuthorizationService(authorizationStore, codeStore, authenticationVerifierStore, clientService, deviceService, Issuer, authorizationCodeInit, authorizationCodeSuccess)
+
+	signingKeyRepo := gormLocal.NewGormRepository[string, *keys.SigningKeyModel, *keys.SigningKeyModel](
+		db,
+		func(a *keys.SigningKeyModel) *keys.SigningKeyModel {
+			return a
+		},
+		func(a *keys.SigningKeyModel) *keys.SigningKeyModel {
+			return a
+		},
+	)
+	keyManager := keys.NewManager(signingKeyRepo, *signingAlgorithm, *signingKeyRotation, *signingKeyOverlap, encryptionKeys)
+	err = keyManager.Load(context.Background())
+	if err != nil {
+		return err
+	}
+	keyManager.Start(context.Background(), time.Minute)
+	// revocations must be visible to all replicas, so they share the store
+	revocationList := oauth2.NewRevocationList(storage.NewKeyValueStore[time.Time](stores, "revocation", core.JSONCodec[time.Time]{}), *refreshTokenLifetime)
 
-	accessTokenStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationGrant]()
 	accessTokensGenerated, err := meter.Int64Counter("access_tokens_generated")
 	if err != nil {
 		return err
 	}
-	accessTokenHandler := oauth2.NewRandomTokenHandler("access-token", 48, accessTokenStore, accessTokensGenerated)
-	refreshTokenStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationGrant]()
+	accessTokenHandler, err := newTokenHandler(oauth2.AccessTokenKind, *accessTokenFormat, core.NewTokenGenerator(AccessTokenPrefix, *tokenEntropy, true), 0, *accessTokenAudience, keyManager, revocationList, accessTokensGenerated)
+	if err != nil {
+		return err
+	}
 	refreshTokensGenerated, err := meter.Int64Counter("refresh_tokens_generated")
 	if err != nil {
 		return err
 	}
-	refreshTokenHandler := oauth2.NewRandomTokenHandler("refresh-token", 64, refreshTokenStore, refreshTokensGenerated)
+	refreshTokenHandler, err := newTokenHandler(oauth2.RefreshTokenKind, *refreshTokenFormat, core.NewTokenGenerator(RefreshTokenPrefix, *tokenEntropy, true), *refreshTokenLifetime, Issuer, keyManager, revocationList, refreshTokensGenerated)
+	if err != nil {
+		return err
+	}
 	tokenRequest, err := meter.Int64Counter("token_request")
 	if err != nil {
 		return err
 	}
-	tokenService := oauth2.NewOAuthTokenService(codeStore, accessTokenHandler, refreshTokenHandler, tokenRequest)
+	refreshTokenReuse, err := meter.Int64Counter("refresh_token_reuse")
+	if err != nil {
+		return err
+	}
+	usedRefreshTokenStore := storage.NewKeyValueStore[uuid.UUID](stores, "used_refresh_token", core.JSONCodec[uuid.UUID]{})
+	userService := domain.NewUserService(gormLocal.NewGormUserRepo(db))
+	openIDProvider := oauth2.NewOpenIDProvider(Issuer, keyManager, userService)
+	tokenService := oauth2.NewOAuthTokenService(codeStore, accessTokenHandler, refreshTokenHandler, openIDProvider, deviceService, revocationList, usedRefreshTokenStore, *refreshTokenLifetime, secretHasher, tokenRequest, refreshTokenReuse)
 
-	controllerInstance = newController(authorizationService, clientService, tokenService)
+	controllerInstance = newController(authorizationService, clientService, tokenService, deviceService, keyManager, openIDProvider, newProviderMetadata())
 
 	return nil
 }
 
+func newTokenHandler(tokenType string, format string, generator *core.TokenGenerator, lifetime time.Duration, audience string, keys jwt.KeySet, revocationList *oauth2.RevocationList, tokensGenerated metric.Int64Counter) (oauth2.TokenHandler, error) {
+	switch format {
+	case OpaqueTokenFormat:
+		store, err := newTokenStore(tokenType, lifetime)
+		if err != nil {
+			return nil, err
+		}
+		return oauth2.NewRandomTokenHandler(tokenType, generator, secretHasher, store, revocationList, tokensGenerated), nil
+	case JWTTokenFormat:
+		return oauth2.NewJWTTokenHandler(tokenType, lifetime, Issuer, audience, keys, revocationList, tokensGenerated), nil
+	default:
+		return nil, fmt.Errorf("unknown token format '%s' for %s", format, tokenType)
+	}
+}
+
+// newAuthorizationRequestStore creates a store in Redis, if configured, and
+// in the database otherwise. The tables of the database cannot hold
+// encrypted values, so they are stored like in Redis if encryption is
+// configured.
+func newAuthorizationRequestStore(kind string, lifetime time.Duration) (core.KeyValueStore[string, *oauth2.AuthorizationRequest], error) {
+	if redisClient != nil || encryptionKeys != nil {
+		codec, err := oauth2.NewAuthorizationRequestCodec(*redisCodec)
+		if err != nil {
+			return nil, err
+		}
+		// codes are hashed like in the database tables
+		store := oauth2.WithHashedKeys(storage.NewEncodedStore[*oauth2.AuthorizationRequest](stores, kind, codec), secretHasher)
+		return core.WithDefaultTTL[string, *oauth2.AuthorizationRequest](store, lifetime), nil
+	}
+
+	store := oauth2.NewGormAuthorizationRequestStore(db, kind, lifetime, secretHasher)
+	go storage.DeleteExpired(context.Background(), store, time.Minute)
+	return store, nil
+}
+
+// newTokenStore creates a store for opaque tokens like
+// newAuthorizationRequestStore. Tokens are kept until the lifetime has
+// passed or, without a lifetime, until their grant expires.
+func newTokenStore(kind string, lifetime time.Duration) (oauth2.TokenStore, error) {
+	if redisClient != nil || encryptionKeys != nil {
+		codec, err := oauth2.NewAuthorizationGrantCodec(*redisCodec)
+		if err != nil {
+			return nil, err
+		}
+		store := storage.NewEncodedStore[*oauth2.AuthorizationGrant](stores, kind, codec)
+		if lifetime == 0 {
+			return oauth2.WithGrantExpiry(store), nil
+		}
+		return core.WithDefaultTTL[string, *oauth2.AuthorizationGrant](store, lifetime), nil
+	}
+
+	store := oauth2.NewGormTokenStore(db, kind, lifetime)
+	go storage.DeleteExpired(context.Background(), store, time.Minute)
+	return store, nil
+}
+
 func configureRoutes() error {
 	route := ginApp.GetRouter(ContextPath)
 
+	route.GET("/.well-known/jwks.json", controllerInstance.publishKeys)
+	route.GET("/.well-known/openid-configuration", controllerInstance.publishProviderMetadata)
+
 	route.Use(disableCaching)
 	route.GET("/authorization", controllerInstance.startAuthorization)
 	route.POST("/authorization/succeed", controllerInstance.succeedAuthorization)
+	route.POST("/authorization/deny", controllerInstance.denyAuthorization)
+	route.POST("/device_authorization", controllerInstance.startDeviceAuthorization)
+	route.GET("/device", controllerInstance.verifyDevice)
 	route.POST("/token", controllerInstance.issueToken)
+	route.POST("/introspect", controllerInstance.introspectToken)
+	route.POST("/revoke", controllerInstance.revokeToken)
 	route.POST("/token/validate", controllerInstance.handleAuthorization, controllerInstance.returnGrant)
+	route.GET("/userinfo", controllerInstance.handleAuthorization, controllerInstance.userInfo)
+	route.POST("/userinfo", controllerInstance.handleAuthorization, controllerInstance.userInfo)
 	route.GET("/client", controllerInstance.handleAuthorization, controllerInstance.listClients)
 	route.GET("/client/:id", controllerInstance.handleAuthorization, controllerInstance.getClient)
 	route.POST("/client", controllerInstance.handleAuthorization, controllerInstance.createClient)
@@ -136,13 +361,21 @@ type controller struct {
 	authorizationService *oauth2.AuthorizationService
 	clientService        *oauth2.ClientService
 	tokenService         *oauth2.OAuthTokenService
+	deviceService        *oauth2.DeviceAuthorizationService
+	keyManager           *keys.Manager
+	openIDProvider       *oauth2.OpenIDProvider
+	providerMetadata     *oauth2.ProviderMetadata
 }
 
-func newController(authorizationService *oauth2.AuthorizationService, clientService *oauth2.ClientService, tokenService *oauth2.OAuthTokenService) *controller {
+func newController(authorizationService *oauth2.AuthorizationService, clientService *oauth2.ClientService, tokenService *oauth2.OAuthTokenService, deviceService *oauth2.DeviceAuthorizationService, keyManager *keys.Manager, openIDProvider *oauth2.OpenIDProvider, providerMetadata *oauth2.ProviderMetadata) *controller {
 	return &controller{
 		authorizationService: authorizationService,
 		clientService:        clientService,
 		tokenService:         tokenService,
+		deviceService:        deviceService,
+		keyManager:           keyManager,
+		openIDProvider:       openIDProvider,
+		providerMetadata:     providerMetadata,
 	}
 }
 
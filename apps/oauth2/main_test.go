@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifySigningKeyOverlap(t *testing.T) {
+	tests := []struct {
+		name                 string
+		overlap              time.Duration
+		refreshTokenFormat   string
+		refreshTokenLifetime time.Duration
+		wantErr              bool
+	}{
+		{
+			name:                 "Opaque refresh tokens",
+			overlap:              24 * time.Hour,
+			refreshTokenFormat:   OpaqueTokenFormat,
+			refreshTokenLifetime: 30 * 24 * time.Hour,
+		},
+		{
+			name:                 "Shorter than access tokens",
+			overlap:              time.Minute,
+			refreshTokenFormat:   OpaqueTokenFormat,
+			refreshTokenLifetime: 30 * 24 * time.Hour,
+			wantErr:              true,
+		},
+		{
+			name:                 "JWT refresh tokens",
+			overlap:              30 * 24 * time.Hour,
+			refreshTokenFormat:   JWTTokenFormat,
+			refreshTokenLifetime: 30 * 24 * time.Hour,
+		},
+		{
+			name:                 "Shorter than JWT refresh tokens",
+			overlap:              24 * time.Hour,
+			refreshTokenFormat:   JWTTokenFormat,
+			refreshTokenLifetime: 30 * 24 * time.Hour,
+			wantErr:              true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySigningKeyOverlap(tt.overlap, tt.refreshTokenFormat, tt.refreshTokenLifetime)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifySigningKeyOverlap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/Untanky/modern-auth/apps/oauth2/internal/oauth2"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func newProviderMetadata() *oauth2.ProviderMetadata {
+	return &oauth2.ProviderMetadata{
+		Issuer:                                     Issuer,
+		AuthorizationEndpoint:                      Issuer + "/authorization",
+		TokenEndpoint:                              Issuer + "/token",
+		DeviceAuthorizationEndpoint:                Issuer + "/device_authorization",
+		IntrospectionEndpoint:                      Issuer + "/introspect",
+		RevocationEndpoint:                         Issuer + "/revoke",
+		UserInfoEndpoint:                           Issuer + "/userinfo",
+		JWKSURI:                                    Issuer + "/.well-known/jwks.json",
+		ScopesSupported:                            []string{oauth2.OpenIDScope},
+		ResponseTypesSupported:                     []string{"code"},
+		ResponseModesSupported:                     []string{"query"},
+		GrantTypesSupported:                        []string{"authorization_code", "refresh_token", "client_credentials", oauth2.DeviceCodeGrantType},
+		SubjectTypesSupported:                      []string{"public"},
+		IDTokenSigningAlgValuesSupported:           []string{*signingAlgorithm},
+		TokenEndpointAuthMethodsSupported:          []string{oauth2.AuthMethodNone, oauth2.AuthMethodClientSecretBasic, oauth2.AuthMethodClientSecretPost, oauth2.AuthMethodPrivateKeyJWT},
+		TokenEndpointAuthSigningAlgValuesSupported: []string{jwt.ES256, jwt.RS256, jwt.EdDSA},
+		ACRValuesSupported:                         []string{"phr"},
+		ClaimsSupported:                            []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "at_hash"},
+		AuthorizationResponseIssParameter:          true,
+	}
+}
+
+func (controller *controller) publishProviderMetadata(ctx *gin.Context) {
+	ctx.Header(CacheControlHeader, "public, max-age=3600")
+	ctx.JSON(http.StatusOK, controller.providerMetadata)
+}
+
+func (controller *controller) userInfo(ctx *gin.Context) {
+	grant, _ := ctx.Get("grant")
+
+	userInfo, err := controller.openIDProvider.UserInfo(ctx.Request.Context(), grant.(*oauth2.AuthorizationGrant))
+	if err != nil {
+		ctx.Header("WWW-Authenticate", "Bearer error=\"insufficient_scope\"")
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, userInfo)
+}
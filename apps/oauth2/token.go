@@ -5,24 +5,105 @@ import (
 	"github.com/Untanky/modern-auth/apps/oauth2/internal/oauth2"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"net/url"
 )
 
 func (controller *controller) issueToken(ctx *gin.Context) {
+	client, err := controller.authenticateClient(ctx)
+	if err != nil {
+		controller.abortClientAuthentication(ctx, err)
+		return
+	}
+
 	tokenRequest, err := controller.parseTokenRequest(ctx)
 	if err != nil {
-		ctx.AbortWithError(http.StatusBadRequest, err)
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &oauth2.TokenError{
+			ErrorType:        "invalid_request",
+			ErrorDescription: err.Error(),
+		})
 		return
 	}
 
-	tokenResponse, tokenError := controller.tokenService.Token(ctx.Request.Context(), tokenRequest)
+	tokenResponse, tokenError := controller.tokenService.Token(ctx.Request.Context(), client, tokenRequest)
 	if tokenError != nil {
-		ctx.AbortWithError(http.StatusBadRequest, tokenError)
+		ctx.Error(tokenError)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, tokenError)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, tokenResponse)
 }
 
+// abortClientAuthentication responds with an invalid_client error. The
+// challenge is only included for clients that authenticated with HTTP Basic,
+// as required by RFC 6749, section 5.2.
+func (controller *controller) abortClientAuthentication(ctx *gin.Context, err error) {
+	ctx.Error(err)
+	if _, _, hasBasicAuth := ctx.Request.BasicAuth(); hasBasicAuth {
+		ctx.Header("WWW-Authenticate", "Basic")
+	}
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, &oauth2.TokenError{
+		ErrorType:        "invalid_client",
+		ErrorDescription: "client authentication failed",
+	})
+}
+
+// authenticateClient authenticates the client at the token endpoint using
+// one of the methods of RFC 6749, section 2.3 and RFC 7523.
+func (controller *controller) authenticateClient(ctx *gin.Context) (*oauth2.Client, error) {
+	var form struct {
+		ClientId            string `form:"client_id"`
+		ClientSecret        string `form:"client_secret"`
+		ClientAssertionType string `form:"client_assertion_type"`
+		ClientAssertion     string `form:"client_assertion"`
+	}
+	if err := ctx.ShouldBind(&form); err != nil {
+		return nil, err
+	}
+
+	authentication := &oauth2.ClientAuthentication{
+		ClientId:            form.ClientId,
+		ClientSecret:        form.ClientSecret,
+		ClientAssertionType: form.ClientAssertionType,
+		ClientAssertion:     form.ClientAssertion,
+	}
+
+	username, password, hasBasicAuth := ctx.Request.BasicAuth()
+	switch {
+	case hasBasicAuth:
+		if form.ClientSecret != "" || form.ClientAssertion != "" {
+			return nil, fmt.Errorf("multiple client authentication methods used")
+		}
+		// credentials are form-urlencoded before they are put in the header
+		clientId, err := url.QueryUnescape(username)
+		if err != nil {
+			return nil, err
+		}
+		clientSecret, err := url.QueryUnescape(password)
+		if err != nil {
+			return nil, err
+		}
+		if form.ClientId != "" && form.ClientId != clientId {
+			return nil, fmt.Errorf("client id does not match")
+		}
+		authentication.Method = oauth2.AuthMethodClientSecretBasic
+		authentication.ClientId = clientId
+		authentication.ClientSecret = clientSecret
+	case form.ClientAssertion != "":
+		if form.ClientSecret != "" {
+			return nil, fmt.Errorf("multiple client authentication methods used")
+		}
+		authentication.Method = oauth2.AuthMethodPrivateKeyJWT
+	case form.ClientSecret != "":
+		authentication.Method = oauth2.AuthMethodClientSecretPost
+	default:
+		authentication.Method = oauth2.AuthMethodNone
+	}
+
+	return controller.clientService.Authenticate(ctx.Request.Context(), authentication)
+}
+
 func (controller *controller) parseTokenRequest(ctx *gin.Context) (oauth2.TokenRequest, error) {
 	var temp struct {
 		GrantType string `form:"grant_type" binding:"required"`
@@ -33,18 +114,78 @@ func (controller *controller) parseTokenRequest(ctx *gin.Context) (oauth2.TokenR
 
 	switch temp.GrantType {
 	case "authorization_code":
-		var authorizationCodeTokenRequest *oauth2.AuthorizationCodeTokenRequest
+		authorizationCodeTokenRequest := &oauth2.AuthorizationCodeTokenRequest{}
 		err := ctx.ShouldBind(authorizationCodeTokenRequest)
 		return authorizationCodeTokenRequest, err
 	case "refresh_token":
-		var refreshTokenRequest *oauth2.RefreshTokenRequest
+		refreshTokenRequest := &oauth2.RefreshTokenRequest{}
 		err := ctx.ShouldBind(refreshTokenRequest)
 		return refreshTokenRequest, err
+	case "client_credentials":
+		clientCredentialsTokenRequest := &oauth2.ClientCredentialsTokenRequest{}
+		err := ctx.ShouldBind(clientCredentialsTokenRequest)
+		return clientCredentialsTokenRequest, err
+	case oauth2.DeviceCodeGrantType:
+		deviceCodeTokenRequest := &oauth2.DeviceCodeTokenRequest{}
+		err := ctx.ShouldBind(deviceCodeTokenRequest)
+		return deviceCodeTokenRequest, err
 	default:
 		return nil, fmt.Errorf("invalid grant type: %s", temp.GrantType)
 	}
 }
 
+func (controller *controller) introspectToken(ctx *gin.Context) {
+	client, err := controller.authenticateClient(ctx)
+	if err == nil && !client.IsConfidential() {
+		err = fmt.Errorf("public clients may not introspect tokens")
+	}
+	if err != nil {
+		controller.abortClientAuthentication(ctx, err)
+		return
+	}
+
+	request := &oauth2.IntrospectionRequest{}
+	err = ctx.ShouldBind(request)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &oauth2.TokenError{
+			ErrorType:        "invalid_request",
+			ErrorDescription: err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, controller.tokenService.Introspect(ctx.Request.Context(), client, request))
+}
+
+func (controller *controller) revokeToken(ctx *gin.Context) {
+	client, err := controller.authenticateClient(ctx)
+	if err != nil {
+		controller.abortClientAuthentication(ctx, err)
+		return
+	}
+
+	request := &oauth2.RevocationRequest{}
+	err = ctx.ShouldBind(request)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &oauth2.TokenError{
+			ErrorType:        "invalid_request",
+			ErrorDescription: err.Error(),
+		})
+		return
+	}
+
+	tokenError := controller.tokenService.Revoke(ctx.Request.Context(), client, request)
+	if tokenError != nil {
+		ctx.Error(tokenError)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, tokenError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
 func (controller *controller) returnGrant(ctx *gin.Context) {
 	grant, _ := ctx.Get("grant")
 
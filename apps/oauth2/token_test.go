@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/apps/oauth2/internal/oauth2"
+	"github.com/Untanky/modern-auth/internal/core"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/metric/noop"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestController creates a controller with the client and token services
+// and returns the secrets of the created clients by their id.
+func newTestController(t *testing.T, clients ...oauth2.ClientDTO) (*controller, map[string]string) {
+	t.Helper()
+	testDB, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := testDB.DB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	if err := testDB.AutoMigrate(&oauth2.ClientModel{}); err != nil {
+		t.Fatal(err)
+	}
+	clientRepo := gormLocal.NewGormRepository[string, *oauth2.ClientModel, *oauth2.ClientModel](
+		testDB,
+		func(a *oauth2.ClientModel) *oauth2.ClientModel {
+			return a
+		},
+		func(a *oauth2.ClientModel) *oauth2.ClientModel {
+			return a
+		},
+	)
+	assertionStore := core.NewInMemoryKeyValueStore[time.Time]()
+	t.Cleanup(assertionStore.Close)
+	secretHasher, err := core.NewSecretHasher("1", map[string][]byte{"1": []byte("pepper of the tests, at least 32 bytes")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientService := oauth2.NewClientService(clientRepo, []string{Issuer, Issuer + "/token"}, assertionStore, secretHasher)
+
+	secrets := make(map[string]string)
+	for _, dto := range clients {
+		_, secret, err := clientService.Create(context.Background(), dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets[dto.ID] = secret
+	}
+
+	counter, err := noop.NewMeterProvider().Meter("test").Int64Counter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationStore := core.NewInMemoryKeyValueStore[time.Time]()
+	accessTokenStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationGrant]()
+	refreshTokenStore := core.NewInMemoryKeyValueStore[*oauth2.AuthorizationGrant]()
+	usedRefreshTokenStore := core.NewInMemoryKeyValueStore[uuid.UUID]()
+	for _, store := range []interface{ Close() }{revocationStore, accessTokenStore, refreshTokenStore, usedRefreshTokenStore} {
+		t.Cleanup(store.Close)
+	}
+	revocationList := oauth2.NewRevocationList(revocationStore, time.Hour)
+	accessTokenHandler := oauth2.NewRandomTokenHandler(oauth2.AccessTokenKind, core.NewTokenGenerator(AccessTokenPrefix, 256, true), secretHasher, accessTokenStore, revocationList, counter)
+	refreshTokenHandler := oauth2.NewRandomTokenHandler(oauth2.RefreshTokenKind, core.NewTokenGenerator(RefreshTokenPrefix, 256, true), secretHasher, refreshTokenStore, revocationList, counter)
+	tokenService := oauth2.NewOAuthTokenService(nil, accessTokenHandler, refreshTokenHandler, nil, nil, revocationList, usedRefreshTokenStore, time.Hour, secretHasher, counter, counter)
+
+	return newController(nil, clientService, tokenService, nil, nil, nil, nil), secrets
+}
+
+func TestIntrospectTokenClientAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	controller, secrets := newTestController(t,
+		oauth2.ClientDTO{ID: "service", Scopes: []string{"api"}, TokenEndpointAuthMethod: oauth2.AuthMethodClientSecretBasic},
+		oauth2.ClientDTO{ID: "basic", TokenEndpointAuthMethod: oauth2.AuthMethodClientSecretBasic},
+		oauth2.ClientDTO{ID: "post", TokenEndpointAuthMethod: oauth2.AuthMethodClientSecretPost},
+		oauth2.ClientDTO{ID: "public", TokenEndpointAuthMethod: oauth2.AuthMethodNone},
+	)
+	router := gin.New()
+	router.POST("/token", controller.issueToken)
+	router.POST("/introspect", controller.introspectToken)
+
+	token := issueTestToken(t, router, "service", secrets["service"])
+
+	tests := []struct {
+		name          string
+		form          url.Values
+		basicAuth     []string
+		wantStatus    int
+		wantChallenge bool
+		wantActive    bool
+	}{
+		{
+			name:       "Client secret basic",
+			form:       url.Values{"token": {token}},
+			basicAuth:  []string{"basic", secrets["basic"]},
+			wantStatus: http.StatusOK,
+			wantActive: true,
+		},
+		{
+			name:       "Client secret post",
+			form:       url.Values{"token": {token}, "client_id": {"post"}, "client_secret": {secrets["post"]}},
+			wantStatus: http.StatusOK,
+			wantActive: true,
+		},
+		{
+			name:       "Unknown token",
+			form:       url.Values{"token": {"mat_unknown"}},
+			basicAuth:  []string{"basic", secrets["basic"]},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Missing token",
+			form:       url.Values{},
+			basicAuth:  []string{"basic", secrets["basic"]},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "No client authentication",
+			form:       url.Values{"token": {token}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Public client",
+			form:       url.Values{"token": {token}, "client_id": {"public"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Wrong client secret basic",
+			form:          url.Values{"token": {token}},
+			basicAuth:     []string{"basic", secrets["post"]},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: true,
+		},
+		{
+			name:       "Wrong client secret post",
+			form:       url.Values{"token": {token}, "client_id": {"post"}, "client_secret": {secrets["basic"]}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Multiple authentication methods",
+			form:          url.Values{"token": {token}, "client_secret": {secrets["basic"]}},
+			basicAuth:     []string{"basic", secrets["basic"]},
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(tt.form.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.basicAuth != nil {
+				request.SetBasicAuth(tt.basicAuth[0], tt.basicAuth[1])
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if hasChallenge := recorder.Header().Get("WWW-Authenticate") == "Basic"; hasChallenge != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want challenge %v", recorder.Header().Get("WWW-Authenticate"), tt.wantChallenge)
+			}
+			if recorder.Code != http.StatusOK {
+				return
+			}
+			var response oauth2.IntrospectionResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Active != tt.wantActive {
+				t.Errorf("active = %v, want %v", response.Active, tt.wantActive)
+			}
+		})
+	}
+}
+
+// issueTestToken issues an access token to the client with the client
+// credentials grant.
+func issueTestToken(t *testing.T, router http.Handler, clientId string, clientSecret string) string {
+	t.Helper()
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(clientId, clientSecret)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("token request failed with status %d: %s", recorder.Code, recorder.Body)
+	}
+	var response oauth2.TokenResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.AccessToken
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/Untanky/modern-auth/internal/app"
 	"github.com/Untanky/modern-auth/internal/core"
+	redisLocal "github.com/Untanky/modern-auth/internal/redis"
 	"github.com/Untanky/modern-auth/registry"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
 	"net"
 )
@@ -15,6 +17,8 @@ var (
 	useTLS   = flag.Bool("useTLS", false, "use useTLS")
 	certFile = flag.String("certFile", "", "path to the cert file")
 	keyFile  = flag.String("keyFile", "", "path to the key file")
+
+	redisAddress = flag.String("redisAddress", "", "the address of the Redis server to persist the registry index in; the index is kept in memory if empty")
 )
 
 func main() {
@@ -39,11 +43,18 @@ func main() {
 
 	store := core.NewInMemoryKeyValueStore[*registry.RegistrationInfo]()
 	index := core.NewInMemoryKeyValueStore[core.List[string]]()
+	newList := core.NewInMemoryListFactory[string]()
+	if *redisAddress != "" {
+		client := redis.NewClient(&redis.Options{Addr: *redisAddress})
+		defer client.Close()
+		// the index only caches the lists, which are persisted in redis
+		newList = redisLocal.NewRedisListFactory[string](client, "registry:index:", core.JSONCodec[string]{})
+	}
 	registerChan := make(chan *registry.RegistrationInfo)
 	unregisterChan := make(chan *registry.RegistrationInfo)
 
 	grpcServer := grpc.NewServer(opts...)
-	registry.RegisterRegistryServer(grpcServer, registry.NewRegistryServer(store, index, registerChan, unregisterChan))
+	registry.RegisterRegistryServer(grpcServer, registry.NewRegistryServer(store, index, newList, registerChan, unregisterChan))
 	err = grpcServer.Serve(listener)
 	if err != nil {
 		panic(err)
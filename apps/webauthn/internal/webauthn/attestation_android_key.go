@@ -0,0 +1,144 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"fmt"
+)
+
+// androidKeyAttestationStatement is sent by Android devices with a hardware
+// keystore, which attests the key in an extension of its certificate.
+type androidKeyAttestationStatement struct {
+	Algorithm        int      `cbor:"alg"`
+	Signature        []byte   `cbor:"sig"`
+	CertificateChain [][]byte `cbor:"x5c"`
+}
+
+// oidAndroidKeyDescription is the extension of the Android key attestation.
+var oidAndroidKeyDescription = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 17}
+
+const (
+	// tags of the AuthorizationList of the Android key attestation
+	androidTagPurpose         = 1
+	androidTagAllApplications = 600
+	androidTagOrigin          = 702
+
+	androidKeyPurposeSign     = 2
+	androidKeyOriginGenerated = 0
+)
+
+// androidKeyDescription is the KeyDescription of the Android key
+// attestation. The authorization lists are parsed by tag, as new tags are
+// added with every version.
+type androidKeyDescription struct {
+	AttestationVersion       int
+	AttestationSecurityLevel asn1.Enumerated
+	KeymasterVersion         int
+	KeymasterSecurityLevel   asn1.Enumerated
+	AttestationChallenge     []byte
+	UniqueID                 []byte
+	SoftwareEnforced         asn1.RawValue
+	TeeEnforced              asn1.RawValue
+}
+
+func (a *androidKeyAttestationStatement) Verify(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	certificates, err := parseCertificateChain(a.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyCertificateSignature(certificates[0], a.Algorithm, a.Signature, signedData(authenticatorData, clientDataHash))
+	if err != nil {
+		return nil, err
+	}
+	err = verifyCredentialPublicKey(certificates[0], authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	extension, ok := certificateExtension(certificates[0], oidAndroidKeyDescription)
+	if !ok {
+		return nil, fmt.Errorf("key description missing")
+	}
+	var description androidKeyDescription
+	rest, err := asn1.Unmarshal(extension, &description)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid key description")
+	}
+	if !bytes.Equal(description.AttestationChallenge, clientDataHash) {
+		return nil, fmt.Errorf("invalid attestation challenge")
+	}
+
+	softwareEnforced, err := parseAndroidAuthorizationList(description.SoftwareEnforced)
+	if err != nil {
+		return nil, err
+	}
+	teeEnforced, err := parseAndroidAuthorizationList(description.TeeEnforced)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyAndroidAuthorizations(softwareEnforced, teeEnforced)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttestationResult{Type: AttestationTypeBasic, TrustPath: certificates}, nil
+}
+
+// verifyAndroidAuthorizations checks the key is scoped to the relying party,
+// was generated in the keystore and may be used for signing. The union of
+// both authorization lists is used, so keys of the software keystore are
+// accepted as well.
+func verifyAndroidAuthorizations(softwareEnforced map[int]asn1.RawValue, teeEnforced map[int]asn1.RawValue) error {
+	for _, list := range []map[int]asn1.RawValue{softwareEnforced, teeEnforced} {
+		if _, ok := list[androidTagAllApplications]; ok {
+			return fmt.Errorf("key must not be valid for all applications")
+		}
+	}
+
+	origin, ok := teeEnforced[androidTagOrigin]
+	if !ok {
+		origin, ok = softwareEnforced[androidTagOrigin]
+	}
+	var originValue int
+	if !ok {
+		return fmt.Errorf("key origin missing")
+	}
+	if _, err := asn1.Unmarshal(origin.Bytes, &originValue); err != nil || originValue != androidKeyOriginGenerated {
+		return fmt.Errorf("key must be generated in the keystore")
+	}
+
+	purpose, ok := teeEnforced[androidTagPurpose]
+	if !ok {
+		purpose, ok = softwareEnforced[androidTagPurpose]
+	}
+	if !ok {
+		return fmt.Errorf("key purpose missing")
+	}
+	var purposes []int
+	if _, err := asn1.UnmarshalWithParams(purpose.Bytes, &purposes, "set"); err != nil {
+		return fmt.Errorf("invalid key purpose")
+	}
+	for _, value := range purposes {
+		if value == androidKeyPurposeSign {
+			return nil
+		}
+	}
+	return fmt.Errorf("key must be usable for signing")
+}
+
+// parseAndroidAuthorizationList returns the explicitly tagged entries of
+// the authorization list by their tag.
+func parseAndroidAuthorizationList(list asn1.RawValue) (map[int]asn1.RawValue, error) {
+	entries := map[int]asn1.RawValue{}
+	rest := list.Bytes
+	for len(rest) > 0 {
+		var entry asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &entry)
+		if err != nil || entry.Class != asn1.ClassContextSpecific {
+			return nil, fmt.Errorf("invalid authorization list")
+		}
+		entries[entry.Tag] = entry
+	}
+	return entries, nil
+}
@@ -0,0 +1,47 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+)
+
+// appleAttestationStatement is sent by Apple devices. The credential
+// certificate binds the nonce of the registration in an extension.
+type appleAttestationStatement struct {
+	CertificateChain [][]byte `cbor:"x5c"`
+}
+
+// oidAppleNonce is the extension containing the nonce.
+var oidAppleNonce = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+func (a *appleAttestationStatement) Verify(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	certificates, err := parseCertificateChain(a.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+
+	extension, ok := certificateExtension(certificates[0], oidAppleNonce)
+	if !ok {
+		return nil, fmt.Errorf("nonce missing")
+	}
+	var nonce struct {
+		Nonce []byte `asn1:"tag:1,explicit"`
+	}
+	rest, err := asn1.Unmarshal(extension, &nonce)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	expectedNonce := sha256.Sum256(signedData(authenticatorData, clientDataHash))
+	if !bytes.Equal(nonce.Nonce, expectedNonce[:]) {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	err = verifyCredentialPublicKey(certificates[0], authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttestationResult{Type: AttestationTypeAnonCA, TrustPath: certificates}, nil
+}
@@ -0,0 +1,89 @@
+package webauthn
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+)
+
+// androidSafetyNetAttestationStatement is sent by Android devices without a
+// hardware keystore. The SafetyNet API attests the device in a JWS signed
+// by Google.
+type androidSafetyNetAttestationStatement struct {
+	Version  string `cbor:"ver"`
+	Response []byte `cbor:"response"`
+}
+
+type safetyNetResponse struct {
+	Nonce           string `json:"nonce"`
+	TimestampMs     int64  `json:"timestampMs"`
+	CTSProfileMatch bool   `json:"ctsProfileMatch"`
+}
+
+const (
+	safetyNetHostname = "attest.android.com"
+	// safetyNetResponseLifetime is the time a response is accepted after it
+	// was created
+	safetyNetResponseLifetime = time.Minute
+)
+
+func (a *androidSafetyNetAttestationStatement) Verify(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	if a.Version == "" {
+		return nil, fmt.Errorf("version missing")
+	}
+
+	var certificates []*x509.Certificate
+	response := safetyNetResponse{}
+	_, err := jwt.Parse(string(a.Response), &response, func(header *jwt.Header) (*jwt.Key, error) {
+		var err error
+		certificates, err = parseSafetyNetCertificates(header)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.NewVerificationKey("", header.Algorithm, certificates[0].PublicKey)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid safetynet response: %w", err)
+	}
+
+	nonce := sha256.Sum256(signedData(authenticatorData, clientDataHash))
+	if response.Nonce != base64.StdEncoding.EncodeToString(nonce[:]) {
+		return nil, fmt.Errorf("invalid safetynet nonce")
+	}
+	if !response.CTSProfileMatch {
+		return nil, fmt.Errorf("device failed the compatibility test")
+	}
+
+	timestamp := time.UnixMilli(response.TimestampMs)
+	if timestamp.After(now()) || timestamp.Before(now().Add(-safetyNetResponseLifetime)) {
+		return nil, fmt.Errorf("safetynet response expired")
+	}
+
+	return &AttestationResult{Type: AttestationTypeBasic, TrustPath: certificates}, nil
+}
+
+// parseSafetyNetCertificates parses the certificate chain the response is
+// signed with. The certificate must be issued to attest.android.com.
+func parseSafetyNetCertificates(header *jwt.Header) ([]*x509.Certificate, error) {
+	chain := make([][]byte, 0, len(header.X509CertificateChain))
+	for _, encoded := range header.X509CertificateChain {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation certificate: %w", err)
+		}
+		chain = append(chain, data)
+	}
+	certificates, err := parseCertificateChain(chain)
+	if err != nil {
+		return nil, err
+	}
+	err = certificates[0].VerifyHostname(safetyNetHostname)
+	if err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
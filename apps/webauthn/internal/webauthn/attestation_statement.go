@@ -1,37 +1,279 @@
 package webauthn
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
 	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// AttestationType describes how the credential was attested.
+type AttestationType string
+
+const (
+	// AttestationTypeNone is recorded if the authenticator did not attest
+	// the credential
+	AttestationTypeNone AttestationType = "none"
+	// AttestationTypeSelf is recorded if the credential signed its own
+	// attestation, which only proves possession of the key
+	AttestationTypeSelf AttestationType = "self"
+	// AttestationTypeBasic is recorded if the authenticator signed the
+	// attestation with a key shared by its model
+	AttestationTypeBasic AttestationType = "basic"
+	// AttestationTypeAttCA is recorded if the attestation was signed with an
+	// attestation identity key certified by a CA, e.g. of a TPM
+	AttestationTypeAttCA AttestationType = "attca"
+	// AttestationTypeAnonCA is recorded if a CA issued an anonymous
+	// certificate for the credential key
+	AttestationTypeAnonCA AttestationType = "anonca"
 )
 
+// AttestationResult is the outcome of verifying an attestation statement.
+type AttestationResult struct {
+	Type AttestationType
+	// TrustPath is the certificate chain of the attestation, the attestation
+	// certificate first. It is empty for self attestation and none.
+	TrustPath []*x509.Certificate
+}
+
+// now returns the current time. It is replaced in tests to verify recorded
+// attestations.
+var now = time.Now
+
+// decodeAttestationStatement decodes the statement of the given attestation
+// statement format.
+func decodeAttestationStatement(format string, data cbor.RawMessage) (AttestationStatement, error) {
+	var statement AttestationStatement
+	switch format {
+	case "none":
+		statement = &noneAttestationStatement{}
+	case "packed":
+		statement = &packedAttestationStatemment{}
+	case "fido-u2f":
+		statement = &fidoU2FAttestationStatement{}
+	case "tpm":
+		statement = &tpmAttestationStatement{}
+	case "android-key":
+		statement = &androidKeyAttestationStatement{}
+	case "android-safetynet":
+		statement = &androidSafetyNetAttestationStatement{}
+	case "apple":
+		statement = &appleAttestationStatement{}
+	default:
+		return nil, fmt.Errorf("invalid attestation format")
+	}
+
+	err := cbor.Unmarshal(data, statement)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s attestation statement: %w", format, err)
+	}
+	return statement, nil
+}
+
+// noneAttestationStatement is sent if the relying party did not request
+// attestation or the authenticator does not attest its credentials.
+type noneAttestationStatement struct{}
+
+func (n *noneAttestationStatement) UnmarshalCBOR(data []byte) error {
+	var statement map[string]cbor.RawMessage
+	err := cbor.Unmarshal(data, &statement)
+	if err != nil {
+		return err
+	}
+	if len(statement) != 0 {
+		return fmt.Errorf("statement must be empty")
+	}
+	return nil
+}
+
+func (n *noneAttestationStatement) Verify(authenticatorData AuthData, clienDataHash []byte) (*AttestationResult, error) {
+	return &AttestationResult{Type: AttestationTypeNone}, nil
+}
+
 type packedAttestationStatemment struct {
-	algorithm        int
-	signature        []byte
-	certificateChain [][]byte
+	Algorithm        int      `cbor:"alg"`
+	Signature        []byte   `cbor:"sig"`
+	CertificateChain [][]byte `cbor:"x5c"`
 }
 
-func (p *packedAttestationStatemment) Verify(authenticatorData AuthData, clienDataHash []byte) error {
-	if len(p.certificateChain) == 0 {
+func (p *packedAttestationStatemment) Verify(authenticatorData AuthData, clienDataHash []byte) (*AttestationResult, error) {
+	if len(p.CertificateChain) == 0 {
 		return p.validateWithoutCert(authenticatorData, clienDataHash)
 	}
 
-	return p.validateWithCert()
+	return p.validateWithCert(authenticatorData, clienDataHash)
 }
 
-func (p *packedAttestationStatemment) validateWithoutCert(authenticatorData AuthData, clientDataHash []byte) error {
-	if p.algorithm != authenticatorData.CredentialPublicKey.Algorithm() {
-		return fmt.Errorf("invalid algorithm")
+func (p *packedAttestationStatemment) validateWithoutCert(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	if p.Algorithm != authenticatorData.CredentialPublicKey.Algorithm() {
+		return nil, fmt.Errorf("invalid algorithm")
 	}
 
-	verificationData := append(authenticatorData.Raw, clientDataHash...)
-	ok := authenticatorData.CredentialPublicKey.Verify(p.signature, verificationData)
+	ok := authenticatorData.CredentialPublicKey.Verify(p.Signature, signedData(authenticatorData, clientDataHash))
 	if !ok {
-		return fmt.Errorf("invalid signature")
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	return &AttestationResult{Type: AttestationTypeSelf}, nil
+}
+
+// validateWithCert verifies the signature with the attestation certificate.
+// Without metadata basic attestation cannot be distinguished from attCA, so
+// it is recorded as basic.
+func (p *packedAttestationStatemment) validateWithCert(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	certificates, err := parseCertificateChain(p.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyCertificateSignature(certificates[0], p.Algorithm, p.Signature, signedData(authenticatorData, clientDataHash))
+	if err != nil {
+		return nil, err
+	}
+	err = verifyPackedCertificate(certificates[0])
+	if err != nil {
+		return nil, err
+	}
+	err = verifyAAGUIDExtension(certificates[0], authenticatorData)
+	if err != nil {
+		return nil, err
 	}
 
+	return &AttestationResult{Type: AttestationTypeBasic, TrustPath: certificates}, nil
+}
+
+// verifyPackedCertificate checks the requirements of the packed attestation
+// statement format on the attestation certificate.
+func verifyPackedCertificate(certificate *x509.Certificate) error {
+	if certificate.Version != 3 {
+		return fmt.Errorf("attestation certificate must be version 3")
+	}
+	subject := certificate.Subject
+	if len(subject.Country) != 1 || len(subject.Organization) != 1 || subject.CommonName == "" {
+		return fmt.Errorf("attestation certificate subject must contain country, organization and common name")
+	}
+	if len(subject.OrganizationalUnit) != 1 || subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return fmt.Errorf("attestation certificate organizational unit must be Authenticator Attestation")
+	}
+	if certificate.IsCA {
+		return fmt.Errorf("attestation certificate must not be a ca")
+	}
 	return nil
 }
 
-func (p *packedAttestationStatemment) validateWithCert() error {
-	return fmt.Errorf("not implemented")
+// signedData returns the data most attestation statement formats sign, the
+// concatenation of the authenticator data and the client data hash.
+func signedData(authenticatorData AuthData, clientDataHash []byte) []byte {
+	data := make([]byte, 0, len(authenticatorData.Raw)+len(clientDataHash))
+	data = append(data, authenticatorData.Raw...)
+	return append(data, clientDataHash...)
+}
+
+// coseAlgorithm describes a COSE signature algorithm in terms of the crypto
+// packages.
+type coseAlgorithm struct {
+	signature x509.SignatureAlgorithm
+	hash      crypto.Hash
+}
+
+// coseAlgorithms are the algorithms attestation statements may be signed
+// with. RS1 is only used by TPMs.
+var coseAlgorithms = map[int]coseAlgorithm{
+	-7:     {signature: x509.ECDSAWithSHA256, hash: crypto.SHA256},
+	-35:    {signature: x509.ECDSAWithSHA384, hash: crypto.SHA384},
+	-36:    {signature: x509.ECDSAWithSHA512, hash: crypto.SHA512},
+	-8:     {signature: x509.PureEd25519, hash: crypto.SHA512},
+	-37:    {signature: x509.SHA256WithRSAPSS, hash: crypto.SHA256},
+	-38:    {signature: x509.SHA384WithRSAPSS, hash: crypto.SHA384},
+	-39:    {signature: x509.SHA512WithRSAPSS, hash: crypto.SHA512},
+	-257:   {signature: x509.SHA256WithRSA, hash: crypto.SHA256},
+	-258:   {signature: x509.SHA384WithRSA, hash: crypto.SHA384},
+	-259:   {signature: x509.SHA512WithRSA, hash: crypto.SHA512},
+	-65535: {signature: x509.SHA1WithRSA, hash: crypto.SHA1},
+}
+
+// parseCertificateChain parses the x5c certificates of an attestation
+// statement. The first certificate is the attestation certificate.
+func parseCertificateChain(chain [][]byte) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("attestation certificate missing")
+	}
+	certificates := make([]*x509.Certificate, 0, len(chain))
+	for _, data := range chain {
+		certificate, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// verifyCertificateSignature verifies the signature with the key of the
+// certificate.
+func verifyCertificateSignature(certificate *x509.Certificate, algorithm int, signature []byte, data []byte) error {
+	alg, ok := coseAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("invalid algorithm")
+	}
+	err := certificate.CheckSignature(alg.signature, data, signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// equalPublicKeys reports whether the keys are the same. All keys of the
+// crypto packages implement Equal.
+func equalPublicKeys(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// verifyCredentialPublicKey verifies that the certificate certifies the
+// credential public key.
+func verifyCredentialPublicKey(certificate *x509.Certificate, authenticatorData AuthData) error {
+	if !equalPublicKeys(authenticatorData.CredentialPublicKey.Public(), certificate.PublicKey) {
+		return fmt.Errorf("credential public key does not match attestation certificate")
+	}
+	return nil
+}
+
+// oidFIDOAAGUID is the id-fido-gen-ce-aaguid extension, which contains the
+// AAGUID of the authenticator model.
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAAGUIDExtension verifies the AAGUID of the certificate matches the
+// authenticator data, if the certificate contains one.
+func verifyAAGUIDExtension(certificate *x509.Certificate, authenticatorData AuthData) error {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidFIDOAAGUID) {
+			continue
+		}
+		if extension.Critical {
+			return fmt.Errorf("aaguid extension must not be critical")
+		}
+		var aaguid []byte
+		rest, err := asn1.Unmarshal(extension.Value, &aaguid)
+		if err != nil || len(rest) != 0 {
+			return fmt.Errorf("invalid aaguid extension")
+		}
+		if !bytes.Equal(aaguid, authenticatorData.AAGUID) {
+			return fmt.Errorf("aaguid does not match attestation certificate")
+		}
+	}
+	return nil
+}
+
+// certificateExtension returns the value of the extension with the given id.
+func certificateExtension(certificate *x509.Certificate, id asn1.ObjectIdentifier) ([]byte, bool) {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(id) {
+			return extension.Value, true
+		}
+	}
+	return nil, false
 }
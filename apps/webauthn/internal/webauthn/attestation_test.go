@@ -0,0 +1,305 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// attestationFixture is a registration recorded from an authenticator.
+type attestationFixture struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+	AttestationType   string `json:"attestationType"`
+}
+
+// safetyNetFixtureTime is shortly after the recorded SafetyNet response was
+// created.
+var safetyNetFixtureTime = time.UnixMilli(1553028043529).Add(time.Second)
+
+func loadAttestationFixtures(t *testing.T, format string) []attestationFixture {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "attestation", format+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []attestationFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+// decodeAttestationFixture returns the attestation object and the client
+// data hash of the fixture.
+func decodeAttestationFixture(t *testing.T, fixture attestationFixture) (*attestationObject, []byte) {
+	t.Helper()
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(fixture.ClientDataJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawAttestationObject, err := base64.RawURLEncoding.DecodeString(fixture.AttestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestationObject, err := RawAttestationObject(rawAttestationObject).Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	return attestationObject, clientDataHash[:]
+}
+
+func TestAttestationStatementVerify(t *testing.T) {
+	now = func() time.Time { return safetyNetFixtureTime }
+	t.Cleanup(func() { now = time.Now })
+
+	formats := []string{"none", "packed", "fido-u2f", "tpm", "android-key", "android-safetynet", "apple"}
+	for _, format := range formats {
+		for _, fixture := range loadAttestationFixtures(t, format) {
+			t.Run(format+"/"+fixture.Name, func(t *testing.T) {
+				attestationObject, clientDataHash := decodeAttestationFixture(t, fixture)
+				if attestationObject.Format != format {
+					t.Fatalf("Format = %s, want %s", attestationObject.Format, format)
+				}
+
+				result, err := attestationObject.Attestation.Verify(attestationObject.AuthData, clientDataHash)
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				if string(result.Type) != fixture.AttestationType {
+					t.Errorf("Type = %s, want %s", result.Type, fixture.AttestationType)
+				}
+
+				if format == "none" {
+					return
+				}
+				otherClientDataHash := sha256.Sum256([]byte("other client data"))
+				_, err = attestationObject.Attestation.Verify(attestationObject.AuthData, otherClientDataHash[:])
+				if err == nil {
+					t.Errorf("Verify() with other client data succeeded")
+				}
+			})
+		}
+	}
+}
+
+func TestSafetyNetResponseExpired(t *testing.T) {
+	now = func() time.Time { return safetyNetFixtureTime.Add(safetyNetResponseLifetime) }
+	t.Cleanup(func() { now = time.Now })
+
+	fixture := loadAttestationFixtures(t, "android-safetynet")[0]
+	attestationObject, clientDataHash := decodeAttestationFixture(t, fixture)
+	_, err := attestationObject.Attestation.Verify(attestationObject.AuthData, clientDataHash)
+	if err == nil {
+		t.Errorf("Verify() of expired response succeeded")
+	}
+}
+
+func TestDecodeAttestationStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			name:   "None",
+			format: "none",
+			data:   []byte{0xa0},
+		},
+		{
+			name:    "None with statement",
+			format:  "none",
+			data:    []byte{0xa1, 0x63, 'a', 'l', 'g', 0x26},
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported format",
+			format:  "unknown",
+			data:    []byte{0xa0},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid statement",
+			format:  "packed",
+			data:    []byte{0x80},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeAttestationStatement(tt.format, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeAttestationStatement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// newTestCertificate issues a certificate from the template. It is
+// self-signed if no parent is given.
+func newTestCertificate(t *testing.T, template *x509.Certificate, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	data, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func newTestCA(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return newTestCertificate(t, template, key, nil, nil), key
+}
+
+func newTestAttestationTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Country:            []string{"DE"},
+			Organization:       []string{"Modern Auth"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+}
+
+func TestPackedAttestationWithCertificate(t *testing.T) {
+	ca, caKey := newTestCA(t, "Test Attestation CA")
+	attestationKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, coseKey := newTestKey(t)
+	authData, err := decodeAuthData(newTestAuthData("example.com", FlagUserPresent|FlagAttestedCredentialData, 0, []byte{1, 2, 3}, coseKey, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataHash := sha256.Sum256([]byte("client data"))
+	digest := sha256.Sum256(signedData(authData, clientDataHash[:]))
+	signature, err := ecdsa.SignASN1(rand.Reader, attestationKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aaguidExtension := func(aaguid []byte, critical bool) pkix.Extension {
+		value, err := asn1.Marshal(aaguid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkix.Extension{Id: oidFIDOAAGUID, Critical: critical, Value: value}
+	}
+
+	tests := []struct {
+		name     string
+		template func(template *x509.Certificate)
+		wantErr  bool
+	}{
+		{
+			name:     "Valid certificate",
+			template: func(template *x509.Certificate) {},
+		},
+		{
+			name: "Matching AAGUID",
+			template: func(template *x509.Certificate) {
+				template.ExtraExtensions = []pkix.Extension{aaguidExtension(make([]byte, 16), false)}
+			},
+		},
+		{
+			name: "Other AAGUID",
+			template: func(template *x509.Certificate) {
+				template.ExtraExtensions = []pkix.Extension{aaguidExtension(bytes.Repeat([]byte{1}, 16), false)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "Critical AAGUID",
+			template: func(template *x509.Certificate) {
+				template.ExtraExtensions = []pkix.Extension{aaguidExtension(make([]byte, 16), true)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "Other organizational unit",
+			template: func(template *x509.Certificate) {
+				template.Subject.OrganizationalUnit = []string{"Security Keys"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "Missing country",
+			template: func(template *x509.Certificate) {
+				template.Subject.Country = nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "CA certificate",
+			template: func(template *x509.Certificate) {
+				template.IsCA = true
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := newTestAttestationTemplate()
+			tt.template(template)
+			certificate := newTestCertificate(t, template, attestationKey, ca, caKey)
+
+			statement := &packedAttestationStatemment{
+				Algorithm:        -7,
+				Signature:        signature,
+				CertificateChain: [][]byte{certificate.Raw, ca.Raw},
+			}
+			result, err := statement.Verify(authData, clientDataHash[:])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if result.Type != AttestationTypeBasic || len(result.TrustPath) != 2 {
+				t.Errorf("Verify() = %v, want basic attestation with trust path", result)
+			}
+		})
+	}
+}
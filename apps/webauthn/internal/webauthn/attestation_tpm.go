@@ -0,0 +1,366 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// tpmAttestationStatement is sent by TPM-backed platform authenticators,
+// e.g. Windows Hello. The TPM certifies the credential key with its
+// attestation identity key (AIK).
+type tpmAttestationStatement struct {
+	Version          string   `cbor:"ver"`
+	Algorithm        int      `cbor:"alg"`
+	CertificateChain [][]byte `cbor:"x5c"`
+	Signature        []byte   `cbor:"sig"`
+	CertInfo         []byte   `cbor:"certInfo"`
+	PubArea          []byte   `cbor:"pubArea"`
+}
+
+const (
+	// tpmGeneratedValue is the magic of structures created by the TPM
+	tpmGeneratedValue = 0xff544347
+	// tpmSTAttestCertify is the type of attestations of a key
+	tpmSTAttestCertify = 0x8017
+
+	tpmAlgRSA      = 0x0001
+	tpmAlgECC      = 0x0023
+	tpmAlgNull     = 0x0010
+	tpmAlgECDAA    = 0x001a
+	tpmECCNistP256 = 0x0003
+	tpmECCNistP384 = 0x0004
+	tpmECCNistP521 = 0x0005
+)
+
+// tpmHashAlgorithms are the hash algorithms used for names of TPM objects.
+var tpmHashAlgorithms = map[uint16]crypto.Hash{
+	0x0004: crypto.SHA1,
+	0x000b: crypto.SHA256,
+	0x000c: crypto.SHA384,
+	0x000d: crypto.SHA512,
+}
+
+var (
+	oidTPMManufacturer     = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTPMModel            = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTPMVersion          = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+	oidTCGKPAIKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+func (t *tpmAttestationStatement) Verify(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	if t.Version != "2.0" {
+		return nil, fmt.Errorf("unsupported tpm version %s", t.Version)
+	}
+
+	pubArea, err := parseTPMPublic(t.PubArea)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pubArea: %w", err)
+	}
+	if !equalPublicKeys(authenticatorData.CredentialPublicKey.Public(), pubArea.publicKey) {
+		return nil, fmt.Errorf("credential public key does not match pubArea")
+	}
+
+	certInfo, err := parseTPMAttest(t.CertInfo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certInfo: %w", err)
+	}
+	if certInfo.magic != tpmGeneratedValue {
+		return nil, fmt.Errorf("invalid certInfo magic")
+	}
+	if certInfo.attestType != tpmSTAttestCertify {
+		return nil, fmt.Errorf("invalid certInfo type")
+	}
+
+	alg, ok := coseAlgorithms[t.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("invalid algorithm")
+	}
+	extraData := alg.hash.New()
+	extraData.Write(signedData(authenticatorData, clientDataHash))
+	if !bytes.Equal(certInfo.extraData, extraData.Sum(nil)) {
+		return nil, fmt.Errorf("invalid certInfo extraData")
+	}
+
+	nameHash, ok := tpmHashAlgorithms[pubArea.nameAlg]
+	if !ok {
+		return nil, fmt.Errorf("invalid pubArea nameAlg")
+	}
+	name := nameHash.New()
+	name.Write(t.PubArea)
+	expectedName := binary.BigEndian.AppendUint16(nil, pubArea.nameAlg)
+	if !bytes.Equal(certInfo.name, name.Sum(expectedName)) {
+		return nil, fmt.Errorf("certInfo does not attest pubArea")
+	}
+
+	certificates, err := parseCertificateChain(t.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyCertificateSignature(certificates[0], t.Algorithm, t.Signature, t.CertInfo)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyAIKCertificate(certificates[0])
+	if err != nil {
+		return nil, err
+	}
+	// the subject alternative name only contains the directory name, which
+	// the x509 package does not handle, so it would fail validating the
+	// chain
+	certificates[0] = withoutCriticalExtension(certificates[0], oidSubjectAltName)
+
+	err = verifyAAGUIDExtension(certificates[0], authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttestationResult{Type: AttestationTypeAttCA, TrustPath: certificates}, nil
+}
+
+// verifyAIKCertificate checks the requirements of the TPM attestation
+// statement format on the certificate of the attestation identity key.
+func verifyAIKCertificate(certificate *x509.Certificate) error {
+	if certificate.Version != 3 {
+		return fmt.Errorf("aik certificate must be version 3")
+	}
+	if len(certificate.Subject.Names) != 0 {
+		return fmt.Errorf("aik certificate subject must be empty")
+	}
+	if certificate.IsCA {
+		return fmt.Errorf("aik certificate must not be a ca")
+	}
+
+	found := false
+	for _, usage := range certificate.UnknownExtKeyUsage {
+		if usage.Equal(oidTCGKPAIKCertificate) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("aik certificate extended key usage missing")
+	}
+
+	subjectAltName, ok := certificateExtension(certificate, oidSubjectAltName)
+	if !ok {
+		return fmt.Errorf("aik certificate subject alternative name missing")
+	}
+	return verifyTPMDeviceAttributes(subjectAltName)
+}
+
+// withoutCriticalExtension returns a copy of the certificate, that does not
+// mark the extension as unhandled. The certificate itself is left unchanged.
+func withoutCriticalExtension(certificate *x509.Certificate, oid asn1.ObjectIdentifier) *x509.Certificate {
+	copied := *certificate
+	copied.UnhandledCriticalExtensions = nil
+	for _, extension := range certificate.UnhandledCriticalExtensions {
+		if !extension.Equal(oid) {
+			copied.UnhandledCriticalExtensions = append(copied.UnhandledCriticalExtensions, extension)
+		}
+	}
+	return &copied
+}
+
+// verifyTPMDeviceAttributes checks the subject alternative name contains the
+// manufacturer, model and version of the TPM as directory name.
+func verifyTPMDeviceAttributes(subjectAltName []byte) error {
+	var generalNames []asn1.RawValue
+	rest, err := asn1.Unmarshal(subjectAltName, &generalNames)
+	if err != nil || len(rest) != 0 {
+		return fmt.Errorf("invalid aik certificate subject alternative name")
+	}
+
+	attributes := map[string]bool{}
+	for _, generalName := range generalNames {
+		// directoryName [4] Name
+		if generalName.Class != asn1.ClassContextSpecific || generalName.Tag != 4 {
+			continue
+		}
+		var name pkix.RDNSequence
+		_, err := asn1.Unmarshal(generalName.Bytes, &name)
+		if err != nil {
+			return fmt.Errorf("invalid aik certificate subject alternative name")
+		}
+		for _, rdn := range name {
+			for _, attribute := range rdn {
+				attributes[attribute.Type.String()] = true
+			}
+		}
+	}
+
+	for _, oid := range []asn1.ObjectIdentifier{oidTPMManufacturer, oidTPMModel, oidTPMVersion} {
+		if !attributes[oid.String()] {
+			return fmt.Errorf("aik certificate tpm attribute %s missing", oid)
+		}
+	}
+	return nil
+}
+
+// tpmReader reads the big-endian structures of the TPM. The first error is
+// kept, so that structures can be read without checking every field.
+type tpmReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tpmReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+	value := r.data[:n]
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *tpmReader) uint16() uint16 {
+	value := r.bytes(2)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(value)
+}
+
+func (r *tpmReader) uint32() uint32 {
+	value := r.bytes(4)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
+
+// sized reads a TPM2B structure, which is prefixed by its size.
+func (r *tpmReader) sized() []byte {
+	return r.bytes(int(r.uint16()))
+}
+
+// done returns the first error or an error if data is left.
+func (r *tpmReader) done() error {
+	if r.err == nil && len(r.data) != 0 {
+		return fmt.Errorf("unexpected data after structure")
+	}
+	return r.err
+}
+
+// tpmPublic is the public area (TPMT_PUBLIC) of a TPM object.
+type tpmPublic struct {
+	nameAlg   uint16
+	publicKey crypto.PublicKey
+}
+
+func parseTPMPublic(data []byte) (*tpmPublic, error) {
+	r := &tpmReader{data: data}
+	public := &tpmPublic{}
+	keyType := r.uint16()
+	public.nameAlg = r.uint16()
+	// objectAttributes
+	r.uint32()
+	// authPolicy
+	r.sized()
+
+	switch keyType {
+	case tpmAlgRSA:
+		// symmetric
+		if r.uint16() != tpmAlgNull {
+			// keyBits and mode
+			r.bytes(4)
+		}
+		skipTPMScheme(r)
+		// keyBits
+		r.uint16()
+		exponent := int(r.uint32())
+		if exponent == 0 {
+			exponent = 65537
+		}
+		modulus := r.sized()
+		public.publicKey = &rsa.PublicKey{
+			N: big.NewInt(0).SetBytes(modulus),
+			E: exponent,
+		}
+	case tpmAlgECC:
+		// symmetric
+		if r.uint16() != tpmAlgNull {
+			r.bytes(4)
+		}
+		skipTPMScheme(r)
+		curveID := r.uint16()
+		// kdf
+		skipTPMScheme(r)
+		x := r.sized()
+		y := r.sized()
+
+		var curve elliptic.Curve
+		switch curveID {
+		case tpmECCNistP256:
+			curve = elliptic.P256()
+		case tpmECCNistP384:
+			curve = elliptic.P384()
+		case tpmECCNistP521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %#04x", curveID)
+		}
+		public.publicKey = &ecdsa.PublicKey{
+			Curve: curve,
+			X:     big.NewInt(0).SetBytes(x),
+			Y:     big.NewInt(0).SetBytes(y),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %#04x", keyType)
+	}
+
+	return public, r.done()
+}
+
+// skipTPMScheme skips a signature or kdf scheme and its details.
+func skipTPMScheme(r *tpmReader) {
+	scheme := r.uint16()
+	if scheme == tpmAlgNull {
+		return
+	}
+	// hashAlg
+	r.uint16()
+	if scheme == tpmAlgECDAA {
+		// count
+		r.uint16()
+	}
+}
+
+// tpmAttest is the attestation (TPMS_ATTEST) of the TPM certifying a key.
+type tpmAttest struct {
+	magic      uint32
+	attestType uint16
+	extraData  []byte
+	name       []byte
+}
+
+func parseTPMAttest(data []byte) (*tpmAttest, error) {
+	r := &tpmReader{data: data}
+	attest := &tpmAttest{}
+	attest.magic = r.uint32()
+	attest.attestType = r.uint16()
+	// qualifiedSigner
+	r.sized()
+	attest.extraData = r.sized()
+	// clockInfo and firmwareVersion
+	r.bytes(17 + 8)
+	if attest.attestType == tpmSTAttestCertify {
+		attest.name = r.sized()
+		// qualifiedName
+		r.sized()
+	}
+	return attest, r.done()
+}
@@ -0,0 +1,52 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+)
+
+// fidoU2FAttestationStatement is sent by FIDO U2F security keys, which sign
+// the registration with their batch attestation certificate.
+type fidoU2FAttestationStatement struct {
+	Signature        []byte   `cbor:"sig"`
+	CertificateChain [][]byte `cbor:"x5c"`
+}
+
+func (f *fidoU2FAttestationStatement) Verify(authenticatorData AuthData, clientDataHash []byte) (*AttestationResult, error) {
+	if len(f.CertificateChain) != 1 {
+		return nil, fmt.Errorf("exactly one attestation certificate expected")
+	}
+	certificates, err := parseCertificateChain(f.CertificateChain)
+	if err != nil {
+		return nil, err
+	}
+	certificateKey, ok := certificates[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok || certificateKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("attestation certificate must have a P-256 key")
+	}
+
+	credentialKey, ok := authenticatorData.CredentialPublicKey.Public().(*ecdsa.PublicKey)
+	if !ok || credentialKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("credential public key must be a P-256 key")
+	}
+
+	// the signed data is the registration response of U2F with the public
+	// key in uncompressed form
+	verificationData := []byte{0x00}
+	verificationData = append(verificationData, authenticatorData.RPIDHash...)
+	verificationData = append(verificationData, clientDataHash...)
+	verificationData = append(verificationData, authenticatorData.CredentialID...)
+	publicKey, err := credentialKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	verificationData = append(verificationData, publicKey.Bytes()...)
+
+	err = verifyCertificateSignature(certificates[0], -7, f.Signature, verificationData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AttestationResult{Type: AttestationTypeBasic, TrustPath: certificates}, nil
+}
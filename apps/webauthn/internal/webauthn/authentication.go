@@ -3,6 +3,8 @@ package webauthn
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
@@ -16,21 +18,29 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-const rpId = "localhost" // TODO: make customizable
+const (
+	// ceremonyTimeout is the time the user has to complete a ceremony
+	ceremonyTimeout = time.Minute
+	// authenticationVerifierLifetime is the time the authorization flow has
+	// to claim a successful authentication
+	authenticationVerifierLifetime = 5 * time.Minute
+)
 
 type AuthenticationService struct {
 	initAuthenticationStore     core.KeyValueStore[string, CredentialOptions]
-	authenticationVerifierStore core.KeyValueStore[string, []byte]
+	authenticationVerifierStore domain.AuthenticationStore
 	userService                 *domain.UserService
 	credentialService           *domain.CredentialService
+	relyingParty                *RelyingParty
 	logger                      *slog.Logger
 }
 
 func NewAuthenticationService(
 	initAuthenticationStore core.KeyValueStore[string, CredentialOptions],
-	authenticationVerifierStore core.KeyValueStore[string, []byte],
+	authenticationVerifierStore domain.AuthenticationStore,
 	userService *domain.UserService,
 	credentialService *domain.CredentialService,
+	relyingParty *RelyingParty,
 ) *AuthenticationService {
 	logger := slog.Default().With("service", "web-authentication")
 
@@ -39,6 +49,7 @@ func NewAuthenticationService(
 		authenticationVerifierStore: authenticationVerifierStore,
 		userService:                 userService,
 		credentialService:           credentialService,
+		relyingParty:                relyingParty,
 		logger:                      logger,
 	}
 }
@@ -47,45 +58,68 @@ func (s *AuthenticationService) InitiateAuthentication(ctx context.Context, requ
 	id := uuid.New().String()
 	userIdBytes := []byte(request.UserId)
 
-	grouped := s.logger.With("userId", utils.EncodeBase64(utils.HashShake256(userIdBytes))).WithGroup("authentication").With("id", id)
+	logger := s.logger
+	if len(userIdBytes) > 0 {
+		logger = logger.With("userId", utils.EncodeBase64(utils.HashShake256(userIdBytes)))
+	}
+	grouped := logger.WithGroup("authentication").With("id", id)
 
 	grouped.DebugContext(ctx, "Starting authentication")
 
-	user, err := s.userService.GetUserByUserID(context.TODO(), userIdBytes)
-	if err != nil && err != gorm.ErrRecordNotFound {
-		return nil, err
+	var user *domain.User
+	var err error
+	if len(userIdBytes) > 0 {
+		user, err = s.userService.GetUserByUserID(context.TODO(), userIdBytes)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
 	}
 
 	var initResponse CredentialOptions
 
-	if user == nil {
+	if len(userIdBytes) == 0 {
+		// the user picks one of their discoverable credentials and is
+		// identified by its user handle
+		initResponse = &CredentialRequestOptions{
+			AuthenticationId: id,
+			Type:             "get",
+			Mediation:        request.Mediation,
+			Options: PublicKeyCredentialRequestOptions{
+				Challenge:        newChallenge(),
+				RpID:             s.relyingParty.ID,
+				UserVerification: "preferred",
+				Attestation:      "direct",
+				AllowCredentials: []PublicKeyCredentialDescriptor{},
+				Timeout:          uint64(ceremonyTimeout.Milliseconds()),
+			},
+		}
+
+		grouped = grouped.With("type", "get")
+
+		grouped.InfoContext(ctx, "Requesting discoverable credential")
+	} else if user == nil {
 		initResponse = &CredentialCreationOptions{
 			AuthenticationId: id,
 			Type:             "create",
 			Options: PublicKeyCredentialCreationOptions{
-				// TODO: randomly generate challenge
-				Challenge: []byte("1234567890"),
+				Challenge: newChallenge(),
 				RelyingParty: PublicKeyCredentialRpEntity{
-					Id:   rpId,
-					Name: "Modern Auth",
+					Id:   s.relyingParty.ID,
+					Name: s.relyingParty.Name,
 				},
 				User: PublicKeyCredentialUserEntity{
-					Id:          userIdBytes,
+					Id:          newUserHandle(),
 					Name:        request.UserId,
 					DisplayName: request.UserId,
 				},
-				PublicKeyCredentialParams: []PublicKeyCredentialParameters{
-					{
-						Type: "public-key",
-						Alg:  -7,
-					},
-				},
+				PublicKeyCredentialParams: supportedPublicKeyCredentialParameters(),
 				AuthenticationSelection: AuthenticationSelection{
 					AuthenticatorAttachment: "all",
-					RequireResidentKey:      false,
+					ResidentKey:             s.relyingParty.residentKeyRequirement(),
+					RequireResidentKey:      s.relyingParty.residentKeyRequirement() == "required",
 					UserVerification:        "preferred",
 				},
-				Timeout:     60000,
+				Timeout:     uint64(ceremonyTimeout.Milliseconds()),
 				Attestation: "indirect",
 			},
 		}
@@ -107,18 +141,25 @@ func (s *AuthenticationService) InitiateAuthentication(ctx context.Context, requ
 			})
 		}
 
+		// users registered before user handles were introduced have their
+		// user id as handle
+		userHandle := user.Handle
+		if len(userHandle) == 0 {
+			userHandle = userIdBytes
+		}
+
 		initResponse = &CredentialRequestOptions{
 			AuthenticationId: id,
 			Type:             "get",
+			Mediation:        request.Mediation,
 			Options: PublicKeyCredentialRequestOptions{
-				UserId: userIdBytes,
-				// TODO: randomly generate challenge
-				Challenge:        []byte("1234567890"),
-				RpID:             rpId,
+				UserHandle:       userHandle,
+				Challenge:        newChallenge(),
+				RpID:             s.relyingParty.ID,
 				UserVerification: "preferred",
 				Attestation:      "direct",
 				AllowCredentials: allowCredentials,
-				Timeout:          60000,
+				Timeout:          uint64(ceremonyTimeout.Milliseconds()),
 			},
 		}
 
@@ -127,7 +168,8 @@ func (s *AuthenticationService) InitiateAuthentication(ctx context.Context, requ
 		grouped.InfoContext(ctx, "Requesting existing credential")
 	}
 
-	err = s.initAuthenticationStore.Set(id, initResponse)
+	// the challenge is only valid for the ceremony, until it times out
+	err = s.initAuthenticationStore.SetWithTTL(id, initResponse, ceremonyTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -142,8 +184,10 @@ func (s *AuthenticationService) Register(ctx context.Context, request *CreateCre
 
 	grouped.DebugContext(ctx, "Received credential request")
 
-	options, err := s.initAuthenticationStore.Get(request.AuthenticationID)
+	// a ceremony can only be completed once
+	options, err := s.initAuthenticationStore.GetAndDelete(request.AuthenticationID)
 	if err != nil {
+		grouped.WarnContext(ctx, "Ceremony not found, it expired or was replayed")
 		return nil, err
 	}
 
@@ -168,9 +212,21 @@ func (s *AuthenticationService) Register(ctx context.Context, request *CreateCre
 
 	grouped.DebugContext(ctx, "Parsed credential request")
 
+	creationOptions, ok := options.(*CredentialCreationOptions)
+	if !ok {
+		return nil, fmt.Errorf("ceremony does not create a credential")
+	}
+
 	credential := &domain.Credential{}
 
-	err = response.Validate(options.GetOptions(), credential)
+	err = response.Validate(creationOptions.GetOptions(), s.relyingParty, credential)
+	if errors.Is(err, errAuthenticatorRejected) {
+		grouped.WarnContext(ctx, "Security event: authenticator rejected by policy",
+			"event", "authenticator_rejected",
+			"reason", err.Error(),
+		)
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +234,8 @@ func (s *AuthenticationService) Register(ctx context.Context, request *CreateCre
 	grouped.DebugContext(ctx, "Validated credential request")
 
 	userInstance := &domain.User{
-		UserID: options.GetUserID(),
+		UserID: []byte(creationOptions.Options.User.Name),
+		Handle: creationOptions.GetUserHandle(),
 		Status: "active",
 	}
 
@@ -207,6 +264,7 @@ func (s *AuthenticationService) Register(ctx context.Context, request *CreateCre
 type Success struct {
 	AccessToken  *domain.AccessToken  `json:"accessToken"`
 	RefreshToken *domain.RefreshToken `json:"refreshToken"`
+	User         *domain.User         `json:"-"`
 }
 
 func (s *AuthenticationService) Login(ctx context.Context, request *RequestCredentialRequest) (*Success, error) {
@@ -214,8 +272,10 @@ func (s *AuthenticationService) Login(ctx context.Context, request *RequestCrede
 
 	grouped.DebugContext(ctx, "Received credential request")
 
-	options, err := s.initAuthenticationStore.Get(request.AuthenticationID)
+	// a ceremony can only be completed once
+	options, err := s.initAuthenticationStore.GetAndDelete(request.AuthenticationID)
 	if err != nil {
+		grouped.WarnContext(ctx, "Ceremony not found, it expired or was replayed")
 		return nil, err
 	}
 
@@ -223,6 +283,10 @@ func (s *AuthenticationService) Login(ctx context.Context, request *RequestCrede
 	if err != nil {
 		return nil, err
 	}
+	if credential.Status != domain.CredentialStatusActive {
+		grouped.WarnContext(ctx, "Credential is not active", "status", credential.Status)
+		return nil, fmt.Errorf("credential is %s", credential.Status)
+	}
 
 	// NOTE: maybe move this to the authenticator controller
 	clientData := &clientData{
@@ -247,13 +311,36 @@ func (s *AuthenticationService) Login(ctx context.Context, request *RequestCrede
 
 	grouped.DebugContext(ctx, "Parsed credential request")
 
-	err = response.Validate(options.GetOptions(), credential)
+	storedSignCount := credential.SignCount
+	err = response.Validate(options.GetOptions(), s.relyingParty, credential)
+	if errors.Is(err, errPossiblyCloned) {
+		s.flagClonedCredential(ctx, grouped, credential, response.AuthenticatorData.SignCount)
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	grouped.DebugContext(ctx, "Validated credential request")
 
+	// the owner is only verified with a valid signature, so that forged
+	// requests cannot cause security events
+	err = s.verifyCredentialOwner(ctx, grouped, options.GetUserHandle(), response, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.credentialService.UpdateSignCount(ctx, credential)
+	if errors.Is(err, domain.ErrSignCountNotIncreased) {
+		// a concurrent ceremony stored the same or a higher sign count
+		credential.SignCount = storedSignCount
+		s.flagClonedCredential(ctx, grouped, credential, response.AuthenticatorData.SignCount)
+		return nil, errPossiblyCloned
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := s.IssueGrant(ctx, credential.User)
 	if err != nil {
 		return nil, err
@@ -264,6 +351,53 @@ func (s *AuthenticationService) Login(ctx context.Context, request *RequestCrede
 	return result, nil
 }
 
+// verifyCredentialOwner verifies the credential belongs to the user it is
+// presented for, which is identified by the user handle in usernameless
+// ceremonies. It must only be called after the signature is verified.
+func (s *AuthenticationService) verifyCredentialOwner(ctx context.Context, logger *slog.Logger, ceremonyHandle []byte, response *RequestCredentialResponse, credential *domain.Credential) error {
+	handle, err := response.OwnerHandle(ceremonyHandle)
+	if err != nil && !errors.Is(err, errCredentialUserMismatch) {
+		return err
+	}
+	if err == nil {
+		user, err := s.userService.GetUserByHandle(ctx, handle)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// users registered before user handles were introduced have
+			// their user id as handle
+			user, err = s.userService.GetUserByUserID(ctx, handle)
+		}
+		if err != nil {
+			return err
+		}
+		if credential.User != nil && credential.User.ID == user.ID {
+			return nil
+		}
+	}
+
+	logger.WarnContext(ctx, "Security event: credential presented for another user",
+		"event", "credential_user_mismatch",
+		"credential_id", credential.ID,
+	)
+	return errCredentialUserMismatch
+}
+
+// flagClonedCredential disables a credential, whose sign count went
+// backwards. It is impossible to tell whether the original or the clone was
+// presented, so the credential cannot be used anymore.
+func (s *AuthenticationService) flagClonedCredential(ctx context.Context, logger *slog.Logger, credential *domain.Credential, signCount uint32) {
+	logger.WarnContext(ctx, "Security event: sign count did not increase, credential possibly cloned",
+		"event", "credential_possibly_cloned",
+		"credential_id", credential.ID,
+		"stored_sign_count", credential.SignCount,
+		"sign_count", signCount,
+	)
+	credential.Status = domain.CredentialStatusPossiblyCloned
+	err := s.credentialService.UpdateCredential(ctx, credential)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to flag credential", "err", err, "credential_id", credential.ID)
+	}
+}
+
 func (s *AuthenticationService) IssueGrant(ctx context.Context, user *domain.User) (*Success, error) {
 	grant := domain.NewGrant(user.ID)
 	grant.AllowRefreshToken = true
@@ -279,7 +413,7 @@ func (s *AuthenticationService) IssueGrant(ctx context.Context, user *domain.Use
 
 	s.logger.DebugContext(ctx, "Issued authentication grant", "userUid", user.ID, "grantId", grant.ID)
 
-	return &Success{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+	return &Success{AccessToken: accessToken, RefreshToken: refreshToken, User: user}, nil
 }
 
 type AuthenticationController struct {
@@ -343,13 +477,14 @@ func (c *AuthenticationController) createCredential(ctx *gin.Context) {
 		return
 	}
 
-	authVerifier, err := c.service.continueAuthorization(ctx.Request.Context(), cookie)
+	authVerifier, err := c.service.continueAuthorization(ctx.Request.Context(), cookie, result.User)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": "internal_server_error",
 		})
+		return
 	}
-	ctx.SetCookie("authentication_verifier", string(utils.EncodeBase64(authVerifier)), 300, "", "localhost", true, true)
+	setAuthenticationVerifier(ctx, authVerifier)
 
 	// TODO: maybe redirect
 	ctx.JSON(200, &result)
@@ -379,20 +514,39 @@ func (c *AuthenticationController) getCredential(ctx *gin.Context) {
 		return
 	}
 
-	authVerifier, err := c.service.continueAuthorization(ctx.Request.Context(), cookie)
-	ctx.SetCookie("authentication_verifier", string(utils.EncodeBase64(authVerifier)), 300, "", "localhost", true, true)
+	authVerifier, err := c.service.continueAuthorization(ctx.Request.Context(), cookie, result.User)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "internal_server_error",
+		})
+		return
+	}
+	setAuthenticationVerifier(ctx, authVerifier)
 
 	// TODO: maybe redirect
 	ctx.JSON(200, &result)
 }
 
-func (s *AuthenticationService) continueAuthorization(ctx context.Context, authorizationId string) ([]byte, error) {
+// setAuthenticationVerifier hands the verifier to the authorization server,
+// which is served from the same host. The cookie is host-only, so that it is
+// not sent to other hosts of the domain.
+func setAuthenticationVerifier(ctx *gin.Context, authVerifier []byte) {
+	ctx.SetCookie("authentication_verifier", string(utils.EncodeBase64(authVerifier)), 300, "/", "", true, true)
+}
+
+func (s *AuthenticationService) continueAuthorization(ctx context.Context, authorizationId string, user *domain.User) ([]byte, error) {
 	rand := make([]byte, 64)
 	utils.RandomBytes(rand)
 	firstHash := utils.HashShake256(rand)
 	secondHash := utils.HashShake256(firstHash)
 
-	err := s.authenticationVerifierStore.Set(authorizationId, secondHash)
+	err := s.authenticationVerifierStore.SetWithTTL(authorizationId, &domain.Authentication{
+		VerifierHash:    secondHash,
+		SubjectID:       user.ID,
+		AuthenticatedAt: time.Now(),
+		Methods:         []string{"hwk"},
+		ContextClass:    "phr",
+	}, authenticationVerifierLifetime)
 	if err != nil {
 		return nil, err
 	}
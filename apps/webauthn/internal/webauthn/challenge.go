@@ -0,0 +1,47 @@
+package webauthn
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/Untanky/modern-auth/internal/utils"
+)
+
+// challengeSize is the size of challenges in bytes. The specification
+// requires at least 16 bytes.
+const challengeSize = 32
+
+// newChallenge returns a random challenge for a single ceremony.
+func newChallenge() []byte {
+	challenge := make([]byte, challengeSize)
+	utils.RandomBytes(challenge)
+	return challenge
+}
+
+// userHandleSize is the size of user handles in bytes. The specification
+// allows at most 64 bytes.
+const userHandleSize = 32
+
+// newUserHandle returns a random user handle, which identifies the user on
+// discoverable credentials without revealing their user id.
+func newUserHandle() []byte {
+	handle := make([]byte, userHandleSize)
+	utils.RandomBytes(handle)
+	return handle
+}
+
+// verifyChallenge compares the challenge signed by the authenticator with
+// the challenge of the ceremony. Clients encode the challenge in the client
+// data with base64url without padding.
+func verifyChallenge(clientData clientData, challenge []byte) error {
+	signed, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil {
+		return fmt.Errorf("invalid challenge encoding")
+	}
+	if len(challenge) < challengeSize || subtle.ConstantTimeCompare(signed, challenge) != 1 {
+		return fmt.Errorf("invalid challenge")
+	}
+	return nil
+}
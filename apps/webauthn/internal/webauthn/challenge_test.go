@@ -0,0 +1,63 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyChallenge(t *testing.T) {
+	// 0xfb and 0xff encode to characters that differ between base64 and base64url
+	challenge := bytes.Repeat([]byte{0xfb, 0xff}, challengeSize/2)
+
+	tests := []struct {
+		name      string
+		signed    string
+		challenge []byte
+		wantErr   bool
+	}{
+		{
+			name:      "Matching challenge",
+			signed:    base64.RawURLEncoding.EncodeToString(challenge),
+			challenge: challenge,
+		},
+		{
+			name:      "Padded challenge",
+			signed:    base64.URLEncoding.EncodeToString(challenge),
+			challenge: challenge,
+		},
+		{
+			name:      "Other challenge",
+			signed:    base64.RawURLEncoding.EncodeToString(newChallenge()),
+			challenge: challenge,
+			wantErr:   true,
+		},
+		{
+			name:      "Standard base64",
+			signed:    base64.RawStdEncoding.EncodeToString(challenge),
+			challenge: challenge,
+			wantErr:   true,
+		},
+		{
+			name:      "Short challenge",
+			signed:    base64.RawURLEncoding.EncodeToString([]byte("1234567890")),
+			challenge: []byte("1234567890"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChallenge(clientData{Challenge: tt.signed}, tt.challenge)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewChallenge(t *testing.T) {
+	first, second := newChallenge(), newChallenge()
+	if len(first) != challengeSize || bytes.Equal(first, second) {
+		t.Errorf("newChallenge() = %x, %x, want distinct challenges of %d bytes", first, second, challengeSize)
+	}
+}
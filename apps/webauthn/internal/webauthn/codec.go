@@ -0,0 +1,47 @@
+package webauthn
+
+import (
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+// credentialOptionsData is the encoded form of CredentialOptions, which
+// keeps the kind of ceremony and the user handle, that is not sent to the
+// client.
+type credentialOptionsData struct {
+	Creation   *CredentialCreationOptions
+	Request    *CredentialRequestOptions
+	UserHandle []byte
+}
+
+// NewCredentialOptionsCodec returns a codec for the options of pending
+// ceremonies in the given format, for use with the store of initiated
+// authentications.
+func NewCredentialOptionsCodec(format string) (core.Codec[CredentialOptions], error) {
+	codec, err := core.NewCodec[*credentialOptionsData](format)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewMappedCodec[CredentialOptions, *credentialOptionsData](
+		codec,
+		func(options CredentialOptions) *credentialOptionsData {
+			switch options := options.(type) {
+			case *CredentialCreationOptions:
+				return &credentialOptionsData{Creation: options}
+			case *CredentialRequestOptions:
+				return &credentialOptionsData{Request: options, UserHandle: options.Options.UserHandle}
+			}
+			return &credentialOptionsData{}
+		},
+		func(data *credentialOptionsData) CredentialOptions {
+			if data.Creation != nil {
+				return data.Creation
+			}
+			if data.Request != nil {
+				data.Request.Options.UserHandle = data.UserHandle
+				return data.Request
+			}
+			return nil
+		},
+	), nil
+}
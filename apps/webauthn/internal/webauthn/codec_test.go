@@ -0,0 +1,60 @@
+package webauthn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+func TestCredentialOptionsCodec(t *testing.T) {
+	options := []CredentialOptions{
+		&CredentialCreationOptions{
+			AuthenticationId: "creation",
+			Type:             "create",
+			Options: PublicKeyCredentialCreationOptions{
+				Challenge:                 []byte("challenge"),
+				RelyingParty:              PublicKeyCredentialRpEntity{Id: "localhost", Name: "Modern Auth"},
+				User:                      PublicKeyCredentialUserEntity{Id: []byte("user"), Name: "user", DisplayName: "User"},
+				PublicKeyCredentialParams: []PublicKeyCredentialParameters{{Type: "public-key", Alg: -7}},
+				Timeout:                   60000,
+				Attestation:               "none",
+			},
+		},
+		&CredentialRequestOptions{
+			AuthenticationId: "request",
+			Type:             "get",
+			Options: PublicKeyCredentialRequestOptions{
+				// the user handle is not sent to the client, but must be kept
+				UserHandle:       []byte("user"),
+				Challenge:        []byte("challenge"),
+				RpID:             "localhost",
+				Timeout:          60000,
+				UserVerification: "preferred",
+				AllowCredentials: []PublicKeyCredentialDescriptor{{Type: "public-key", ID: []byte("credential")}},
+			},
+		},
+	}
+
+	for _, format := range []string{core.JSONCodecFormat, core.GobCodecFormat, core.CBORCodecFormat} {
+		t.Run(format, func(t *testing.T) {
+			codec, err := NewCredentialOptionsCodec(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range options {
+				data, err := codec.Encode(want)
+				if err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+				got, err := codec.Decode(data)
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Decode() = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
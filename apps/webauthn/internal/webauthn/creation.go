@@ -5,6 +5,7 @@ import (
 
 	"github.com/Untanky/modern-auth/internal/domain"
 	"github.com/Untanky/modern-auth/internal/utils"
+	"github.com/google/uuid"
 )
 
 type PublicKeyCredentialCreationOptions struct {
@@ -36,30 +37,32 @@ type PublicKeyCredentialParameters struct {
 
 type AuthenticationSelection struct {
 	AuthenticatorAttachment string `json:"authenticatorAttachment"`
-	RequireResidentKey      bool   `json:"requireResidentKey"`
-	UserVerification        string `json:"userVerification"`
+	// ResidentKey is "required" or "preferred" to create a discoverable
+	// credential, which can be used for usernameless login.
+	// RequireResidentKey is only set for clients supporting WebAuthn
+	// Level 1.
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
 }
 
-func (options *PublicKeyCredentialCreationOptions) ValidateClientData(clientData clientData) error {
+func (options *PublicKeyCredentialCreationOptions) ValidateClientData(clientData clientData, rp *RelyingParty) error {
 	if clientData.Type != "webauthn.create" {
 		return fmt.Errorf("invalid type")
 	}
-	if clientData.Challenge != string(utils.EncodeBase64([]byte(options.Challenge))) {
-		return fmt.Errorf("invalid challenge")
+	if err := verifyChallenge(clientData, options.Challenge); err != nil {
+		return err
 	}
-	// TODO: fix hardcoding
-	if clientData.Origin != "http://localhost:3000" {
-		return fmt.Errorf("invalid origin")
+	if err := rp.ValidateOrigin(clientData); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// ValidateAttestationObject validates the authenticator data. Unsupported
+// attestation formats are already rejected when decoding.
 func (options *PublicKeyCredentialCreationOptions) ValidateAttestationObject(attestationObject attestationObject) error {
-	if attestationObject.Format != "packed" {
-		return fmt.Errorf("invalid attestation format")
-	}
-
 	if err := options.ValidateAuthenticatorData(attestationObject.AuthData); err != nil {
 		return err
 	}
@@ -73,7 +76,7 @@ func (options *PublicKeyCredentialCreationOptions) ValidateAuthenticatorData(aut
 		return fmt.Errorf("invalid rpIdHash")
 	}
 
-	if err := authenticatorData.Flags.Verify(); err != nil {
+	if err := authenticatorData.Flags.Verify(options.AuthenticationSelection.UserVerification, true); err != nil {
 		return err
 	}
 
@@ -96,8 +99,8 @@ type CreationCredentialResponse struct {
 	AttestationObject attestationObject
 }
 
-func (response *CreationCredentialResponse) Validate(options PublicKeyCredentialOptions, credential *domain.Credential) error {
-	err := options.ValidateClientData(response.ClientData)
+func (response *CreationCredentialResponse) Validate(options PublicKeyCredentialOptions, rp *RelyingParty, credential *domain.Credential) error {
+	err := options.ValidateClientData(response.ClientData, rp)
 	if err != nil {
 		return err
 	}
@@ -109,13 +112,28 @@ func (response *CreationCredentialResponse) Validate(options PublicKeyCredential
 		return err
 	}
 
-	err = response.AttestationObject.Attestation.Verify(response.AttestationObject.AuthData, clientDataHash)
+	attestation, err := response.AttestationObject.Attestation.Verify(response.AttestationObject.AuthData, clientDataHash)
+	if err != nil {
+		return err
+	}
+
+	aaguid, err := uuid.FromBytes(response.AttestationObject.AuthData.AAGUID)
+	if err != nil {
+		return err
+	}
+	err = rp.VerifyAttestation(attestation, aaguid)
 	if err != nil {
 		return err
 	}
 
 	credential.CredentialID = response.AttestationObject.AuthData.CredentialID
 	credential.PublicKey = response.AttestationObject.AuthData.RawCredentialPublicKey
+	credential.SignCount = response.AttestationObject.AuthData.SignCount
+	backupEligible := response.AttestationObject.AuthData.Flags.Has(FlagBackupEligible)
+	credential.BackupEligible = &backupEligible
+	credential.BackupState = response.AttestationObject.AuthData.Flags.Has(FlagBackupState)
+	credential.AttestationType = string(attestation.Type)
+	credential.AAGUID = aaguid
 
 	return nil
 }
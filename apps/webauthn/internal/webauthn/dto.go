@@ -1,6 +1,8 @@
 package webauthn
 
 import (
+	"bytes"
+	"crypto"
 	"encoding/binary"
 	"fmt"
 
@@ -8,7 +10,7 @@ import (
 )
 
 type CredentialOptions interface {
-	GetUserID() []byte
+	GetUserHandle() []byte
 	GetAuthenticationID() string
 	IsCreationOptions() bool
 	GetOptions() PublicKeyCredentialOptions
@@ -16,10 +18,15 @@ type CredentialOptions interface {
 
 // Request object to initiate an authentication flow,
 type InitiateAuthenticationRequest struct {
-	// The unique identifier selected by the user
+	// The unique identifier selected by the user. If empty, a usernameless
+	// ceremony is started, in which the user picks a discoverable credential
 	//
 	// Never print this value in plain text
 	UserId string `json:"userId"`
+	// Mediation is passed to the client for ceremonies requesting an
+	// existing credential, e.g. "conditional" to offer the discoverable
+	// credentials as autofill
+	Mediation string `json:"mediation" binding:"omitempty,oneof=silent optional conditional required"`
 }
 
 type CredentialCreationOptions struct {
@@ -28,7 +35,7 @@ type CredentialCreationOptions struct {
 	Options          PublicKeyCredentialCreationOptions `json:"publicKey"`
 }
 
-func (options *CredentialCreationOptions) GetUserID() []byte {
+func (options *CredentialCreationOptions) GetUserHandle() []byte {
 	return options.Options.User.Id
 }
 
@@ -45,13 +52,17 @@ func (options *CredentialCreationOptions) GetOptions() PublicKeyCredentialOption
 }
 
 type CredentialRequestOptions struct {
-	AuthenticationId string                            `json:"authenticationId"`
-	Type             string                            `json:"type"`
-	Options          PublicKeyCredentialRequestOptions `json:"publicKey"`
+	AuthenticationId string `json:"authenticationId"`
+	Type             string `json:"type"`
+	// Mediation is requested by the client, e.g. "conditional" for
+	// usernameless ceremonies, which offer the discoverable credentials as
+	// autofill
+	Mediation string                            `json:"mediation,omitempty"`
+	Options   PublicKeyCredentialRequestOptions `json:"publicKey"`
 }
 
-func (options *CredentialRequestOptions) GetUserID() []byte {
-	return options.Options.UserId
+func (options *CredentialRequestOptions) GetUserHandle() []byte {
+	return options.Options.UserHandle
 }
 
 func (options *CredentialRequestOptions) GetAuthenticationID() string {
@@ -85,10 +96,12 @@ type RequestCredentialRequest struct {
 type RawClientDataJSON []byte
 
 type clientData struct {
-	Raw       []byte `json:"-"`
-	Origin    string `json:"origin"`
-	Type      string `json:"type"`
-	Challenge string `json:"challenge"`
+	Raw         []byte `json:"-"`
+	Origin      string `json:"origin"`
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	CrossOrigin bool   `json:"crossOrigin"`
+	TopOrigin   string `json:"topOrigin"`
 }
 
 type RawAttestationObject []byte
@@ -100,40 +113,30 @@ type attestationObject struct {
 }
 
 type AttestationStatement interface {
-	Verify(authData AuthData, clienDataHash []byte) error
+	Verify(authData AuthData, clienDataHash []byte) (*AttestationResult, error)
 }
 
 func (attestation RawAttestationObject) Decode() (*attestationObject, error) {
-	var rawAttestationObject map[string]interface{}
+	var rawAttestationObject struct {
+		Format    string          `cbor:"fmt"`
+		Statement cbor.RawMessage `cbor:"attStmt"`
+		AuthData  []byte          `cbor:"authData"`
+	}
 	err := cbor.Unmarshal(attestation, &rawAttestationObject)
 	if err != nil {
 		return nil, err
 	}
 
 	var attestationObject attestationObject
-	attestationObject.AuthData, err = decodeAuthData(rawAttestationObject["authData"].([]byte))
+	attestationObject.AuthData, err = decodeAuthData(rawAttestationObject.AuthData)
 	if err != nil {
 		return nil, err
 	}
-	attestationObject.Format = rawAttestationObject["fmt"].(string)
-
-	attestationStatement := rawAttestationObject["attStmt"].(map[interface{}]interface{})
-	switch attestationObject.Format {
-	case "packed":
-		certificates := [][]byte{}
-		chain := attestationStatement["x5c"]
-		if chain != nil {
-			for _, cert := range chain.([]interface{}) {
-				certificates = append(certificates, cert.([]byte))
-			}
-		}
-		attestationObject.Attestation = &packedAttestationStatemment{
-			algorithm:        int(attestationStatement["alg"].(int64)),
-			signature:        attestationStatement["sig"].([]byte),
-			certificateChain: certificates,
-		}
-	default:
-		return nil, fmt.Errorf("invalid attestation format")
+	attestationObject.Format = rawAttestationObject.Format
+
+	attestationObject.Attestation, err = decodeAttestationStatement(rawAttestationObject.Format, rawAttestationObject.Statement)
+	if err != nil {
+		return nil, err
 	}
 
 	return &attestationObject, nil
@@ -141,13 +144,52 @@ func (attestation RawAttestationObject) Decode() (*attestationObject, error) {
 
 type AuthFlags byte
 
-func (flags AuthFlags) Verify() error {
-	// TODO: implement
+const (
+	// FlagUserPresent (UP) is set if the user was present
+	FlagUserPresent AuthFlags = 1 << 0
+	// FlagUserVerified (UV) is set if the user was verified, e.g. by PIN or
+	// biometrics
+	FlagUserVerified AuthFlags = 1 << 2
+	// FlagBackupEligible (BE) is set if the credential may be backed up,
+	// e.g. synced passkeys. It never changes for a credential.
+	FlagBackupEligible AuthFlags = 1 << 3
+	// FlagBackupState (BS) is set if the credential is currently backed up
+	FlagBackupState AuthFlags = 1 << 4
+	// FlagAttestedCredentialData (AT) is set if the authenticator data
+	// contains the attested credential data
+	FlagAttestedCredentialData AuthFlags = 1 << 6
+	// FlagExtensionData (ED) is set if the authenticator data contains
+	// extension data
+	FlagExtensionData AuthFlags = 1 << 7
+)
+
+func (flags AuthFlags) Has(flag AuthFlags) bool {
+	return flags&flag == flag
+}
+
+// Verify checks the flags against the user verification requirement of the
+// ceremony. Attested credential data is only expected in create ceremonies.
+func (flags AuthFlags) Verify(userVerification string, attestedCredentialData bool) error {
+	if !flags.Has(FlagUserPresent) {
+		return fmt.Errorf("user not present")
+	}
+	if userVerification == "required" && !flags.Has(FlagUserVerified) {
+		return fmt.Errorf("user not verified")
+	}
+	if flags.Has(FlagBackupState) && !flags.Has(FlagBackupEligible) {
+		return fmt.Errorf("credential backed up, but not eligible for backup")
+	}
+	if flags.Has(FlagAttestedCredentialData) != attestedCredentialData {
+		return fmt.Errorf("unexpected attested credential data")
+	}
 	return nil
 }
 
 type PublicKey interface {
 	Algorithm() int
+	// Public returns the key in the representation of the crypto packages,
+	// e.g. to compare it with the key of a certificate.
+	Public() crypto.PublicKey
 	Verify(signature []byte, value []byte) bool
 }
 
@@ -155,32 +197,66 @@ type AuthData struct {
 	Raw                    []byte
 	RPIDHash               []byte
 	Flags                  AuthFlags
-	SignCount              []byte
+	SignCount              uint32
 	AAGUID                 []byte
 	CredentialID           []byte
 	RawCredentialPublicKey []byte
 	CredentialPublicKey    PublicKey
+	Extensions             []byte
 }
 
+// authDataMinLength is the length of the rpIdHash, the flags and the sign
+// count, which are always present.
+const authDataMinLength = 37
+
 func decodeAuthData(data []byte) (AuthData, error) {
 	authData := AuthData{}
+	if len(data) < authDataMinLength {
+		return authData, fmt.Errorf("authenticator data too short")
+	}
 	authData.Raw = data
 	authData.RPIDHash = data[:32]
 	authData.Flags = AuthFlags(data[32])
-	authData.SignCount = data[33:37]
-	if (len(data)) == 37 {
-		return authData, nil
+	authData.SignCount = binary.BigEndian.Uint32(data[33:37])
+	rest := data[authDataMinLength:]
+
+	if authData.Flags.Has(FlagAttestedCredentialData) {
+		if len(rest) < 18 {
+			return authData, fmt.Errorf("attested credential data too short")
+		}
+		authData.AAGUID = rest[:16]
+		credentialIDLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < credentialIDLength {
+			return authData, fmt.Errorf("credential id too short")
+		}
+		authData.CredentialID = rest[:credentialIDLength]
+		rest = rest[credentialIDLength:]
+
+		// the public key is followed by the extensions, so its length is only
+		// known after decoding it
+		var rawPublicKey cbor.RawMessage
+		decoder := cbor.NewDecoder(bytes.NewReader(rest))
+		if err := decoder.Decode(&rawPublicKey); err != nil {
+			return authData, err
+		}
+		authData.RawCredentialPublicKey = rest[:decoder.NumBytesRead()]
+		rest = rest[decoder.NumBytesRead():]
+		publicKey, err := decodeKey(authData.RawCredentialPublicKey)
+		if err != nil {
+			return authData, err
+		}
+		authData.CredentialPublicKey = publicKey
 	}
 
-	authData.AAGUID = data[37:53]
-	credentialIDLength := binary.BigEndian.Uint16(data[53:55])
-	authData.CredentialID = data[55 : 55+credentialIDLength]
-	authData.RawCredentialPublicKey = data[55+credentialIDLength:]
-	publicKey, err := decodeKey(authData.RawCredentialPublicKey)
-	if err != nil {
-		return authData, err
+	if authData.Flags.Has(FlagExtensionData) {
+		if err := cbor.Valid(rest); err != nil {
+			return authData, fmt.Errorf("invalid extension data: %w", err)
+		}
+		authData.Extensions = rest
+	} else if len(rest) > 0 {
+		return authData, fmt.Errorf("unexpected data after authenticator data")
 	}
-	authData.CredentialPublicKey = publicKey
 	return authData, nil
 }
 
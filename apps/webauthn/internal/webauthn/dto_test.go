@@ -0,0 +1,386 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// newTestKey returns an ES256 key and its COSE encoding.
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  -7,
+		-1: 1,
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, coseKey
+}
+
+// newTestAuthData encodes authenticator data. The credential is only
+// included if a public key is given.
+func newTestAuthData(rpID string, flags AuthFlags, signCount uint32, credentialID []byte, publicKey []byte, extensions []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, byte(flags))
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	if publicKey != nil {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(credentialID)))
+		data = append(data, credentialID...)
+		data = append(data, publicKey...)
+	}
+	return append(data, extensions...)
+}
+
+func TestAuthFlagsVerify(t *testing.T) {
+	tests := []struct {
+		name             string
+		flags            AuthFlags
+		userVerification string
+		attested         bool
+		wantErr          bool
+	}{
+		{
+			name:             "User present",
+			flags:            FlagUserPresent,
+			userVerification: "preferred",
+		},
+		{
+			name:             "User not present",
+			flags:            FlagUserVerified,
+			userVerification: "preferred",
+			wantErr:          true,
+		},
+		{
+			name:             "User verification required",
+			flags:            FlagUserPresent | FlagUserVerified,
+			userVerification: "required",
+		},
+		{
+			name:             "User verification required, but not verified",
+			flags:            FlagUserPresent,
+			userVerification: "required",
+			wantErr:          true,
+		},
+		{
+			name:             "Backed up",
+			flags:            FlagUserPresent | FlagBackupEligible | FlagBackupState,
+			userVerification: "preferred",
+		},
+		{
+			name:             "Backed up, but not eligible",
+			flags:            FlagUserPresent | FlagBackupState,
+			userVerification: "preferred",
+			wantErr:          true,
+		},
+		{
+			name:             "Attested credential data",
+			flags:            FlagUserPresent | FlagAttestedCredentialData,
+			userVerification: "preferred",
+			attested:         true,
+		},
+		{
+			name:             "Missing attested credential data",
+			flags:            FlagUserPresent,
+			userVerification: "preferred",
+			attested:         true,
+			wantErr:          true,
+		},
+		{
+			name:             "Unexpected attested credential data",
+			flags:            FlagUserPresent | FlagAttestedCredentialData,
+			userVerification: "preferred",
+			wantErr:          true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.flags.Verify(tt.userVerification, tt.attested)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeAuthData(t *testing.T) {
+	_, publicKey := newTestKey(t)
+	extensions, _ := cbor.Marshal(map[string]bool{"credProps": true})
+
+	tests := []struct {
+		name           string
+		data           []byte
+		wantSignCount  uint32
+		wantExtensions bool
+		wantErr        bool
+	}{
+		{
+			name:          "Assertion",
+			data:          newTestAuthData("localhost", FlagUserPresent, 7, nil, nil, nil),
+			wantSignCount: 7,
+		},
+		{
+			name:           "Assertion with extensions",
+			data:           newTestAuthData("localhost", FlagUserPresent|FlagExtensionData, 7, nil, nil, extensions),
+			wantSignCount:  7,
+			wantExtensions: true,
+		},
+		{
+			name:          "Attestation",
+			data:          newTestAuthData("localhost", FlagUserPresent|FlagAttestedCredentialData, 1, []byte("id"), publicKey, nil),
+			wantSignCount: 1,
+		},
+		{
+			name:           "Attestation with extensions",
+			data:           newTestAuthData("localhost", FlagUserPresent|FlagAttestedCredentialData|FlagExtensionData, 1, []byte("id"), publicKey, extensions),
+			wantSignCount:  1,
+			wantExtensions: true,
+		},
+		{
+			name:    "Extensions without flag",
+			data:    newTestAuthData("localhost", FlagUserPresent, 7, nil, nil, extensions),
+			wantErr: true,
+		},
+		{
+			name:    "Flag without extensions",
+			data:    newTestAuthData("localhost", FlagUserPresent|FlagExtensionData, 7, nil, nil, nil),
+			wantErr: true,
+		},
+		{
+			name:    "Too short",
+			data:    make([]byte, 36),
+			wantErr: true,
+		},
+		{
+			name:    "Truncated credential id",
+			data:    newTestAuthData("localhost", FlagUserPresent|FlagAttestedCredentialData, 1, []byte("id"), publicKey, nil)[:55],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authData, err := decodeAuthData(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeAuthData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if authData.SignCount != tt.wantSignCount {
+				t.Errorf("decodeAuthData() SignCount = %v, want %v", authData.SignCount, tt.wantSignCount)
+			}
+			if (authData.Extensions != nil) != tt.wantExtensions {
+				t.Errorf("decodeAuthData() Extensions = %x, want extensions %v", authData.Extensions, tt.wantExtensions)
+			}
+		})
+	}
+}
+
+func TestRequestCredentialResponseSignCount(t *testing.T) {
+	key, publicKey := newTestKey(t)
+	rp := DefaultRelyingParty()
+	challenge := newChallenge()
+	options := &PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		RpID:             rp.ID,
+		UserVerification: "preferred",
+	}
+	rawClientData, _ := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    rp.Origins[0],
+	})
+
+	tests := []struct {
+		name          string
+		stored        uint32
+		signCount     uint32
+		wantErr       error
+		wantSignCount uint32
+	}{
+		{
+			name:          "Increasing counter",
+			stored:        5,
+			signCount:     6,
+			wantSignCount: 6,
+		},
+		{
+			name:      "Repeated counter",
+			stored:    5,
+			signCount: 5,
+			wantErr:   errPossiblyCloned,
+		},
+		{
+			name:      "Decreasing counter",
+			stored:    5,
+			signCount: 2,
+			wantErr:   errPossiblyCloned,
+		},
+		{
+			name:      "Counter reset to zero",
+			stored:    5,
+			signCount: 0,
+			wantErr:   errPossiblyCloned,
+		},
+		{
+			name:          "Authenticator without counter",
+			stored:        0,
+			signCount:     0,
+			wantSignCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawAuthData := newTestAuthData(rp.ID, FlagUserPresent, tt.signCount, nil, nil, nil)
+			authData, err := decodeAuthData(rawAuthData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientData := clientData{Raw: rawClientData}
+			json.Unmarshal(rawClientData, &clientData)
+			clientDataHash := sha256.Sum256(rawClientData)
+			digest := sha256.Sum256(append(append([]byte{}, rawAuthData...), clientDataHash[:]...))
+			signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := &RequestCredentialResponse{
+				ClientData:        clientData,
+				AuthenticatorData: authData,
+				Signature:         signature,
+			}
+			credential := &domain.Credential{PublicKey: publicKey, SignCount: tt.stored}
+			err = response.Validate(options, rp, credential)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && credential.SignCount != tt.wantSignCount {
+				t.Errorf("Validate() SignCount = %v, want %v", credential.SignCount, tt.wantSignCount)
+			}
+		})
+	}
+}
+
+func TestVerifyBackupEligibility(t *testing.T) {
+	eligible, notEligible := true, false
+
+	tests := []struct {
+		name               string
+		stored             *bool
+		flags              AuthFlags
+		wantErr            error
+		wantBackupEligible bool
+	}{
+		{
+			name:               "Eligible",
+			stored:             &eligible,
+			flags:              FlagUserPresent | FlagBackupEligible,
+			wantBackupEligible: true,
+		},
+		{
+			name:               "Not eligible",
+			stored:             &notEligible,
+			flags:              FlagUserPresent,
+			wantBackupEligible: false,
+		},
+		{
+			name:    "Eligibility lost",
+			stored:  &eligible,
+			flags:   FlagUserPresent,
+			wantErr: errBackupEligibilityChanged,
+		},
+		{
+			name:    "Eligibility gained",
+			stored:  &notEligible,
+			flags:   FlagUserPresent | FlagBackupEligible,
+			wantErr: errBackupEligibilityChanged,
+		},
+		{
+			// credentials registered before the flag was stored
+			name:               "Unknown",
+			flags:              FlagUserPresent | FlagBackupEligible,
+			wantBackupEligible: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential := &domain.Credential{BackupEligible: tt.stored}
+			err := verifyBackupEligibility(tt.flags, credential)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyBackupEligibility() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && *credential.BackupEligible != tt.wantBackupEligible {
+				t.Errorf("BackupEligible = %v, want %v", *credential.BackupEligible, tt.wantBackupEligible)
+			}
+		})
+	}
+}
+
+func TestRequestCredentialResponseOwnerHandle(t *testing.T) {
+	tests := []struct {
+		name           string
+		ceremonyHandle []byte
+		userHandle     []byte
+		want           []byte
+		wantErr        bool
+	}{
+		{
+			name:       "Usernameless",
+			userHandle: []byte("alice"),
+			want:       []byte("alice"),
+		},
+		{
+			name:    "Usernameless without user handle",
+			wantErr: true,
+		},
+		{
+			name:           "Matching user handle",
+			ceremonyHandle: []byte("alice"),
+			userHandle:     []byte("alice"),
+			want:           []byte("alice"),
+		},
+		{
+			name:           "Without user handle",
+			ceremonyHandle: []byte("alice"),
+			want:           []byte("alice"),
+		},
+		{
+			name:           "Other user handle",
+			ceremonyHandle: []byte("alice"),
+			userHandle:     []byte("bob"),
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &RequestCredentialResponse{UserHandle: tt.userHandle}
+			got, err := response.OwnerHandle(tt.ceremonyHandle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OwnerHandle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("OwnerHandle() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package webauthn
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+// Metadata is a blob of the FIDO Metadata Service (MDS3), which describes
+// the authenticator models. It is downloaded out of band, e.g. from
+// https://mds3.fidoalliance.org/, and indexed by AAGUID.
+type Metadata struct {
+	// Number is the serial number of the blob, which increases with every
+	// update
+	Number int
+	// NextUpdate is the date the next blob is published
+	NextUpdate time.Time
+	entries    map[uuid.UUID]*MetadataEntry
+}
+
+// MetadataEntry describes an authenticator model. Entries of FIDO U2F
+// authenticators, which have no AAGUID, are not indexed.
+type MetadataEntry struct {
+	AAGUID            uuid.UUID         `json:"aaguid"`
+	MetadataStatement MetadataStatement `json:"metadataStatement"`
+	StatusReports     []StatusReport    `json:"statusReports"`
+}
+
+type MetadataStatement struct {
+	Description string `json:"description"`
+	// AttestationRootCertificates are the base64 encoded DER certificates
+	// attestations of the model chain to
+	AttestationRootCertificates []string `json:"attestationRootCertificates"`
+}
+
+type StatusReport struct {
+	Status        string `json:"status"`
+	EffectiveDate string `json:"effectiveDate"`
+}
+
+// errStaleMetadata is returned if the next update of the metadata is past
+// due, because it misses recently compromised authenticators.
+var errStaleMetadata = errors.New("stale metadata")
+
+type metadataBlob struct {
+	Number     int              `json:"no"`
+	NextUpdate string           `json:"nextUpdate"`
+	Entries    []*MetadataEntry `json:"entries"`
+}
+
+// certificationLevels ranks the certification statuses of authenticators.
+var certificationLevels = map[string]int{
+	"FIDO_CERTIFIED":        1,
+	"FIDO_CERTIFIED_L1":     1,
+	"FIDO_CERTIFIED_L1plus": 2,
+	"FIDO_CERTIFIED_L2":     3,
+	"FIDO_CERTIFIED_L2plus": 4,
+	"FIDO_CERTIFIED_L3":     5,
+	"FIDO_CERTIFIED_L3plus": 6,
+}
+
+// compromisedStatuses are the statuses reporting that the security of the
+// authenticator model is broken.
+var compromisedStatuses = map[string]bool{
+	"USER_VERIFICATION_BYPASS":     true,
+	"ATTESTATION_KEY_COMPROMISE":   true,
+	"USER_KEY_REMOTE_COMPROMISE":   true,
+	"USER_KEY_PHYSICAL_COMPROMISE": true,
+	"REVOKED":                      true,
+}
+
+// LoadMetadata reads a blob and verifies it is signed by a certificate
+// chaining to one of the roots. The FIDO Alliance signs its blobs with a
+// certificate of the GlobalSign Root CA - R3.
+func LoadMetadata(path string, roots *x509.CertPool) (*Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := ParseMetadata(data, roots)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata %s: %w", path, err)
+	}
+	return metadata, nil
+}
+
+// ParseMetadata verifies and decodes a blob, which is a JWT signed with the
+// key of the first certificate in the x5c header.
+func ParseMetadata(data []byte, roots *x509.CertPool) (*Metadata, error) {
+	blob := metadataBlob{}
+	_, err := jwt.Parse(string(data), &blob, func(header *jwt.Header) (*jwt.Key, error) {
+		certificate, err := verifyMetadataCertificates(header, roots)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.NewVerificationKey("", header.Algorithm, certificate.PublicKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nextUpdate, err := time.Parse(time.DateOnly, blob.NextUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nextUpdate: %w", err)
+	}
+	metadata := &Metadata{
+		Number:     blob.Number,
+		NextUpdate: nextUpdate,
+		entries:    make(map[uuid.UUID]*MetadataEntry, len(blob.Entries)),
+	}
+	if metadata.Stale() {
+		return nil, fmt.Errorf("%w: next update was due %s", errStaleMetadata, blob.NextUpdate)
+	}
+	for _, entry := range blob.Entries {
+		if entry.AAGUID != uuid.Nil {
+			metadata.entries[entry.AAGUID] = entry
+		}
+	}
+	return metadata, nil
+}
+
+// verifyMetadataCertificates validates the certificate chain of the blob
+// and returns the certificate it is signed with.
+func verifyMetadataCertificates(header *jwt.Header, roots *x509.CertPool) (*x509.Certificate, error) {
+	if len(header.X509CertificateChain) == 0 {
+		return nil, fmt.Errorf("signing certificate missing")
+	}
+	certificates := make([]*x509.Certificate, 0, len(header.X509CertificateChain))
+	for _, encoded := range header.X509CertificateChain {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing certificate: %w", err)
+		}
+		certificate, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+	return certificates[0], nil
+}
+
+// Stale reports whether the next blob should have been published already.
+func (m *Metadata) Stale() bool {
+	return now().After(m.NextUpdate)
+}
+
+// Entry returns the entry of the authenticator model with the AAGUID.
+func (m *Metadata) Entry(aaguid uuid.UUID) (*MetadataEntry, bool) {
+	entry, ok := m.entries[aaguid]
+	return entry, ok
+}
+
+// TrustAnchors returns the attestation root certificates of the model.
+func (e *MetadataEntry) TrustAnchors() (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, encoded := range e.MetadataStatement.AttestationRootCertificates {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation root certificate of %s: %w", e.AAGUID, err)
+		}
+		certificate, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation root certificate of %s: %w", e.AAGUID, err)
+		}
+		pool.AddCert(certificate)
+	}
+	return pool, nil
+}
+
+// CertificationLevel returns the highest level the model was certified
+// with, or 0 if it is not certified.
+func (e *MetadataEntry) CertificationLevel() int {
+	level := 0
+	for _, report := range e.StatusReports {
+		if certificationLevels[report.Status] > level {
+			level = certificationLevels[report.Status]
+		}
+	}
+	return level
+}
+
+// Compromised reports whether any status report reports the security of
+// the model as broken.
+func (e *MetadataEntry) Compromised() bool {
+	for _, report := range e.StatusReports {
+		if compromisedStatuses[report.Status] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+// newTestMetadataBlob signs the payload with a certificate issued by the
+// root.
+func newTestMetadataBlob(t *testing.T, root *x509.Certificate, rootKey *ecdsa.PrivateKey, payload interface{}) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "mds.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate := newTestCertificate(t, template, key, root, rootKey)
+	signingKey, err := jwt.NewSigningKey("", jwt.ES256, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := json.Marshal(&jwt.Header{
+		Algorithm:            jwt.ES256,
+		Type:                 "JWT",
+		X509CertificateChain: []string{base64.StdEncoding.EncodeToString(certificate.Raw)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature, err := signingKey.Sign([]byte(signingInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(signingInput + "." + base64.RawURLEncoding.EncodeToString(signature))
+}
+
+func newTestMetadataRoot(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	root, key := newTestCA(t, name)
+	return root, key.(*ecdsa.PrivateKey)
+}
+
+func TestParseMetadata(t *testing.T) {
+	aaguid := uuid.New()
+	nextUpdate := time.Now().UTC().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	payload := map[string]interface{}{
+		"no":         42,
+		"nextUpdate": nextUpdate.Format(time.DateOnly),
+		"entries": []interface{}{
+			map[string]interface{}{
+				"aaguid": aaguid.String(),
+				"metadataStatement": map[string]interface{}{
+					"description": "Test Authenticator",
+				},
+				"statusReports": []interface{}{
+					map[string]interface{}{"status": "FIDO_CERTIFIED_L1", "effectiveDate": "2023-01-01"},
+				},
+			},
+			map[string]interface{}{
+				"attestationCertificateKeyIdentifiers": []string{"bf7d7c2d1e1a8a3e7b1f0d6c5e0a7e3c9b1c2d3e"},
+			},
+		},
+	}
+	root, rootKey := newTestMetadataRoot(t, "Metadata Root")
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	blob := newTestMetadataBlob(t, root, rootKey, payload)
+
+	metadata, err := ParseMetadata(blob, roots)
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if metadata.Number != 42 || !metadata.NextUpdate.Equal(nextUpdate) {
+		t.Errorf("ParseMetadata() = %d, %v", metadata.Number, metadata.NextUpdate)
+	}
+	entry, ok := metadata.Entry(aaguid)
+	if !ok || entry.MetadataStatement.Description != "Test Authenticator" || entry.CertificationLevel() != 1 {
+		t.Errorf("Entry() = %v, %v", entry, ok)
+	}
+	if len(metadata.entries) != 1 {
+		t.Errorf("indexed %d entries, want 1", len(metadata.entries))
+	}
+
+	t.Run("Untrusted root", func(t *testing.T) {
+		otherRoot, otherKey := newTestMetadataRoot(t, "Other Root")
+		_, err := ParseMetadata(newTestMetadataBlob(t, otherRoot, otherKey, payload), roots)
+		if err == nil {
+			t.Errorf("ParseMetadata() of untrusted blob succeeded")
+		}
+	})
+
+	t.Run("Modified payload", func(t *testing.T) {
+		parts := strings.Split(string(blob), ".")
+		payload["no"] = 43
+		claims, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[1] = base64.RawURLEncoding.EncodeToString(claims)
+		_, err = ParseMetadata([]byte(strings.Join(parts, ".")), roots)
+		if !errors.Is(err, jwt.ErrInvalidSignature) {
+			t.Errorf("ParseMetadata() error = %v, want %v", err, jwt.ErrInvalidSignature)
+		}
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		stale := map[string]interface{}{"no": 41, "nextUpdate": "2024-02-01"}
+		_, err := ParseMetadata(newTestMetadataBlob(t, root, rootKey, stale), roots)
+		if !errors.Is(err, errStaleMetadata) {
+			t.Errorf("ParseMetadata() error = %v, want %v", err, errStaleMetadata)
+		}
+	})
+
+	t.Run("Load from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blob.jwt")
+		if err := os.WriteFile(path, blob, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMetadata(path, roots); err != nil {
+			t.Errorf("LoadMetadata() error = %v", err)
+		}
+	})
+}
+
+func TestAuthenticatorPolicyVerify(t *testing.T) {
+	aaguid := uuid.New()
+	other := uuid.New()
+	entry := func(statuses ...string) *MetadataEntry {
+		entry := &MetadataEntry{AAGUID: aaguid}
+		for _, status := range statuses {
+			entry.StatusReports = append(entry.StatusReports, StatusReport{Status: status})
+		}
+		return entry
+	}
+
+	tests := []struct {
+		name        string
+		policy      AuthenticatorPolicy
+		entry       *MetadataEntry
+		unvalidated bool
+		wantErr     bool
+	}{
+		{
+			name: "Empty policy",
+		},
+		{
+			name:        "Empty policy without validation",
+			unvalidated: true,
+		},
+		{
+			name:        "Allowed without validation",
+			policy:      AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{aaguid}},
+			unvalidated: true,
+			wantErr:     true,
+		},
+		{
+			name:        "Certified without validation",
+			policy:      AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L1"},
+			entry:       entry("FIDO_CERTIFIED_L2"),
+			unvalidated: true,
+			wantErr:     true,
+		},
+		{
+			name:   "Allowed",
+			policy: AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{other, aaguid}},
+		},
+		{
+			name:    "Not allowed",
+			policy:  AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{other}},
+			wantErr: true,
+		},
+		{
+			name:    "Denied",
+			policy:  AuthenticatorPolicy{DeniedAAGUIDs: []uuid.UUID{aaguid}},
+			wantErr: true,
+		},
+		{
+			name:    "Compromised",
+			entry:   entry("FIDO_CERTIFIED_L2", "USER_VERIFICATION_BYPASS"),
+			wantErr: true,
+		},
+		{
+			name:    "Revoked",
+			entry:   entry("REVOKED"),
+			wantErr: true,
+		},
+		{
+			name:   "Certified",
+			policy: AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L1plus"},
+			entry:  entry("FIDO_CERTIFIED_L1", "FIDO_CERTIFIED_L2"),
+		},
+		{
+			name:    "Certification level too low",
+			policy:  AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L2"},
+			entry:   entry("FIDO_CERTIFIED_L1plus"),
+			wantErr: true,
+		},
+		{
+			name:    "Not certified",
+			policy:  AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L1"},
+			entry:   entry("NOT_FIDO_CERTIFIED"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.verify(aaguid, tt.entry, !tt.unvalidated)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errAuthenticatorRejected) {
+				t.Errorf("verify() error = %v, want %v", err, errAuthenticatorRejected)
+			}
+		})
+	}
+}
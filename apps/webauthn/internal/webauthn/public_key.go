@@ -3,14 +3,73 @@ package webauthn
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
+	"crypto/rsa"
 	"fmt"
-	"hash"
 	"math/big"
 
 	"github.com/fxamacker/cbor/v2"
 )
 
+// COSE key types, see RFC 9053.
+const (
+	keyTypeOKP = 1
+	keyTypeEC2 = 2
+	keyTypeRSA = 3
+)
+
+// COSE elliptic curves, see RFC 9053.
+const (
+	curveP256    = 1
+	curveP384    = 2
+	curveP521    = 3
+	curveEd25519 = 6
+)
+
+// COSE algorithms of credential public keys.
+const (
+	AlgorithmEdDSA = -8
+	AlgorithmES256 = -7
+	AlgorithmES384 = -35
+	AlgorithmES512 = -36
+	AlgorithmPS256 = -37
+	AlgorithmRS256 = -257
+	AlgorithmRS384 = -258
+	AlgorithmRS512 = -259
+)
+
+// SupportedAlgorithms are the algorithms of credential public keys in order
+// of preference. Authenticators create the credential with the first
+// algorithm they support.
+var SupportedAlgorithms = []int{
+	AlgorithmEdDSA,
+	AlgorithmES256,
+	AlgorithmES384,
+	AlgorithmES512,
+	AlgorithmPS256,
+	AlgorithmRS256,
+	AlgorithmRS384,
+	AlgorithmRS512,
+}
+
+// minimumRSAKeySize is the minimum size of RSA keys in bits.
+const minimumRSAKeySize = 2048
+
+func supportedPublicKeyCredentialParameters() []PublicKeyCredentialParameters {
+	params := make([]PublicKeyCredentialParameters, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, PublicKeyCredentialParameters{
+			Type: "public-key",
+			Alg:  alg,
+		})
+	}
+	return params
+}
+
+// decodeKey decodes a COSE key. The key type, algorithm and curve must be
+// consistent, so that the algorithm a signature is verified with is always
+// the one the key was created for.
 func decodeKey(data []byte) (PublicKey, error) {
 	publicKeyData := publicKeyData{}
 	err := cbor.Unmarshal(data, &publicKeyData)
@@ -18,18 +77,23 @@ func decodeKey(data []byte) (PublicKey, error) {
 		return nil, err
 	}
 
+	var publicKey PublicKey
 	switch publicKeyData.KeyType {
-	case 2:
-		ec2PublicKey := ec2PublicKey{}
-		err := cbor.Unmarshal(data, &ec2PublicKey)
-		if err != nil {
-			return nil, err
-		}
-		ec2PublicKey.publicKeyData = publicKeyData
-		return &ec2PublicKey, nil
+	case keyTypeOKP:
+		publicKey = &okpPublicKey{}
+	case keyTypeEC2:
+		publicKey = &ec2PublicKey{}
+	case keyTypeRSA:
+		publicKey = &rsaPublicKey{}
 	default:
 		return nil, fmt.Errorf("invalid keyType")
 	}
+
+	err = cbor.Unmarshal(data, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return publicKey, nil
 }
 
 type publicKeyData struct {
@@ -41,25 +105,12 @@ func (k *publicKeyData) Algorithm() int {
 	return int(k.Alg)
 }
 
-func (k *publicKeyData) GetHashFunc() func() hash.Hash {
-	switch k.Alg {
-	case -7:
-		return crypto.SHA256.New
-	case -35:
-		return crypto.SHA384.New
-	case -36:
-		return crypto.SHA512.New
-	default:
-		// TODO: proper error handling
-		return nil
-	}
-}
-
 type ec2PublicKey struct {
 	publicKeyData
 	Curve elliptic.Curve
 	X     *big.Int
 	Y     *big.Int
+	hash  crypto.Hash
 }
 
 func (k *ec2PublicKey) UnmarshalCBOR(data []byte) error {
@@ -75,31 +126,153 @@ func (k *ec2PublicKey) UnmarshalCBOR(data []byte) error {
 		return err
 	}
 
-	switch cborData.Curve {
-	case 1:
-		k.Curve = elliptic.P256()
-	case 2:
-		k.Curve = elliptic.P384()
-	case 3:
-		k.Curve = elliptic.P521()
+	var curve int64
+	switch cborData.Alg {
+	case AlgorithmES256:
+		k.Curve, k.hash, curve = elliptic.P256(), crypto.SHA256, curveP256
+	case AlgorithmES384:
+		k.Curve, k.hash, curve = elliptic.P384(), crypto.SHA384, curveP384
+	case AlgorithmES512:
+		k.Curve, k.hash, curve = elliptic.P521(), crypto.SHA512, curveP521
 	default:
-		k.Curve = elliptic.P256()
+		return fmt.Errorf("invalid algorithm %d for EC2 key", cborData.Alg)
 	}
+	if cborData.Curve != curve {
+		return fmt.Errorf("curve %d does not match algorithm %d", cborData.Curve, cborData.Alg)
+	}
+	size := (k.Curve.Params().BitSize + 7) / 8
+	if len(cborData.X) != size || len(cborData.Y) != size {
+		return fmt.Errorf("invalid coordinate length for curve %d", cborData.Curve)
+	}
+
+	k.publicKeyData = cborData.publicKeyData
 	k.X = big.NewInt(0).SetBytes(cborData.X)
 	k.Y = big.NewInt(0).SetBytes(cborData.Y)
+	if _, err := k.Public().(*ecdsa.PublicKey).ECDH(); err != nil {
+		return fmt.Errorf("invalid EC2 key: %w", err)
+	}
 
 	return nil
 }
 
-func (k *ec2PublicKey) Verify(signature []byte, data []byte) bool {
-	key := ecdsa.PublicKey{
+func (k *ec2PublicKey) Public() crypto.PublicKey {
+	return &ecdsa.PublicKey{
 		Curve: k.Curve,
 		X:     k.X,
 		Y:     k.Y,
 	}
+}
 
-	hash := k.GetHashFunc()()
+func (k *ec2PublicKey) Verify(signature []byte, data []byte) bool {
+	hash := k.hash.New()
 	hash.Write(data)
 
-	return ecdsa.VerifyASN1(&key, hash.Sum(nil), signature)
+	return ecdsa.VerifyASN1(k.Public().(*ecdsa.PublicKey), hash.Sum(nil), signature)
+}
+
+// okpPublicKey is an octet key pair. Only Ed25519 is supported.
+type okpPublicKey struct {
+	publicKeyData
+	X ed25519.PublicKey
+}
+
+func (k *okpPublicKey) UnmarshalCBOR(data []byte) error {
+	type okpData struct {
+		publicKeyData
+		Curve int64  `cbor:"-1,keyasint" json:"crv"`
+		X     []byte `cbor:"-2,keyasint" json:"x"`
+	}
+	cborData := okpData{}
+	err := cbor.Unmarshal(data, &cborData)
+	if err != nil {
+		return err
+	}
+
+	if cborData.Alg != AlgorithmEdDSA {
+		return fmt.Errorf("invalid algorithm %d for OKP key", cborData.Alg)
+	}
+	if cborData.Curve != curveEd25519 {
+		return fmt.Errorf("curve %d does not match algorithm %d", cborData.Curve, cborData.Alg)
+	}
+	if len(cborData.X) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid Ed25519 key length")
+	}
+
+	k.publicKeyData = cborData.publicKeyData
+	k.X = cborData.X
+	return nil
+}
+
+func (k *okpPublicKey) Public() crypto.PublicKey {
+	return k.X
+}
+
+func (k *okpPublicKey) Verify(signature []byte, data []byte) bool {
+	return ed25519.Verify(k.X, data, signature)
+}
+
+type rsaPublicKey struct {
+	publicKeyData
+	N    *big.Int
+	E    int
+	hash crypto.Hash
+	pss  bool
+}
+
+func (k *rsaPublicKey) UnmarshalCBOR(data []byte) error {
+	type rsaData struct {
+		publicKeyData
+		N []byte `cbor:"-1,keyasint" json:"n"`
+		E []byte `cbor:"-2,keyasint" json:"e"`
+	}
+	cborData := rsaData{}
+	err := cbor.Unmarshal(data, &cborData)
+	if err != nil {
+		return err
+	}
+
+	switch cborData.Alg {
+	case AlgorithmPS256:
+		k.hash, k.pss = crypto.SHA256, true
+	case AlgorithmRS256:
+		k.hash = crypto.SHA256
+	case AlgorithmRS384:
+		k.hash = crypto.SHA384
+	case AlgorithmRS512:
+		k.hash = crypto.SHA512
+	default:
+		return fmt.Errorf("invalid algorithm %d for RSA key", cborData.Alg)
+	}
+
+	k.publicKeyData = cborData.publicKeyData
+	k.N = big.NewInt(0).SetBytes(cborData.N)
+	if k.N.BitLen() < minimumRSAKeySize {
+		return fmt.Errorf("RSA key must be at least %d bits", minimumRSAKeySize)
+	}
+	exponent := big.NewInt(0).SetBytes(cborData.E)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 || exponent.Bit(0) == 0 {
+		return fmt.Errorf("invalid RSA exponent")
+	}
+	k.E = int(exponent.Int64())
+
+	return nil
+}
+
+func (k *rsaPublicKey) Public() crypto.PublicKey {
+	return &rsa.PublicKey{
+		N: k.N,
+		E: k.E,
+	}
+}
+
+func (k *rsaPublicKey) Verify(signature []byte, data []byte) bool {
+	hash := k.hash.New()
+	hash.Write(data)
+
+	publicKey := k.Public().(*rsa.PublicKey)
+	if k.pss {
+		options := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		return rsa.VerifyPSS(publicKey, k.hash, hash.Sum(nil), signature, options) == nil
+	}
+	return rsa.VerifyPKCS1v15(publicKey, k.hash, hash.Sum(nil), signature) == nil
 }
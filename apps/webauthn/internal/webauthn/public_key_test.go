@@ -0,0 +1,273 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func encodeTestKey(t *testing.T, key map[int]interface{}) []byte {
+	t.Helper()
+	data, err := cbor.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestEC2Key(t *testing.T, curve elliptic.Curve, alg int, crv int) (*ecdsa.PrivateKey, map[int]interface{}) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	return key, map[int]interface{}{
+		1:  keyTypeEC2,
+		3:  alg,
+		-1: crv,
+		-2: key.X.FillBytes(make([]byte, size)),
+		-3: key.Y.FillBytes(make([]byte, size)),
+	}
+}
+
+func newTestRSAKey(t *testing.T, bits int, alg int) (*rsa.PrivateKey, map[int]interface{}) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, map[int]interface{}{
+		1:  keyTypeRSA,
+		3:  alg,
+		-1: key.N.Bytes(),
+		-2: big.NewInt(int64(key.E)).Bytes(),
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	_, p256 := newTestEC2Key(t, elliptic.P256(), AlgorithmES256, curveP256)
+	_, p384 := newTestEC2Key(t, elliptic.P384(), AlgorithmES384, curveP384)
+	_, p521 := newTestEC2Key(t, elliptic.P521(), AlgorithmES512, curveP521)
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	okp := map[int]interface{}{1: keyTypeOKP, 3: AlgorithmEdDSA, -1: curveEd25519, -2: []byte(ed25519Key)}
+	_, rsaKey := newTestRSAKey(t, 2048, AlgorithmRS256)
+	_, smallRSAKey := newTestRSAKey(t, 1024, AlgorithmRS256)
+
+	with := func(key map[int]interface{}, label int, value interface{}) map[int]interface{} {
+		copied := make(map[int]interface{}, len(key))
+		for k, v := range key {
+			copied[k] = v
+		}
+		copied[label] = value
+		return copied
+	}
+
+	tests := []struct {
+		name    string
+		key     map[int]interface{}
+		wantErr bool
+	}{
+		{
+			name: "ES256",
+			key:  p256,
+		},
+		{
+			name: "ES384",
+			key:  p384,
+		},
+		{
+			name: "ES512",
+			key:  p521,
+		},
+		{
+			name: "EdDSA",
+			key:  okp,
+		},
+		{
+			name: "RS256",
+			key:  rsaKey,
+		},
+		{
+			name: "PS256",
+			key:  with(rsaKey, 3, AlgorithmPS256),
+		},
+		{
+			name:    "Unknown key type",
+			key:     with(p256, 1, 4),
+			wantErr: true,
+		},
+		{
+			name:    "Curve does not match algorithm",
+			key:     with(p256, 3, AlgorithmES384),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown EC2 algorithm",
+			key:     with(p256, 3, -9),
+			wantErr: true,
+		},
+		{
+			name:    "EdDSA with EC2 key",
+			key:     with(p256, 3, AlgorithmEdDSA),
+			wantErr: true,
+		},
+		{
+			name:    "Short coordinate",
+			key:     with(p256, -2, make([]byte, 31)),
+			wantErr: true,
+		},
+		{
+			name:    "Point not on curve",
+			key:     with(p256, -3, make([]byte, 32)),
+			wantErr: true,
+		},
+		{
+			name:    "OKP with other curve",
+			key:     with(okp, -1, 4),
+			wantErr: true,
+		},
+		{
+			name:    "OKP with ES256",
+			key:     with(okp, 3, AlgorithmES256),
+			wantErr: true,
+		},
+		{
+			name:    "Short Ed25519 key",
+			key:     with(okp, -2, make([]byte, 31)),
+			wantErr: true,
+		},
+		{
+			name:    "RSA with ES256",
+			key:     with(rsaKey, 3, AlgorithmES256),
+			wantErr: true,
+		},
+		{
+			name:    "RSA key too small",
+			key:     smallRSAKey,
+			wantErr: true,
+		},
+		{
+			name:    "Even RSA exponent",
+			key:     with(rsaKey, -2, []byte{0x01, 0x00, 0x00}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeKey(encodeTestKey(t, tt.key))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && key.Algorithm() != tt.key[3] {
+				t.Errorf("Algorithm() = %d, want %d", key.Algorithm(), tt.key[3])
+			}
+		})
+	}
+}
+
+func TestPublicKeyVerify(t *testing.T) {
+	data := []byte("signed data")
+	sign := func(hash crypto.Hash, signer func(digest []byte) ([]byte, error)) func(t *testing.T) []byte {
+		return func(t *testing.T) []byte {
+			h := hash.New()
+			h.Write(data)
+			signature, err := signer(h.Sum(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		}
+	}
+
+	p384Key, p384 := newTestEC2Key(t, elliptic.P384(), AlgorithmES384, curveP384)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, rs256 := newTestRSAKey(t, 2048, AlgorithmRS256)
+	ps256 := map[int]interface{}{1: keyTypeRSA, 3: AlgorithmPS256, -1: rs256[-1], -2: rs256[-2]}
+	rs512 := map[int]interface{}{1: keyTypeRSA, 3: AlgorithmRS512, -1: rs256[-1], -2: rs256[-2]}
+
+	tests := []struct {
+		name      string
+		key       map[int]interface{}
+		signature func(t *testing.T) []byte
+	}{
+		{
+			name: "ES384",
+			key:  p384,
+			signature: sign(crypto.SHA384, func(digest []byte) ([]byte, error) {
+				return ecdsa.SignASN1(rand.Reader, p384Key, digest)
+			}),
+		},
+		{
+			name: "EdDSA",
+			key:  map[int]interface{}{1: keyTypeOKP, 3: AlgorithmEdDSA, -1: curveEd25519, -2: []byte(ed25519Key.Public().(ed25519.PublicKey))},
+			signature: func(t *testing.T) []byte {
+				return ed25519.Sign(ed25519Key, data)
+			},
+		},
+		{
+			name: "RS256",
+			key:  rs256,
+			signature: sign(crypto.SHA256, func(digest []byte) ([]byte, error) {
+				return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+			}),
+		},
+		{
+			name: "RS512",
+			key:  rs512,
+			signature: sign(crypto.SHA512, func(digest []byte) ([]byte, error) {
+				return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA512, digest)
+			}),
+		},
+		{
+			name: "PS256",
+			key:  ps256,
+			signature: sign(crypto.SHA256, func(digest []byte) ([]byte, error) {
+				return rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeKey(encodeTestKey(t, tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature := tt.signature(t)
+			if !key.Verify(signature, data) {
+				t.Errorf("Verify() = false, want true")
+			}
+			if key.Verify(signature, []byte("other data")) {
+				t.Errorf("Verify() of other data = true, want false")
+			}
+		})
+	}
+}
+
+func TestSupportedPublicKeyCredentialParameters(t *testing.T) {
+	params := supportedPublicKeyCredentialParameters()
+	if len(params) != len(SupportedAlgorithms) {
+		t.Fatalf("got %d parameters, want %d", len(params), len(SupportedAlgorithms))
+	}
+	for i, param := range params {
+		if param.Type != "public-key" || param.Alg != SupportedAlgorithms[i] {
+			t.Errorf("params[%d] = %v", i, param)
+		}
+	}
+	if params[0].Alg != AlgorithmEdDSA {
+		t.Errorf("preferred algorithm = %d, want %d", params[0].Alg, AlgorithmEdDSA)
+	}
+}
@@ -0,0 +1,323 @@
+package webauthn
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RelyingParty is the configuration of the relying party all ceremonies are
+// performed for.
+type RelyingParty struct {
+	// ID is the domain credentials are scoped to
+	ID string `json:"id"`
+	// Name is the display name shown by authenticators
+	Name string `json:"name"`
+	// Origins are the origins ceremonies may be performed on. Web origins
+	// may use a wildcard to allow all subdomains, e.g.
+	// "https://*.example.com". Origins of apps, e.g.
+	// "android:apk-key-hash:<hash>" or "ios:bundle-id:<bundle id>", must
+	// match exactly.
+	Origins []string `json:"origins"`
+	// TopOrigins are the origins of pages, which may embed the origins in a
+	// cross-origin iframe. Cross-origin ceremonies are rejected if empty.
+	TopOrigins []string `json:"topOrigins"`
+	// AttestationRoots is the path of a PEM file with the root certificates
+	// attestation certificate chains are validated against. Without it,
+	// chains are not validated and attestations cannot be trusted.
+	AttestationRoots string `json:"attestationRoots"`
+	// Metadata is the path of a FIDO Metadata Service (MDS3) blob, which
+	// provides the attestation roots and certification status of
+	// authenticator models. It must be signed by the root certificate in the
+	// PEM file MetadataRoot.
+	Metadata     string `json:"metadata"`
+	MetadataRoot string `json:"metadataRoot"`
+	// Policy restricts the authenticators credentials may be registered
+	// with
+	Policy AuthenticatorPolicy `json:"authenticatorPolicy"`
+	// ResidentKey is "required" or "preferred" (the default) and requests
+	// authenticators to create discoverable credentials, which allow
+	// usernameless login
+	ResidentKey string `json:"residentKey"`
+
+	attestationRoots *x509.CertPool
+	metadata         *Metadata
+}
+
+// AuthenticatorPolicy restricts authenticators by their AAGUID. Only
+// AAGUIDs of validated attestations can be trusted, so allowed AAGUIDs and a
+// minimum certification level reject attestations, that are not validated
+// against attestation roots or metadata.
+type AuthenticatorPolicy struct {
+	// AllowedAAGUIDs are the only authenticator models allowed, if set
+	AllowedAAGUIDs []uuid.UUID `json:"allowedAaguids"`
+	// DeniedAAGUIDs are the authenticator models rejected
+	DeniedAAGUIDs []uuid.UUID `json:"deniedAaguids"`
+	// MinimumCertificationLevel is the lowest FIDO certification status
+	// accepted, e.g. "FIDO_CERTIFIED_L1". Authenticators without metadata
+	// are rejected, if set.
+	MinimumCertificationLevel string `json:"minimumCertificationLevel"`
+}
+
+// errAuthenticatorRejected is returned if the policy rejects the
+// authenticator.
+var errAuthenticatorRejected = errors.New("authenticator rejected by policy")
+
+// verify checks the authenticator against the policy. Authenticators the
+// metadata reports as compromised are always rejected. The AAGUID is only
+// allowed, if the attestation is validated.
+func (policy *AuthenticatorPolicy) verify(aaguid uuid.UUID, entry *MetadataEntry, validated bool) error {
+	for _, denied := range policy.DeniedAAGUIDs {
+		if denied == aaguid {
+			return fmt.Errorf("%w: %s is denied", errAuthenticatorRejected, aaguid)
+		}
+	}
+	if !validated && (len(policy.AllowedAAGUIDs) > 0 || policy.MinimumCertificationLevel != "") {
+		return fmt.Errorf("%w: attestation of %s is not validated", errAuthenticatorRejected, aaguid)
+	}
+	if len(policy.AllowedAAGUIDs) > 0 {
+		allowed := false
+		for _, candidate := range policy.AllowedAAGUIDs {
+			if candidate == aaguid {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s is not allowed", errAuthenticatorRejected, aaguid)
+		}
+	}
+	if entry != nil && entry.Compromised() {
+		return fmt.Errorf("%w: %s is compromised", errAuthenticatorRejected, aaguid)
+	}
+	if policy.MinimumCertificationLevel != "" {
+		if entry == nil || entry.CertificationLevel() < certificationLevels[policy.MinimumCertificationLevel] {
+			return fmt.Errorf("%w: %s is not certified with %s", errAuthenticatorRejected, aaguid, policy.MinimumCertificationLevel)
+		}
+	}
+	return nil
+}
+
+// DefaultRelyingParty is used for local development.
+func DefaultRelyingParty() *RelyingParty {
+	return &RelyingParty{
+		ID:      "localhost",
+		Name:    "Modern Auth",
+		Origins: []string{"http://localhost:3000"},
+	}
+}
+
+// LoadRelyingParty reads the configuration from a JSON file.
+func LoadRelyingParty(path string) (*RelyingParty, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &RelyingParty{}
+	err = json.Unmarshal(data, rp)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.validate(); err != nil {
+		return nil, fmt.Errorf("invalid relying party configuration %s: %w", path, err)
+	}
+	if rp.AttestationRoots != "" {
+		rp.attestationRoots, err = loadCertificatePool(rp.AttestationRoots)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rp.Metadata != "" {
+		metadataRoots, err := loadCertificatePool(rp.MetadataRoot)
+		if err != nil {
+			return nil, err
+		}
+		rp.metadata, err = LoadMetadata(rp.Metadata, metadataRoots)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rp, nil
+}
+
+func loadCertificatePool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+func (rp *RelyingParty) validate() error {
+	if rp.ID == "" {
+		return fmt.Errorf("id missing")
+	}
+	if rp.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if len(rp.Origins) == 0 {
+		return fmt.Errorf("origins missing")
+	}
+	for _, origin := range append(rp.Origins, rp.TopOrigins...) {
+		if strings.Contains(origin, "*") && !strings.Contains(origin, "://*.") {
+			return fmt.Errorf("wildcard origin %s must be of the form scheme://*.domain", origin)
+		}
+	}
+	for _, origin := range rp.Origins {
+		if !rp.allowsOrigin(origin) {
+			return fmt.Errorf("origin %s is not the id %s or a subdomain of it", origin, rp.ID)
+		}
+	}
+	if rp.Metadata != "" && rp.MetadataRoot == "" {
+		return fmt.Errorf("metadataRoot missing")
+	}
+	level := rp.Policy.MinimumCertificationLevel
+	if _, ok := certificationLevels[level]; level != "" && !ok {
+		return fmt.Errorf("unknown certification level %s", level)
+	}
+	switch rp.ResidentKey {
+	case "", "required", "preferred":
+	default:
+		return fmt.Errorf("residentKey must be required or preferred")
+	}
+	return nil
+}
+
+// allowsOrigin reports whether browsers allow ceremonies for the id on the
+// origin, i.e. whether the host of a web origin is the id or a subdomain of
+// it. Origins of apps are not scoped by their host.
+func (rp *RelyingParty) allowsOrigin(origin string) bool {
+	parsed, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return true
+	}
+	host := parsed.Hostname()
+	return host == rp.ID || strings.HasSuffix(host, "."+rp.ID)
+}
+
+// residentKeyRequirement returns whether discoverable credentials are
+// required or preferred.
+func (rp *RelyingParty) residentKeyRequirement() string {
+	if rp.ResidentKey == "" {
+		return "preferred"
+	}
+	return rp.ResidentKey
+}
+
+// ValidateOrigin verifies the origins in the client data. Cross-origin
+// ceremonies must be embedded in one of the top origins.
+func (rp *RelyingParty) ValidateOrigin(clientData clientData) error {
+	if !matchOrigin(rp.Origins, clientData.Origin) {
+		return fmt.Errorf("invalid origin")
+	}
+	if !clientData.CrossOrigin {
+		return nil
+	}
+	if !matchOrigin(rp.TopOrigins, clientData.TopOrigin) {
+		return fmt.Errorf("invalid top origin")
+	}
+	return nil
+}
+
+// VerifyAttestation validates the certificate chain of the attestation and
+// checks the authenticator against the policy. Chains are validated against
+// the attestation roots of the authenticator model in the metadata, or else
+// the configured attestation roots. Attestations without a chain, i.e. self
+// attestation and none, are not validated. Attestations with a chain, but
+// without roots to validate it against, are downgraded to none. Both are
+// rejected, if the policy restricts the allowed authenticators. Stale
+// metadata rejects all attestations, until it is updated.
+func (rp *RelyingParty) VerifyAttestation(result *AttestationResult, aaguid uuid.UUID) error {
+	var entry *MetadataEntry
+	if rp.metadata != nil {
+		if rp.metadata.Stale() {
+			return fmt.Errorf("%w: next update was due %s", errStaleMetadata, rp.metadata.NextUpdate.Format(time.DateOnly))
+		}
+		entry, _ = rp.metadata.Entry(aaguid)
+	}
+
+	roots := rp.attestationRoots
+	if entry != nil && len(entry.MetadataStatement.AttestationRootCertificates) > 0 {
+		var err error
+		roots, err = entry.TrustAnchors()
+		if err != nil {
+			return err
+		}
+	}
+	validated := false
+	if len(result.TrustPath) > 0 {
+		if roots == nil {
+			// the chain cannot be validated, so the attestation is not
+			// trusted more than none
+			result.Type = AttestationTypeNone
+		} else {
+			err := verifyTrustPath(result.TrustPath, roots)
+			if err != nil {
+				return err
+			}
+			validated = true
+		}
+	}
+
+	return rp.Policy.verify(aaguid, entry, validated)
+}
+
+func verifyTrustPath(trustPath []*x509.Certificate, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, certificate := range trustPath[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := trustPath[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now(),
+		// attestation certificates are not issued for a specific usage
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("untrusted attestation: %w", err)
+	}
+	return nil
+}
+
+func matchOrigin(allowed []string, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, candidate := range allowed {
+		if candidate == origin || matchWildcardOrigin(candidate, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcardOrigin matches subdomains of the domain of the pattern, e.g.
+// "https://*.example.com" matches "https://login.example.com", but neither
+// "https://example.com" nor "http://login.example.com".
+func matchWildcardOrigin(pattern string, origin string) bool {
+	scheme, domain, found := strings.Cut(pattern, "://*.")
+	if !found {
+		return false
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme != scheme || parsed.Path != "" || parsed.User != nil {
+		return false
+	}
+	return strings.HasSuffix(parsed.Host, "."+domain)
+}
@@ -0,0 +1,361 @@
+package webauthn
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRelyingPartyValidateOrigin(t *testing.T) {
+	rp := &RelyingParty{
+		ID:   "example.com",
+		Name: "Example",
+		Origins: []string{
+			"https://example.com",
+			"https://*.example.com",
+			"android:apk-key-hash:dGVzdA",
+			"ios:bundle-id:com.example.app",
+		},
+		TopOrigins: []string{"https://partner.org"},
+	}
+
+	tests := []struct {
+		name       string
+		clientData clientData
+		wantErr    bool
+	}{
+		{
+			name:       "Exact origin",
+			clientData: clientData{Origin: "https://example.com"},
+		},
+		{
+			name:       "Subdomain",
+			clientData: clientData{Origin: "https://login.example.com"},
+		},
+		{
+			name:       "Nested subdomain",
+			clientData: clientData{Origin: "https://a.login.example.com"},
+		},
+		{
+			name:       "Android app",
+			clientData: clientData{Origin: "android:apk-key-hash:dGVzdA"},
+		},
+		{
+			name:       "iOS app",
+			clientData: clientData{Origin: "ios:bundle-id:com.example.app"},
+		},
+		{
+			name:       "Other scheme",
+			clientData: clientData{Origin: "http://login.example.com"},
+			wantErr:    true,
+		},
+		{
+			name:       "Other port",
+			clientData: clientData{Origin: "https://login.example.com:8443"},
+			wantErr:    true,
+		},
+		{
+			name:       "Lookalike domain",
+			clientData: clientData{Origin: "https://evilexample.com"},
+			wantErr:    true,
+		},
+		{
+			name:       "Other Android app",
+			clientData: clientData{Origin: "android:apk-key-hash:b3RoZXI"},
+			wantErr:    true,
+		},
+		{
+			name:       "Missing origin",
+			clientData: clientData{},
+			wantErr:    true,
+		},
+		{
+			name:       "Cross origin in allowed top origin",
+			clientData: clientData{Origin: "https://example.com", CrossOrigin: true, TopOrigin: "https://partner.org"},
+		},
+		{
+			name:       "Cross origin in other top origin",
+			clientData: clientData{Origin: "https://example.com", CrossOrigin: true, TopOrigin: "https://evil.org"},
+			wantErr:    true,
+		},
+		{
+			name:       "Cross origin without top origin",
+			clientData: clientData{Origin: "https://example.com", CrossOrigin: true},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := rp.ValidateOrigin(tt.clientData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRelyingParty(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "Valid configuration",
+			content: `{"id":"example.com","name":"Example","origins":["https://*.example.com"]}`,
+		},
+		{
+			name:    "Missing origins",
+			content: `{"id":"example.com","name":"Example"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Attestation roots",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"attestationRoots":"testdata/attestation/feitian-root.pem"}`,
+		},
+		{
+			name:    "Missing attestation roots",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"attestationRoots":"testdata/attestation/missing.pem"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Metadata without root",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"metadata":"blob.jwt"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Authenticator policy",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"authenticatorPolicy":{"deniedAaguids":["ee882879-721c-4913-9775-3dfcce97072a"],"minimumCertificationLevel":"FIDO_CERTIFIED_L1"}}`,
+		},
+		{
+			name:    "Unknown certification level",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"authenticatorPolicy":{"minimumCertificationLevel":"L1"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Resident key required",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"residentKey":"required"}`,
+		},
+		{
+			name:    "Resident key discouraged",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com"],"residentKey":"discouraged"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Subdomain and app origins",
+			content: `{"id":"example.com","name":"Example","origins":["https://login.example.com:8443","android:apk-key-hash:abc"],"topOrigins":["https://partner.com"]}`,
+		},
+		{
+			name:    "Origin of other domain",
+			content: `{"id":"example.com","name":"Example","origins":["https://example.com","https://example.org"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Origin with id as suffix",
+			content: `{"id":"example.com","name":"Example","origins":["https://badexample.com"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Wildcard origin of other domain",
+			content: `{"id":"login.example.com","name":"Example","origins":["https://*.example.com"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid wildcard",
+			content: `{"id":"example.com","name":"Example","origins":["https://login*.example.com"]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rp.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadRelyingParty(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRelyingParty() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRelyingPartyVerifyAttestation(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now })
+
+	verify := func(t *testing.T, format string, name string) (*AttestationResult, uuid.UUID) {
+		t.Helper()
+		for _, fixture := range loadAttestationFixtures(t, format) {
+			if fixture.Name != name {
+				continue
+			}
+			attestationObject, clientDataHash := decodeAttestationFixture(t, fixture)
+			result, err := attestationObject.Attestation.Verify(attestationObject.AuthData, clientDataHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result, uuid.UUID(attestationObject.AuthData.AAGUID)
+		}
+		t.Fatalf("fixture %s/%s not found", format, name)
+		return nil, uuid.Nil
+	}
+	feitianRoots, err := loadCertificatePool(filepath.Join("testdata", "attestation", "feitian-root.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, _ := newTestCA(t, "Other CA")
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA)
+
+	feitian, feitianAAGUID := verify(t, "packed", "Feitian BioPass")
+	tpm, tpmAAGUID := verify(t, "tpm", "ECC key")
+	self, selfAAGUID := verify(t, "packed", "Self attestation ES256")
+	// the root of the TPM is not part of the fixture, so its intermediate is
+	// trusted instead
+	tpmRoots := x509.NewCertPool()
+	tpmRoots.AddCert(tpm.TrustPath[1])
+
+	newMetadata := func(aaguid uuid.UUID, root *x509.Certificate, statuses ...string) *Metadata {
+		entry := &MetadataEntry{AAGUID: aaguid}
+		entry.MetadataStatement.AttestationRootCertificates = []string{base64.StdEncoding.EncodeToString(root.Raw)}
+		for _, status := range statuses {
+			entry.StatusReports = append(entry.StatusReports, StatusReport{Status: status})
+		}
+		return &Metadata{NextUpdate: now().AddDate(0, 1, 0), entries: map[uuid.UUID]*MetadataEntry{aaguid: entry}}
+	}
+	feitianRoot := feitian.TrustPath[2]
+	staleMetadata := newMetadata(feitianAAGUID, feitianRoot)
+	staleMetadata.NextUpdate = now().AddDate(0, -1, 0)
+
+	tests := []struct {
+		name     string
+		roots    *x509.CertPool
+		metadata *Metadata
+		policy   AuthenticatorPolicy
+		result   *AttestationResult
+		aaguid   uuid.UUID
+		wantType AttestationType
+		wantErr  bool
+	}{
+		{
+			name:     "Trusted chain",
+			roots:    feitianRoots,
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+			wantType: AttestationTypeBasic,
+		},
+		{
+			name:    "Untrusted chain",
+			roots:   otherRoots,
+			result:  feitian,
+			aaguid:  feitianAAGUID,
+			wantErr: true,
+		},
+		{
+			name:     "Without attestation roots",
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+			wantType: AttestationTypeNone,
+		},
+		{
+			name:     "TPM chain",
+			roots:    tpmRoots,
+			result:   tpm,
+			aaguid:   tpmAAGUID,
+			wantType: AttestationTypeAttCA,
+		},
+		{
+			name:   "Self attestation",
+			roots:  otherRoots,
+			result: self,
+			aaguid: selfAAGUID,
+		},
+		{
+			name:     "Trusted by metadata",
+			roots:    otherRoots,
+			metadata: newMetadata(feitianAAGUID, feitianRoot),
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+		},
+		{
+			name:     "Untrusted by metadata",
+			roots:    feitianRoots,
+			metadata: newMetadata(feitianAAGUID, otherCA),
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+			wantErr:  true,
+		},
+		{
+			name:     "Compromised",
+			metadata: newMetadata(feitianAAGUID, feitianRoot, "FIDO_CERTIFIED_L1", "ATTESTATION_KEY_COMPROMISE"),
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+			wantErr:  true,
+		},
+		{
+			name:     "Certified",
+			metadata: newMetadata(feitianAAGUID, feitianRoot, "FIDO_CERTIFIED_L2"),
+			policy:   AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L1"},
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+		},
+		{
+			name:    "Allowed without attestation roots",
+			policy:  AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{feitianAAGUID}},
+			result:  feitian,
+			aaguid:  feitianAAGUID,
+			wantErr: true,
+		},
+		{
+			name:    "Allowed self attestation",
+			roots:   otherRoots,
+			policy:  AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{selfAAGUID}},
+			result:  self,
+			aaguid:  selfAAGUID,
+			wantErr: true,
+		},
+		{
+			name:   "Allowed trusted chain",
+			roots:  feitianRoots,
+			policy: AuthenticatorPolicy{AllowedAAGUIDs: []uuid.UUID{feitianAAGUID}},
+			result: feitian,
+			aaguid: feitianAAGUID,
+		},
+		{
+			name:     "Stale metadata",
+			metadata: staleMetadata,
+			result:   feitian,
+			aaguid:   feitianAAGUID,
+			wantErr:  true,
+		},
+		{
+			name:    "Certification required without metadata",
+			policy:  AuthenticatorPolicy{MinimumCertificationLevel: "FIDO_CERTIFIED_L1"},
+			result:  feitian,
+			aaguid:  feitianAAGUID,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := DefaultRelyingParty()
+			rp.attestationRoots = tt.roots
+			rp.metadata = tt.metadata
+			rp.Policy = tt.policy
+			result := *tt.result
+			err := rp.VerifyAttestation(&result, tt.aaguid)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyAttestation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.wantType != "" && result.Type != tt.wantType {
+				t.Errorf("VerifyAttestation() type = %v, want %v", result.Type, tt.wantType)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package webauthn
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/Untanky/modern-auth/internal/domain"
@@ -8,17 +9,17 @@ import (
 )
 
 type CredentialResponse interface {
-	Validate(options CredentialOptions, credential *domain.Credential) error
+	Validate(options PublicKeyCredentialOptions, rp *RelyingParty, credential *domain.Credential) error
 }
 
 type PublicKeyCredentialOptions interface {
-	ValidateClientData(clientData clientData) error
+	ValidateClientData(clientData clientData, rp *RelyingParty) error
 	ValidateAttestationObject(attestationObject attestationObject) error
 	ValidateAuthenticatorData(authenticatorData AuthData) error
 }
 
 type PublicKeyCredentialRequestOptions struct {
-	UserId             []byte                          `json:"-"`
+	UserHandle         []byte                          `json:"-"`
 	Challenge          []byte                          `json:"challenge"`
 	RpID               string                          `json:"rpId"`
 	Timeout            uint64                          `json:"timeout"`
@@ -28,16 +29,15 @@ type PublicKeyCredentialRequestOptions struct {
 	AllowCredentials   []PublicKeyCredentialDescriptor `json:"allowCredentials"`
 }
 
-func (options *PublicKeyCredentialRequestOptions) ValidateClientData(clientData clientData) error {
+func (options *PublicKeyCredentialRequestOptions) ValidateClientData(clientData clientData, rp *RelyingParty) error {
 	if clientData.Type != "webauthn.get" {
 		return fmt.Errorf("invalid type")
 	}
-	if clientData.Challenge != string(utils.EncodeBase64([]byte(options.Challenge))) {
-		return fmt.Errorf("invalid challenge")
+	if err := verifyChallenge(clientData, options.Challenge); err != nil {
+		return err
 	}
-	// TODO: fix hardcoding
-	if clientData.Origin != "http://localhost:3000" {
-		return fmt.Errorf("invalid origin")
+	if err := rp.ValidateOrigin(clientData); err != nil {
+		return err
 	}
 
 	return nil
@@ -52,7 +52,7 @@ func (options *PublicKeyCredentialRequestOptions) ValidateAuthenticatorData(auth
 		return fmt.Errorf("invalid rpIdHash")
 	}
 
-	if err := authenticatorData.Flags.Verify(); err != nil {
+	if err := authenticatorData.Flags.Verify(options.UserVerification, false); err != nil {
 		return err
 	}
 
@@ -72,8 +72,8 @@ type RequestCredentialResponse struct {
 	UserHandle        []byte
 }
 
-func (response *RequestCredentialResponse) Validate(options PublicKeyCredentialOptions, credential *domain.Credential) error {
-	err := options.ValidateClientData(response.ClientData)
+func (response *RequestCredentialResponse) Validate(options PublicKeyCredentialOptions, rp *RelyingParty, credential *domain.Credential) error {
+	err := options.ValidateClientData(response.ClientData, rp)
 	if err != nil {
 		return err
 	}
@@ -96,5 +96,64 @@ func (response *RequestCredentialResponse) Validate(options PublicKeyCredentialO
 		return fmt.Errorf("invalid signature")
 	}
 
+	flags := response.AuthenticatorData.Flags
+	err = verifyBackupEligibility(flags, credential)
+	if err != nil {
+		return err
+	}
+	credential.BackupState = flags.Has(FlagBackupState)
+
+	signCount := response.AuthenticatorData.SignCount
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		return errPossiblyCloned
+	}
+	credential.SignCount = signCount
+
+	return nil
+}
+
+// verifyBackupEligibility rejects credentials whose backup eligibility
+// changed, since it is fixed for the lifetime of a credential. Credentials
+// registered before the flag was stored record it instead.
+func verifyBackupEligibility(flags AuthFlags, credential *domain.Credential) error {
+	backupEligible := flags.Has(FlagBackupEligible)
+	if credential.BackupEligible == nil {
+		credential.BackupEligible = &backupEligible
+		return nil
+	}
+	if backupEligible != *credential.BackupEligible {
+		return errBackupEligibilityChanged
+	}
 	return nil
 }
+
+// OwnerHandle returns the handle of the user the credential is presented
+// for. In usernameless ceremonies the ceremony was started without a user,
+// so the user is identified by the user handle of the discoverable
+// credential. Otherwise the user handle, if returned, must match the user
+// handle of the ceremony.
+func (response *RequestCredentialResponse) OwnerHandle(ceremonyHandle []byte) ([]byte, error) {
+	if len(ceremonyHandle) == 0 {
+		if len(response.UserHandle) == 0 {
+			return nil, fmt.Errorf("user handle missing")
+		}
+		return response.UserHandle, nil
+	}
+	if len(response.UserHandle) != 0 && !bytes.Equal(response.UserHandle, ceremonyHandle) {
+		return nil, errCredentialUserMismatch
+	}
+	return ceremonyHandle, nil
+}
+
+// errCredentialUserMismatch is returned if a credential is presented for
+// another user than it belongs to.
+var errCredentialUserMismatch = fmt.Errorf("credential does not belong to user")
+
+// errPossiblyCloned is returned if the sign count of a credential did not
+// increase. Either the authenticator was cloned, or the clone was used
+// before.
+var errPossiblyCloned = fmt.Errorf("sign count did not increase, credential possibly cloned")
+
+// errBackupEligibilityChanged is returned if the backup eligibility of a
+// credential differs from the one recorded before.
+var errBackupEligibilityChanged = fmt.Errorf("backup eligibility of credential changed")
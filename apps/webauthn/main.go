@@ -1,7 +1,148 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"flag"
+	"github.com/Untanky/modern-auth/apps/webauthn/internal/webauthn"
+	"github.com/Untanky/modern-auth/internal/app"
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/domain"
+	ginApp "github.com/Untanky/modern-auth/internal/gin"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/Untanky/modern-auth/internal/storage"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
+	"log/slog"
+	"time"
+)
+
+const (
+	ContextPath = "/api/v1/webauthn"
+)
+
+var (
+	db             *gorm.DB
+	redisClient    *redis.Client
+	encryptionKeys *core.KeyEncryptionKeys
+	relyingParty   *webauthn.RelyingParty
+
+	redisAddress      = flag.String("redisAddress", "", "the address of the Redis server to store pending ceremonies and authentications in; the database is used if empty")
+	redisCodec        = flag.String("redisCodec", core.JSONCodecFormat, "the encoding of stored values ('json', 'gob' or 'cbor')")
+	relyingPartyFile  = flag.String("relyingParty", "", "the JSON file configuring the relying party and its allowed origins; a relying party for local development is used if empty")
+	encryptionKeyfile = flag.String("encryptionKeys", "", "the keyfile of the key-encryption keys to encrypt pending ceremonies and authentications with; they are stored unencrypted if empty")
+)
 
 func main() {
-	fmt.Println("Hello World")
+	flag.Parse()
+
+	err := app.Sequence(
+		"Application initialization",
+		app.Step("Database initialization", initializeDatabase),
+		app.Step("Database migration", migrateDatabase),
+		app.Step("Redis initialization", initializeRedis),
+		app.Step("Encryption configuration", configureEncryption),
+		app.Step("Relying party configuration", configureRelyingParty),
+		app.Step("Service initialization", initializeServices),
+		app.Step("Gin configuration", ginApp.ConfigureGin),
+		app.Step("Telemetry configuration", ginApp.ConfigureTelemetry),
+		app.Step("Routing configuration", configureRoutes),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	err = app.AnnounceRun("Application", ginApp.Start)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func initializeDatabase() error {
+	dsn := "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable TimeZone=Europe/Berlin"
+	var err error
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func migrateDatabase() error {
+	return db.AutoMigrate(
+		&gormLocal.User{},
+		&gormLocal.Credential{},
+		&gormLocal.Grant{},
+		&gormLocal.KeyValueModel{},
+	)
+}
+
+func initializeRedis() error {
+	if *redisAddress == "" {
+		return nil
+	}
+
+	redisClient = redis.NewClient(&redis.Options{Addr: *redisAddress})
+	return redisClient.Ping(context.Background()).Err()
+}
+
+func configureEncryption() error {
+	if *encryptionKeyfile == "" {
+		return nil
+	}
+
+	var err error
+	encryptionKeys, err = core.LoadKeyEncryptionKeys(*encryptionKeyfile)
+	return err
+}
+
+func configureRelyingParty() error {
+	if *relyingPartyFile == "" {
+		slog.Warn("No relying party configured, using the relying party for local development")
+		relyingParty = webauthn.DefaultRelyingParty()
+		return nil
+	}
+
+	var err error
+	relyingParty, err = webauthn.LoadRelyingParty(*relyingPartyFile)
+	return err
+}
+
+var authenticationController *webauthn.AuthenticationController
+
+func initializeServices() error {
+	stores := storage.NewStores("webauthn", db, redisClient, encryptionKeys)
+
+	grantRepo := gormLocal.NewGormGrantRepo(db)
+	domain.UseGrantRepo(grantRepo)
+	go storage.DeleteExpired(context.Background(), grantRepo, time.Minute)
+
+	optionsCodec, err := webauthn.NewCredentialOptionsCodec(*redisCodec)
+	if err != nil {
+		return err
+	}
+	initAuthenticationStore := storage.NewKeyValueStore[webauthn.CredentialOptions](stores, "init_authentication", optionsCodec)
+	authenticationCodec, err := core.NewCodec[*domain.Authentication](*redisCodec)
+	if err != nil {
+		return err
+	}
+	authenticationVerifierStore := storage.NewKeyValueStore[*domain.Authentication](stores, "authentication", authenticationCodec)
+
+	userService := domain.NewUserService(gormLocal.NewGormUserRepo(db))
+	credentialService := domain.NewCredentialService(gormLocal.NewGormCredentialRepo(db))
+	authenticationService := webauthn.NewAuthenticationService(initAuthenticationStore, authenticationVerifierStore, userService, credentialService, relyingParty)
+	authenticationController = webauthn.NewAuthenticationController(authenticationService)
+
+	return nil
+}
+
+func configureRoutes() error {
+	authenticationController.RegisterRoutes(ginApp.GetRouter(ContextPath))
+
+	return nil
 }
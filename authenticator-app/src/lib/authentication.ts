@@ -27,13 +27,15 @@ const createCredential = (credOps: CredentialCreationOptions): Promise<PublicKey
     }) as Promise<PublicKeyCredential>;
 };
 
-export const login = async (credentialOptions: MyCredentialRequestOptions): Promise<void> => {
-    const credential = await getCredential(credentialOptions);
+export const login = async (credentialOptions: MyCredentialRequestOptions, signal?: AbortSignal): Promise<void> => {
+    const credential = await getCredential(credentialOptions, signal);
     await secureClient.login(credentialOptions.authenticationId, credential);
 };
 
-const getCredential = (credOps: CredentialRequestOptions): Promise<PublicKeyCredential> => {
+const getCredential = (credOps: CredentialRequestOptions, signal?: AbortSignal): Promise<PublicKeyCredential> => {
     return navigator.credentials.get({
+        mediation: credOps.mediation,
+        signal,
         publicKey: {
             ...credOps.publicKey,
             challenge: base64ToBuffer(credOps.publicKey.challenge as unknown as string),
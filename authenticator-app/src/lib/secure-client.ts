@@ -23,7 +23,7 @@ interface TokenResponse {
 
 let correlationId: string;
 
-export const initiateAuthentication = (userId: string): Promise<CredentialOptions> => {
+export const initiateAuthentication = (userId: string, mediation?: CredentialMediationRequirement): Promise<CredentialOptions> => {
     correlationId = crypto.randomUUID();
 
     return fetch('/v1/webauthn/authentication/initiate', {
@@ -34,7 +34,7 @@ export const initiateAuthentication = (userId: string): Promise<CredentialOption
             'Correlation-Id': correlationId,
             'Cache-Control': 'no-store',
         },
-        body: JSON.stringify({ userId }),
+        body: JSON.stringify({ userId, mediation }),
     }).then((response) => response.json());
 };
 
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	JSONCodecFormat = "json"
+	GobCodecFormat  = "gob"
+	CBORCodecFormat = "cbor"
+)
+
+// A Codec encodes values for stores that persist them outside of memory.
+type Codec[Type interface{}] interface {
+	Encode(value Type) ([]byte, error)
+	Decode(data []byte) (Type, error)
+}
+
+// NewCodec returns the codec for the given format.
+func NewCodec[Type interface{}](format string) (Codec[Type], error) {
+	switch format {
+	case JSONCodecFormat:
+		return JSONCodec[Type]{}, nil
+	case GobCodecFormat:
+		return GobCodec[Type]{}, nil
+	case CBORCodecFormat:
+		return CBORCodec[Type]{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec format '%s'", format)
+	}
+}
+
+type JSONCodec[Type interface{}] struct{}
+
+func (JSONCodec[Type]) Encode(value Type) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[Type]) Decode(data []byte) (Type, error) {
+	var value Type
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+type GobCodec[Type interface{}] struct{}
+
+func (GobCodec[Type]) Encode(value Type) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(value)
+	return buffer.Bytes(), err
+}
+
+func (GobCodec[Type]) Decode(data []byte) (Type, error) {
+	var value Type
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// cborEncMode keeps the sub-second precision of timestamps, which are
+// encoded as whole seconds by default.
+var cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+type CBORCodec[Type interface{}] struct{}
+
+func (CBORCodec[Type]) Encode(value Type) ([]byte, error) {
+	return cborEncMode.Marshal(value)
+}
+
+func (CBORCodec[Type]) Decode(data []byte) (Type, error) {
+	var value Type
+	err := cbor.Unmarshal(data, &value)
+	return value, err
+}
+
+// MappedCodec encodes values by mapping them to a different type first. It
+// allows to encode types with unexported fields.
+type MappedCodec[Type interface{}, Data interface{}] struct {
+	codec  Codec[Data]
+	toData func(value Type) Data
+	toType func(data Data) Type
+}
+
+func NewMappedCodec[Type interface{}, Data interface{}](codec Codec[Data], toData func(value Type) Data, toType func(data Data) Type) *MappedCodec[Type, Data] {
+	return &MappedCodec[Type, Data]{
+		codec:  codec,
+		toData: toData,
+		toType: toType,
+	}
+}
+
+func (c *MappedCodec[Type, Data]) Encode(value Type) ([]byte, error) {
+	return c.codec.Encode(c.toData(value))
+}
+
+func (c *MappedCodec[Type, Data]) Decode(data []byte) (Type, error) {
+	decoded, err := c.codec.Decode(data)
+	if err != nil {
+		var empty Type
+		return empty, err
+	}
+	return c.toType(decoded), nil
+}
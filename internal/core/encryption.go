@@ -0,0 +1,267 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// keyEncryptionKeySize is the size of key-encryption keys and data keys,
+// which selects AES-256.
+const keyEncryptionKeySize = 32
+
+// KeyEncryptionKeys holds the keys used to encrypt the data keys of
+// encrypted values. New values are encrypted with the current key, while the
+// others are kept to decrypt values encrypted before a rotation.
+type KeyEncryptionKeys struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyEncryptionKeys creates the keys from 32 byte AES keys by their id.
+// The current key must be one of the keys.
+func NewKeyEncryptionKeys(currentID string, keys map[string][]byte) (*KeyEncryptionKeys, error) {
+	kek := &KeyEncryptionKeys{
+		currentID: currentID,
+		keys:      make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if len(key) != keyEncryptionKeySize {
+			return nil, fmt.Errorf("key encryption key %s must be %d bytes, got %d", id, keyEncryptionKeySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		kek.keys[id] = aead
+	}
+	if _, ok := kek.keys[currentID]; !ok {
+		return nil, fmt.Errorf("current key encryption key %s not found", currentID)
+	}
+	return kek, nil
+}
+
+// LoadKeyEncryptionKeys reads the keys from a keyfile. The first key is the
+// current one. To rotate, add a new key at the top and keep the old keys
+// until all values encrypted with them are re-encrypted or expired.
+func LoadKeyEncryptionKeys(path string) (*KeyEncryptionKeys, error) {
+	currentID, keys, err := readKeyfile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyEncryptionKeys(currentID, keys)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedValue is a value encrypted with a random data key, which itself
+// is encrypted with a key-encryption key. Only the encrypted value is stored.
+type EncryptedValue struct {
+	// KeyID is the id of the key-encryption key
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+// Encrypt encrypts the plaintext with a new data key, which is encrypted with
+// the current key-encryption key. The additional data is authenticated, but
+// not stored, and must be passed to Decrypt again.
+func (kek *KeyEncryptionKeys) Encrypt(plaintext []byte, additionalData []byte) (*EncryptedValue, error) {
+	dataKey := make([]byte, keyEncryptionKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(aead, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := seal(kek.keys[kek.currentID], dataKey, []byte(kek.currentID))
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedValue{
+		KeyID:        kek.currentID,
+		EncryptedKey: encryptedKey,
+		Ciphertext:   ciphertext,
+	}, nil
+}
+
+// Decrypt decrypts a value encrypted with any of the keys.
+func (kek *KeyEncryptionKeys) Decrypt(value *EncryptedValue, additionalData []byte) ([]byte, error) {
+	keyAEAD, ok := kek.keys[value.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("key encryption key %s not found", value.KeyID)
+	}
+	dataKey, err := open(keyAEAD, value.EncryptedKey, []byte(value.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, value.Ciphertext, additionalData)
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to
+// the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// EncryptedKeyValueStore is a KeyValueStore decorator, which encrypts values
+// with AES-GCM before passing them to the underlying store. The key of an
+// entry is authenticated with its value, so values cannot be swapped
+// between keys. Values encrypted with an old key-encryption key are
+// re-encrypted with the current one when they are read.
+type EncryptedKeyValueStore[Type interface{}] struct {
+	store KeyValueStore[string, *EncryptedValue]
+	codec Codec[Type]
+	keys  *KeyEncryptionKeys
+}
+
+func NewEncryptedKeyValueStore[Type interface{}](store KeyValueStore[string, *EncryptedValue], codec Codec[Type], keys *KeyEncryptionKeys) *EncryptedKeyValueStore[Type] {
+	return &EncryptedKeyValueStore[Type]{
+		store: store,
+		codec: codec,
+		keys:  keys,
+	}
+}
+
+func (store *EncryptedKeyValueStore[Type]) Get(key string) (Type, error) {
+	var empty Type
+	encrypted, err := store.store.Get(key)
+	if err != nil {
+		return empty, err
+	}
+	plaintext, err := store.keys.Decrypt(encrypted, []byte(key))
+	if err != nil {
+		return empty, fmt.Errorf("decrypting value of key %s: %w", HashKey(key), err)
+	}
+	if encrypted.KeyID != store.keys.currentID {
+		store.reencrypt(key, encrypted, plaintext)
+	}
+	return store.codec.Decode(plaintext)
+}
+
+// reencrypt replaces a value encrypted with an old key-encryption key, unless
+// it has been changed in the meantime. Failing to re-encrypt is not an error,
+// as the value is re-encrypted with the next read.
+func (store *EncryptedKeyValueStore[Type]) reencrypt(key string, encrypted *EncryptedValue, plaintext []byte) {
+	reencrypted, err := store.keys.Encrypt(plaintext, []byte(key))
+	if err != nil {
+		return
+	}
+	store.store.CompareAndSet(key, encrypted, reencrypted)
+}
+
+func (store *EncryptedKeyValueStore[Type]) Set(key string, value Type) error {
+	encrypted, err := store.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return store.store.Set(key, encrypted)
+}
+
+func (store *EncryptedKeyValueStore[Type]) SetWithTTL(key string, value Type, ttl time.Duration) error {
+	encrypted, err := store.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return store.store.SetWithTTL(key, encrypted, ttl)
+}
+
+func (store *EncryptedKeyValueStore[Type]) encrypt(key string, value Type) (*EncryptedValue, error) {
+	plaintext, err := store.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return store.keys.Encrypt(plaintext, []byte(key))
+}
+
+func (store *EncryptedKeyValueStore[Type]) Delete(key string) error {
+	return store.store.Delete(key)
+}
+
+func (store *EncryptedKeyValueStore[Type]) GetAndDelete(key string) (Type, error) {
+	var empty Type
+	encrypted, err := store.store.GetAndDelete(key)
+	if err != nil {
+		return empty, err
+	}
+	plaintext, err := store.keys.Decrypt(encrypted, []byte(key))
+	if err != nil {
+		return empty, fmt.Errorf("decrypting value of key %s: %w", HashKey(key), err)
+	}
+	return store.codec.Decode(plaintext)
+}
+
+// CompareAndSet compares the encoded values, so the codec must encode equal
+// values to the same bytes. Since every encryption yields a different
+// ciphertext, the current ciphertext is compared against in the underlying
+// store.
+func (store *EncryptedKeyValueStore[Type]) CompareAndSet(key string, expected Type, value Type) (bool, error) {
+	current, err := store.store.Get(key)
+	if err != nil {
+		return false, nil
+	}
+	plaintext, err := store.keys.Decrypt(current, []byte(key))
+	if err != nil {
+		return false, fmt.Errorf("decrypting value of key %s: %w", HashKey(key), err)
+	}
+	expectedPlaintext, err := store.codec.Encode(expected)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(plaintext, expectedPlaintext) {
+		return false, nil
+	}
+	encrypted, err := store.encrypt(key, value)
+	if err != nil {
+		return false, err
+	}
+	return store.store.CompareAndSet(key, current, encrypted)
+}
+
+func (store *EncryptedKeyValueStore[Type]) SetIfAbsent(key string, value Type, ttl time.Duration) (bool, error) {
+	encrypted, err := store.encrypt(key, value)
+	if err != nil {
+		return false, err
+	}
+	return store.store.SetIfAbsent(key, encrypted, ttl)
+}
+
+func (store *EncryptedKeyValueStore[Type]) WithContext(ctx context.Context) KeyValueStore[string, Type] {
+	return &EncryptedKeyValueStore[Type]{
+		store: store.store.WithContext(ctx),
+		codec: store.codec,
+		keys:  store.keys,
+	}
+}
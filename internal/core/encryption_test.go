@@ -0,0 +1,146 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+func newTestKeys(t *testing.T, currentID string, ids ...string) *core.KeyEncryptionKeys {
+	keys := make(map[string][]byte)
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	kek, err := core.NewKeyEncryptionKeys(currentID, keys)
+	if err != nil {
+		t.Fatalf("NewKeyEncryptionKeys() error = %v", err)
+	}
+	return kek
+}
+
+func TestEncryptedKeyValueStore(t *testing.T) {
+	inner := core.NewInMemoryKeyValueStore[*core.EncryptedValue]()
+	store := core.NewEncryptedKeyValueStore[*Person](inner, core.JSONCodec[*Person]{}, newTestKeys(t, "1", "1"))
+
+	err := store.SetWithTTL("1", &Person{Name: "John"}, time.Hour)
+	if err != nil {
+		t.Errorf("SetWithTTL() error = %v", err)
+	}
+
+	encrypted, err := inner.Get("1")
+	if err != nil {
+		t.Fatalf("Get() from underlying store error = %v", err)
+	}
+	if bytes.Contains(encrypted.Ciphertext, []byte("John")) {
+		t.Errorf("underlying store contains plaintext %q", encrypted.Ciphertext)
+	}
+
+	value, err := store.Get("1")
+	if err != nil || value == nil || value.Name != "John" {
+		t.Errorf("Get() value = %v, error = %v, want %v", value, err, "John")
+	}
+
+	// values are bound to their key, which is not revealed by the error
+	inner.Set("secret code", encrypted)
+	_, err = store.Get("secret code")
+	if err == nil {
+		t.Errorf("Get() of value moved to other key error = nil, want error")
+	} else if strings.Contains(err.Error(), "secret code") {
+		t.Errorf("Get() error = %v, contains key", err)
+	}
+
+	swapped, err := store.CompareAndSet("1", &Person{Name: "Peter"}, &Person{Name: "Paul"})
+	if err != nil || swapped {
+		t.Errorf("CompareAndSet() with wrong expected value = %v, error = %v, want false", swapped, err)
+	}
+	swapped, err = store.CompareAndSet("1", &Person{Name: "John"}, &Person{Name: "Paul"})
+	if err != nil || !swapped {
+		t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+	}
+
+	value, err = store.GetAndDelete("1")
+	if err != nil || value == nil || value.Name != "Paul" {
+		t.Errorf("GetAndDelete() value = %v, error = %v, want %v", value, err, "Paul")
+	}
+	_, err = store.Get("1")
+	if err == nil {
+		t.Errorf("Get() after GetAndDelete() error = nil, want error")
+	}
+}
+
+func TestEncryptedKeyValueStoreKeyRotation(t *testing.T) {
+	inner := core.NewInMemoryKeyValueStore[*core.EncryptedValue]()
+	old := core.NewEncryptedKeyValueStore[*Person](inner, core.JSONCodec[*Person]{}, newTestKeys(t, "old", "old"))
+	err := old.Set("1", &Person{Name: "John"})
+	if err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+
+	rotated := core.NewEncryptedKeyValueStore[*Person](inner, core.JSONCodec[*Person]{}, newTestKeys(t, "new", "old", "new"))
+	value, err := rotated.Get("1")
+	if err != nil || value == nil || value.Name != "John" {
+		t.Errorf("Get() value = %v, error = %v, want %v", value, err, "John")
+	}
+
+	encrypted, _ := inner.Get("1")
+	if encrypted.KeyID != "new" {
+		t.Errorf("KeyID after Get() = %v, want %v", encrypted.KeyID, "new")
+	}
+
+	_, err = old.Get("1")
+	if err == nil {
+		t.Errorf("Get() with retired key error = nil, want error")
+	}
+}
+
+func TestLoadKeyEncryptionKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "Multiple keys",
+			content: "# current key first\nnew:" + key + "\n\nold:" + key + "\n",
+		},
+		{
+			name:    "No keys",
+			content: "# no keys\n",
+			wantErr: true,
+		},
+		{
+			name:    "Short key",
+			content: "1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: true,
+		},
+		{
+			name:    "Missing id",
+			content: key,
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate id",
+			content: "1:" + key + "\n1:" + key,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyfile")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := core.LoadKeyEncryptionKeys(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadKeyEncryptionKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
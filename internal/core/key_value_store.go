@@ -2,7 +2,13 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
@@ -21,8 +27,23 @@ type KeyValueStore[Key comparable, Type interface{}] interface {
 	Get(key Key) (Type, error)
 	// Set associates the given value with the given value.
 	Set(key Key, value Type) error
+	// SetWithTTL associates the given value with the given key until the
+	// ttl has passed. Expired values are treated as not found.
+	SetWithTTL(key Key, value Type, ttl time.Duration) error
 	// Delete removes the value associated with the given key.
 	Delete(key Key) error
+	// GetAndDelete returns the value associated with the given key and
+	// removes it atomically, so that only one caller receives the value.
+	GetAndDelete(key Key) (Type, error)
+	// CompareAndSet associates the given value with the given key only if
+	// the current value equals the expected one. The ttl of the entry is
+	// kept. It reports whether the value was set.
+	CompareAndSet(key Key, expected Type, value Type) (bool, error)
+	// SetIfAbsent associates the given value with the given key until the
+	// ttl has passed, but only if no value is associated with the key yet.
+	// It reports whether the value was set. Values with a ttl of zero or less
+	// would expire right away, so they are not stored, but reported as set.
+	SetIfAbsent(key Key, value Type, ttl time.Duration) (bool, error)
 
 	WithContext(ctx context.Context) KeyValueStore[Key, Type]
 }
@@ -44,12 +65,36 @@ func (store *contextKeyValueStore[Key, Type]) Set(key Key, value Type) error {
 	return store.store.Set(key, value)
 }
 
+func (store *contextKeyValueStore[Key, Type]) SetWithTTL(key Key, value Type, ttl time.Duration) error {
+	_, span := tracer.Start(store.ctx, "keyValueStore.SetWithTTL", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return store.store.SetWithTTL(key, value, ttl)
+}
+
 func (store *contextKeyValueStore[Key, Type]) Delete(key Key) error {
 	_, span := tracer.Start(store.ctx, "keyValueStore.Delete", trace.WithSpanKind(trace.SpanKindInternal))
 	defer span.End()
 	return store.store.Delete(key)
 }
 
+func (store *contextKeyValueStore[Key, Type]) GetAndDelete(key Key) (Type, error) {
+	_, span := tracer.Start(store.ctx, "keyValueStore.GetAndDelete", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return store.store.GetAndDelete(key)
+}
+
+func (store *contextKeyValueStore[Key, Type]) CompareAndSet(key Key, expected Type, value Type) (bool, error) {
+	_, span := tracer.Start(store.ctx, "keyValueStore.CompareAndSet", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return store.store.CompareAndSet(key, expected, value)
+}
+
+func (store *contextKeyValueStore[Key, Type]) SetIfAbsent(key Key, value Type, ttl time.Duration) (bool, error) {
+	_, span := tracer.Start(store.ctx, "keyValueStore.SetIfAbsent", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return store.store.SetIfAbsent(key, value, ttl)
+}
+
 func (store *contextKeyValueStore[Key, Type]) WithContext(ctx context.Context) KeyValueStore[Key, Type] {
 	return &contextKeyValueStore[Key, Type]{
 		ctx:   ctx,
@@ -57,37 +102,294 @@ func (store *contextKeyValueStore[Key, Type]) WithContext(ctx context.Context) K
 	}
 }
 
+type defaultTTLKeyValueStore[Key comparable, Type interface{}] struct {
+	store KeyValueStore[Key, Type]
+	ttl   time.Duration
+}
+
+// WithDefaultTTL returns a store that expires values set with Set after the
+// given ttl. Values set with SetWithTTL keep their own ttl.
+func WithDefaultTTL[Key comparable, Type interface{}](store KeyValueStore[Key, Type], ttl time.Duration) KeyValueStore[Key, Type] {
+	return &defaultTTLKeyValueStore[Key, Type]{
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) Get(key Key) (Type, error) {
+	return store.store.Get(key)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) Set(key Key, value Type) error {
+	return store.store.SetWithTTL(key, value, store.ttl)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) SetWithTTL(key Key, value Type, ttl time.Duration) error {
+	return store.store.SetWithTTL(key, value, ttl)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) Delete(key Key) error {
+	return store.store.Delete(key)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) GetAndDelete(key Key) (Type, error) {
+	return store.store.GetAndDelete(key)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) CompareAndSet(key Key, expected Type, value Type) (bool, error) {
+	return store.store.CompareAndSet(key, expected, value)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) SetIfAbsent(key Key, value Type, ttl time.Duration) (bool, error) {
+	return store.store.SetIfAbsent(key, value, ttl)
+}
+
+func (store *defaultTTLKeyValueStore[Key, Type]) WithContext(ctx context.Context) KeyValueStore[Key, Type] {
+	return &defaultTTLKeyValueStore[Key, Type]{
+		store: store.store.WithContext(ctx),
+		ttl:   store.ttl,
+	}
+}
+
+type hashedKeyValueStore[Type interface{}] struct {
+	store KeyValueStore[string, Type]
+	hash  func(key string) string
+}
+
+// WithHashedKeys returns a store that stores values by the hash of their
+// key, so that the backend does not contain usable codes or tokens.
+func WithHashedKeys[Type interface{}](store KeyValueStore[string, Type], hash func(key string) string) KeyValueStore[string, Type] {
+	return &hashedKeyValueStore[Type]{
+		store: store,
+		hash:  hash,
+	}
+}
+
+func (store *hashedKeyValueStore[Type]) Get(key string) (Type, error) {
+	return store.store.Get(store.hash(key))
+}
+
+func (store *hashedKeyValueStore[Type]) Set(key string, value Type) error {
+	return store.store.Set(store.hash(key), value)
+}
+
+func (store *hashedKeyValueStore[Type]) SetWithTTL(key string, value Type, ttl time.Duration) error {
+	return store.store.SetWithTTL(store.hash(key), value, ttl)
+}
+
+func (store *hashedKeyValueStore[Type]) Delete(key string) error {
+	return store.store.Delete(store.hash(key))
+}
+
+func (store *hashedKeyValueStore[Type]) GetAndDelete(key string) (Type, error) {
+	return store.store.GetAndDelete(store.hash(key))
+}
+
+func (store *hashedKeyValueStore[Type]) CompareAndSet(key string, expected Type, value Type) (bool, error) {
+	return store.store.CompareAndSet(store.hash(key), expected, value)
+}
+
+func (store *hashedKeyValueStore[Type]) SetIfAbsent(key string, value Type, ttl time.Duration) (bool, error) {
+	return store.store.SetIfAbsent(store.hash(key), value, ttl)
+}
+
+func (store *hashedKeyValueStore[Type]) WithContext(ctx context.Context) KeyValueStore[string, Type] {
+	return &hashedKeyValueStore[Type]{
+		store: store.store.WithContext(ctx),
+		hash:  store.hash,
+	}
+}
+
+// HashKey hashes a key with SHA-256. Unlike secret hashes it does not depend
+// on the configured peppers, so values remain found after a pepper rotation.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultEvictionInterval is the interval in which the in-memory store
+// removes expired entries.
+const DefaultEvictionInterval = time.Minute
+
+type inMemoryEntry[Type interface{}] struct {
+	value Type
+	// expiresAt is zero for entries without a ttl
+	expiresAt time.Time
+}
+
+func (e *inMemoryEntry[Type]) isExpired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// DefaultShardCount is the number of shards of the in-memory store. Keys
+// are distributed across shards, so that concurrent requests rarely contend
+// for the same lock.
+const DefaultShardCount = 32
+
+type inMemoryShard[Type interface{}] struct {
+	mutex   sync.RWMutex
+	storage map[string]*inMemoryEntry[Type]
+}
+
+// InMemoryKeyValueStore is a concurrency-safe KeyValueStore keeping its
+// values in memory. The keys are sharded across multiple lock-protected maps.
 type InMemoryKeyValueStore[Type interface{}] struct {
-	storage map[string]Type
+	shards   []*inMemoryShard[Type]
+	eviction sync.Once
+	closing  sync.Once
+	done     chan struct{}
 }
 
 func NewInMemoryKeyValueStore[Type interface{}]() *InMemoryKeyValueStore[Type] {
+	return NewShardedInMemoryKeyValueStore[Type](DefaultShardCount)
+}
+
+func NewShardedInMemoryKeyValueStore[Type interface{}](shardCount int) *InMemoryKeyValueStore[Type] {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	shards := make([]*inMemoryShard[Type], shardCount)
+	for i := range shards {
+		shards[i] = &inMemoryShard[Type]{storage: make(map[string]*inMemoryEntry[Type])}
+	}
 	return &InMemoryKeyValueStore[Type]{
-		storage: make(map[string]Type),
+		shards: shards,
+		done:   make(chan struct{}),
 	}
 }
 
+func (store *InMemoryKeyValueStore[Type]) shard(key string) *inMemoryShard[Type] {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return store.shards[hash.Sum32()%uint32(len(store.shards))]
+}
+
 func (store *InMemoryKeyValueStore[Type]) Get(key string) (Type, error) {
-	value, ok := store.storage[key]
-	if !ok {
-		return value, fmt.Errorf("key %s not found", key)
+	shard := store.shard(key)
+	shard.mutex.RLock()
+	entry, ok := shard.storage[key]
+	shard.mutex.RUnlock()
+	if !ok || entry.isExpired(time.Now()) {
+		var empty Type
+		return empty, fmt.Errorf("key not found")
 	}
-	return value, nil
+	return entry.value, nil
 }
 
 func (store *InMemoryKeyValueStore[Type]) Set(key string, value Type) error {
-	store.storage[key] = value
+	store.set(key, &inMemoryEntry[Type]{value: value})
 	return nil
 }
 
+// SetWithTTL stores the value until the ttl has passed. Expired entries are
+// evicted in the background; the eviction starts with the first entry that
+// has a ttl.
+func (store *InMemoryKeyValueStore[Type]) SetWithTTL(key string, value Type, ttl time.Duration) error {
+	store.eviction.Do(func() {
+		go store.evict(DefaultEvictionInterval)
+	})
+
+	store.set(key, &inMemoryEntry[Type]{value: value, expiresAt: time.Now().Add(ttl)})
+	return nil
+}
+
+func (store *InMemoryKeyValueStore[Type]) set(key string, entry *inMemoryEntry[Type]) {
+	shard := store.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	shard.storage[key] = entry
+}
+
 func (store *InMemoryKeyValueStore[Type]) Delete(key string) error {
-	delete(store.storage, key)
+	shard := store.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(shard.storage, key)
 	return nil
 }
 
+func (store *InMemoryKeyValueStore[Type]) GetAndDelete(key string) (Type, error) {
+	shard := store.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	entry, ok := shard.storage[key]
+	if !ok || entry.isExpired(time.Now()) {
+		var empty Type
+		return empty, fmt.Errorf("key not found")
+	}
+	delete(shard.storage, key)
+	return entry.value, nil
+}
+
+// CompareAndSet compares the values with reflect.DeepEqual.
+func (store *InMemoryKeyValueStore[Type]) CompareAndSet(key string, expected Type, value Type) (bool, error) {
+	shard := store.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	entry, ok := shard.storage[key]
+	if !ok || entry.isExpired(time.Now()) || !reflect.DeepEqual(entry.value, expected) {
+		return false, nil
+	}
+	shard.storage[key] = &inMemoryEntry[Type]{value: value, expiresAt: entry.expiresAt}
+	return true, nil
+}
+
+func (store *InMemoryKeyValueStore[Type]) SetIfAbsent(key string, value Type, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	store.eviction.Do(func() {
+		go store.evict(DefaultEvictionInterval)
+	})
+
+	shard := store.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	now := time.Now()
+	if entry, ok := shard.storage[key]; ok && !entry.isExpired(now) {
+		return false, nil
+	}
+	shard.storage[key] = &inMemoryEntry[Type]{value: value, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
 func (store *InMemoryKeyValueStore[Type]) WithContext(ctx context.Context) KeyValueStore[string, Type] {
 	return &contextKeyValueStore[string, Type]{
 		ctx:   ctx,
 		store: store,
 	}
 }
+
+// Close stops the background eviction of the store. It may be called more
+// than once.
+func (store *InMemoryKeyValueStore[Type]) Close() {
+	store.closing.Do(func() {
+		close(store.done)
+	})
+}
+
+func (store *InMemoryKeyValueStore[Type]) evict(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.done:
+			return
+		case now := <-ticker.C:
+			store.deleteExpired(now)
+		}
+	}
+}
+
+func (store *InMemoryKeyValueStore[Type]) deleteExpired(now time.Time) {
+	// shards are locked one after another to not block the whole store
+	for _, shard := range store.shards {
+		shard.mutex.Lock()
+		for key, entry := range shard.storage {
+			if entry.isExpired(now) {
+				delete(shard.storage, key)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
@@ -1,7 +1,11 @@
 package core_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Untanky/modern-auth/internal/core"
 )
@@ -55,3 +59,330 @@ func TestKeyValueStoreFullFlow(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyValueStoreTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		store core.KeyValueStore[string, *Person]
+	}{
+		{
+			name:  "In Memory Key Value Store",
+			store: core.NewInMemoryKeyValueStore[*Person](),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.store.SetWithTTL("short", &Person{Name: "John"}, 10*time.Millisecond)
+			if err != nil {
+				t.Errorf("SetWithTTL() error = %v", err)
+			}
+			err = tt.store.SetWithTTL("long", &Person{Name: "Peter"}, time.Hour)
+			if err != nil {
+				t.Errorf("SetWithTTL() error = %v", err)
+			}
+
+			value, err := tt.store.Get("short")
+			if err != nil || value == nil || value.Name != "John" {
+				t.Errorf("Get() value = %v, error = %v, want %v", value, err, "John")
+			}
+
+			time.Sleep(20 * time.Millisecond)
+
+			_, err = tt.store.Get("short")
+			if err == nil {
+				t.Errorf("Get() of expired key error = nil, want error")
+			}
+			value, err = tt.store.Get("long")
+			if err != nil || value == nil || value.Name != "Peter" {
+				t.Errorf("Get() value = %v, error = %v, want %v", value, err, "Peter")
+			}
+		})
+	}
+}
+
+func TestKeyValueStoreConcurrentAccess(t *testing.T) {
+	tests := []struct {
+		name  string
+		store core.KeyValueStore[string, *Person]
+	}{
+		{
+			name:  "In Memory Key Value Store",
+			store: core.NewInMemoryKeyValueStore[*Person](),
+		},
+		{
+			name:  "Single Shard In Memory Key Value Store",
+			store: core.NewShardedInMemoryKeyValueStore[*Person](1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const workers = 16
+			const operations = 500
+
+			var wg sync.WaitGroup
+			for worker := 0; worker < workers; worker++ {
+				wg.Add(1)
+				go func(worker int) {
+					defer wg.Done()
+					for i := 0; i < operations; i++ {
+						// half of the keys are shared between workers to provoke contention
+						key := fmt.Sprintf("%d", i%(operations/2))
+						if i%2 == 0 {
+							key = fmt.Sprintf("%d-%d", worker, i)
+						}
+
+						var err error
+						switch i % 4 {
+						case 0:
+							err = tt.store.Set(key, &Person{Name: key})
+						case 1:
+							err = tt.store.SetWithTTL(key, &Person{Name: key}, time.Millisecond)
+						case 2:
+							_, _ = tt.store.Get(key)
+						case 3:
+							err = tt.store.Delete(key)
+						}
+						if err != nil {
+							t.Errorf("operation on key %s failed: %v", key, err)
+						}
+					}
+				}(worker)
+			}
+			wg.Wait()
+
+			for worker := 0; worker < workers; worker++ {
+				key := fmt.Sprintf("%d-%d", worker, 0)
+				value, err := tt.store.Get(key)
+				if err != nil || value == nil || value.Name != key {
+					t.Errorf("Get(%s) value = %v, error = %v, want %v", key, value, err, key)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInMemoryKeyValueStoreParallel(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		shards int
+	}{
+		{name: "1 shard", shards: 1},
+		{name: "8 shards", shards: 8},
+		{name: "default shards", shards: core.DefaultShardCount},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			store := core.NewShardedInMemoryKeyValueStore[*Person](bm.shards)
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				store.Set(keys[i], &Person{Name: keys[i]})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					// mostly reads, as in the authorization flows
+					if i%10 == 0 {
+						store.Set(key, &Person{Name: key})
+					} else {
+						store.Get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestKeyValueStoreAtomicOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		store core.KeyValueStore[string, *Person]
+	}{
+		{
+			name:  "In Memory Key Value Store",
+			store: core.NewInMemoryKeyValueStore[*Person](),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			john := &Person{Name: "John"}
+			err := tt.store.SetWithTTL("1", john, time.Hour)
+			if err != nil {
+				t.Errorf("SetWithTTL() error = %v", err)
+			}
+
+			swapped, err := tt.store.CompareAndSet("1", &Person{Name: "Peter"}, &Person{Name: "Paul"})
+			if err != nil || swapped {
+				t.Errorf("CompareAndSet() with wrong expected value = %v, error = %v, want false", swapped, err)
+			}
+			swapped, err = tt.store.CompareAndSet("1", &Person{Name: "John"}, &Person{Name: "Paul"})
+			if err != nil || !swapped {
+				t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+			}
+			swapped, err = tt.store.CompareAndSet("2", &Person{Name: "John"}, &Person{Name: "Paul"})
+			if err != nil || swapped {
+				t.Errorf("CompareAndSet() on missing key = %v, error = %v, want false", swapped, err)
+			}
+
+			value, err := tt.store.GetAndDelete("1")
+			if err != nil || value == nil || value.Name != "Paul" {
+				t.Errorf("GetAndDelete() value = %v, error = %v, want %v", value, err, "Paul")
+			}
+			_, err = tt.store.GetAndDelete("1")
+			if err == nil {
+				t.Errorf("second GetAndDelete() error = nil, want error")
+			}
+			_, err = tt.store.Get("1")
+			if err == nil {
+				t.Errorf("Get() after GetAndDelete() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestKeyValueStoreConcurrentGetAndDelete(t *testing.T) {
+	store := core.NewInMemoryKeyValueStore[*Person]()
+	const workers = 16
+	const keys = 200
+
+	for i := 0; i < keys; i++ {
+		store.Set(fmt.Sprintf("%d", i), &Person{Name: "John"})
+	}
+
+	// every key must be received by exactly one worker
+	var received [workers]int
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				if _, err := store.GetAndDelete(fmt.Sprintf("%d", i)); err == nil {
+					received[worker]++
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, count := range received {
+		total += count
+	}
+	if total != keys {
+		t.Errorf("GetAndDelete() returned %d values, want %d", total, keys)
+	}
+}
+
+func TestKeyValueStoreSetIfAbsent(t *testing.T) {
+	store := core.NewInMemoryKeyValueStore[*Person]()
+	defer store.Close()
+
+	set, err := store.SetIfAbsent("1", &Person{Name: "John"}, time.Hour)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() = %v, error = %v, want true", set, err)
+	}
+	set, err = store.SetIfAbsent("1", &Person{Name: "Paul"}, time.Hour)
+	if err != nil || set {
+		t.Errorf("SetIfAbsent() on existing key = %v, error = %v, want false", set, err)
+	}
+	if value, _ := store.Get("1"); value == nil || value.Name != "John" {
+		t.Errorf("Get() = %v, want John", value)
+	}
+
+	store.SetWithTTL("2", &Person{Name: "John"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	set, err = store.SetIfAbsent("2", &Person{Name: "Paul"}, time.Hour)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() on expired key = %v, error = %v, want true", set, err)
+	}
+
+	set, err = store.SetIfAbsent("3", &Person{Name: "John"}, -time.Second)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() with negative ttl = %v, error = %v, want true", set, err)
+	}
+	if _, err := store.Get("3"); err == nil {
+		t.Errorf("value with negative ttl stored")
+	}
+}
+
+func TestKeyValueStoreConcurrentSetIfAbsent(t *testing.T) {
+	store := core.NewInMemoryKeyValueStore[*Person]()
+	defer store.Close()
+	const workers = 16
+
+	// only one worker may set the key
+	var set [workers]bool
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			set[worker], _ = store.SetIfAbsent("1", &Person{Name: "John"}, time.Hour)
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, ok := range set {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("SetIfAbsent() succeeded %d times, want 1", count)
+	}
+}
+
+func TestInMemoryKeyValueStoreClose(t *testing.T) {
+	store := core.NewInMemoryKeyValueStore[*Person]()
+	store.SetWithTTL("short", &Person{Name: "John"}, time.Millisecond)
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("Close() panicked: %v", r)
+		}
+	}()
+	store.Close()
+	store.Close()
+
+	// the store remains usable without the background eviction
+	store.Set("1", &Person{Name: "Paul"})
+	if value, err := store.Get("1"); err != nil || value.Name != "Paul" {
+		t.Errorf("Get() after Close() value = %v, error = %v", value, err)
+	}
+}
+
+func TestWithHashedKeys(t *testing.T) {
+	backend := core.NewInMemoryKeyValueStore[*Person]()
+	defer backend.Close()
+	store := core.WithHashedKeys[*Person](backend, core.HashKey)
+
+	store.Set("code", &Person{Name: "John"})
+	if value, err := store.Get("code"); err != nil || value.Name != "John" {
+		t.Errorf("Get() value = %v, error = %v, want John", value, err)
+	}
+	if _, err := backend.Get("code"); err == nil {
+		t.Errorf("backend stores the key unhashed")
+	}
+	if value, err := backend.Get(core.HashKey("code")); err != nil || value.Name != "John" {
+		t.Errorf("backend Get() of hashed key value = %v, error = %v", value, err)
+	}
+
+	if set, _ := store.SetIfAbsent("code", &Person{Name: "Paul"}, time.Hour); set {
+		t.Errorf("SetIfAbsent() on existing key = true")
+	}
+	if swapped, _ := store.CompareAndSet("code", &Person{Name: "John"}, &Person{Name: "Paul"}); !swapped {
+		t.Errorf("CompareAndSet() = false")
+	}
+	if value, err := store.WithContext(context.Background()).GetAndDelete("code"); err != nil || value.Name != "Paul" {
+		t.Errorf("GetAndDelete() value = %v, error = %v, want Paul", value, err)
+	}
+	if _, err := backend.Get(core.HashKey("code")); err == nil {
+		t.Errorf("value left in backend after GetAndDelete()")
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readKeyfile reads keys by their id from a keyfile. Each line holds the id
+// of a key and the base64 encoded key separated by a colon. Empty lines and
+// lines starting with # are ignored. The id of the first key is returned as
+// the current id.
+func readKeyfile(path string) (string, map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	var currentID string
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, found := strings.Cut(line, ":")
+		if !found || id == "" {
+			return "", nil, fmt.Errorf("invalid line in keyfile %s", path)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid key %s in keyfile %s: %w", id, path, err)
+		}
+		if _, ok := keys[id]; ok {
+			return "", nil, fmt.Errorf("duplicate key %s in keyfile %s", id, path)
+		}
+		if currentID == "" {
+			currentID = id
+		}
+		keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if currentID == "" {
+		return "", nil, fmt.Errorf("keyfile %s contains no keys", path)
+	}
+	return currentID, keys, nil
+}
@@ -1,12 +1,161 @@
 package core
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
 
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A List is an ordered collection of values of a given type.
 type List[Type interface{}] interface {
+	// Index returns the position of the first occurrence of the given value.
 	Index(Type) (int64, error)
+	// Len returns the number of values in the list.
 	Len() int64
+	// Append adds the value to the end of the list and returns its position.
 	Append(Type) (int64, error)
+	// Remove removes the value at the given position.
 	Remove(int64) error
+	// RemoveValue removes the first occurrence of the given value. Unlike
+	// looking up the position with Index and removing it with Remove, it
+	// cannot remove another value if the list changes in between.
+	RemoveValue(Type) error
 	WithContext(ctx context.Context) List[Type]
+	// Slice returns a copy of the values in the list.
 	Slice() []Type
 }
+
+// A ListFactory creates the list stored under the given name.
+type ListFactory[Type interface{}] func(name string) List[Type]
+
+type contextList[Type interface{}] struct {
+	ctx  context.Context
+	list List[Type]
+}
+
+func (list *contextList[Type]) Index(value Type) (int64, error) {
+	_, span := tracer.Start(list.ctx, "list.Index", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.Index(value)
+}
+
+func (list *contextList[Type]) Len() int64 {
+	_, span := tracer.Start(list.ctx, "list.Len", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.Len()
+}
+
+func (list *contextList[Type]) Append(value Type) (int64, error) {
+	_, span := tracer.Start(list.ctx, "list.Append", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.Append(value)
+}
+
+func (list *contextList[Type]) Remove(index int64) error {
+	_, span := tracer.Start(list.ctx, "list.Remove", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.Remove(index)
+}
+
+func (list *contextList[Type]) RemoveValue(value Type) error {
+	_, span := tracer.Start(list.ctx, "list.RemoveValue", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.RemoveValue(value)
+}
+
+func (list *contextList[Type]) WithContext(ctx context.Context) List[Type] {
+	return &contextList[Type]{
+		ctx:  ctx,
+		list: list.list,
+	}
+}
+
+func (list *contextList[Type]) Slice() []Type {
+	_, span := tracer.Start(list.ctx, "list.Slice", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	return list.list.Slice()
+}
+
+// InMemoryList is a concurrency-safe List keeping its values in memory.
+type InMemoryList[Type interface{}] struct {
+	mutex  sync.RWMutex
+	values []Type
+}
+
+func NewInMemoryList[Type interface{}]() *InMemoryList[Type] {
+	return &InMemoryList[Type]{}
+}
+
+// NewInMemoryListFactory returns a ListFactory creating empty in-memory
+// lists.
+func NewInMemoryListFactory[Type interface{}]() ListFactory[Type] {
+	return func(name string) List[Type] {
+		return NewInMemoryList[Type]()
+	}
+}
+
+// Index compares the values with reflect.DeepEqual.
+func (list *InMemoryList[Type]) Index(value Type) (int64, error) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	for i, v := range list.values {
+		if reflect.DeepEqual(v, value) {
+			return int64(i), nil
+		}
+	}
+	return -1, fmt.Errorf("value %v not found", value)
+}
+
+func (list *InMemoryList[Type]) Len() int64 {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return int64(len(list.values))
+}
+
+func (list *InMemoryList[Type]) Append(value Type) (int64, error) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.values = append(list.values, value)
+	return int64(len(list.values) - 1), nil
+}
+
+func (list *InMemoryList[Type]) Remove(index int64) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	if index < 0 || index >= int64(len(list.values)) {
+		return fmt.Errorf("index %d out of range", index)
+	}
+	list.values = append(list.values[:index], list.values[index+1:]...)
+	return nil
+}
+
+// RemoveValue compares the values with reflect.DeepEqual.
+func (list *InMemoryList[Type]) RemoveValue(value Type) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	for i, v := range list.values {
+		if reflect.DeepEqual(v, value) {
+			list.values = append(list.values[:i], list.values[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("value %v not found", value)
+}
+
+func (list *InMemoryList[Type]) WithContext(ctx context.Context) List[Type] {
+	return &contextList[Type]{
+		ctx:  ctx,
+		list: list,
+	}
+}
+
+func (list *InMemoryList[Type]) Slice() []Type {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	values := make([]Type, len(list.values))
+	copy(values, list.values)
+	return values
+}
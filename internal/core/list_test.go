@@ -0,0 +1,104 @@
+package core_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+func TestInMemoryList(t *testing.T) {
+	list := core.NewInMemoryList[string]()
+
+	for i, value := range []string{"a", "b", "c"} {
+		index, err := list.Append(value)
+		if err != nil || index != int64(i) {
+			t.Errorf("Append() = %v, error = %v, want %v", index, err, i)
+		}
+	}
+
+	index, err := list.Index("b")
+	if err != nil || index != 1 {
+		t.Errorf("Index() = %v, error = %v, want %v", index, err, 1)
+	}
+	_, err = list.Index("d")
+	if err == nil {
+		t.Errorf("Index() of missing value error = nil, want error")
+	}
+
+	err = list.Remove(index)
+	if err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	err = list.Remove(2)
+	if err == nil {
+		t.Errorf("Remove() out of range error = nil, want error")
+	}
+	err = list.Remove(-1)
+	if err == nil {
+		t.Errorf("Remove() with negative index error = nil, want error")
+	}
+
+	list.Append("b")
+	err = list.RemoveValue("b")
+	if err != nil {
+		t.Errorf("RemoveValue() error = %v", err)
+	}
+	err = list.RemoveValue("b")
+	if err == nil {
+		t.Errorf("RemoveValue() of missing value error = nil, want error")
+	}
+
+	if got := list.Slice(); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Slice() = %v, want %v", got, []string{"a", "c"})
+	}
+	if got := list.Len(); got != 2 {
+		t.Errorf("Len() = %v, want %v", got, 2)
+	}
+}
+
+func TestInMemoryListConcurrentAccess(t *testing.T) {
+	list := core.NewInMemoryList[string]()
+	const workers = 16
+	const values = 100
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < values; i++ {
+				value := fmt.Sprintf("%d-%d", worker, i)
+				list.Append(value)
+				list.Slice()
+				if _, err := list.Index(value); err != nil {
+					t.Errorf("Index() error = %v", err)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if got := list.Len(); got != workers*values {
+		t.Errorf("Len() = %v, want %v", got, workers*values)
+	}
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < values; i++ {
+				if err := list.Remove(0); err != nil {
+					t.Errorf("Remove() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := list.Len(); got != 0 {
+		t.Errorf("Len() = %v, want %v", got, 0)
+	}
+}
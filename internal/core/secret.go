@@ -1,25 +1,157 @@
 package core
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
+	"strings"
 
 	"github.com/Untanky/modern-auth/internal/utils"
 )
 
+// HMACSHA256SecretAlgorithm is the algorithm of keyed secret hashes, which are
+// prefixed with "$hmac-sha256$<pepper id>$". Unprefixed hashes are unkeyed
+// SHAKE256 hashes, which are only produced if no pepper is configured.
+const HMACSHA256SecretAlgorithm = "hmac-sha256"
+
+// MinPepperSize is the minimum size of peppers, which is the output size of
+// SHA-256, so that the pepper cannot be guessed more easily than a hash.
+const MinPepperSize = 32
+
+// A SecretHasher hashes secrets with a server-side pepper, so that the hashes
+// cannot be checked offline without the pepper. The pepper used is part of
+// the hash, which allows to verify hashes created with previous peppers.
+type SecretHasher struct {
+	currentID string
+	peppers   map[string][]byte
+}
+
+// NewSecretHasher creates a hasher from the peppers by their id. New hashes
+// are created with the current pepper. Without peppers, the hasher creates
+// unkeyed hashes.
+func NewSecretHasher(currentID string, peppers map[string][]byte) (*SecretHasher, error) {
+	for id, pepper := range peppers {
+		if strings.Contains(id, "$") {
+			return nil, fmt.Errorf("pepper id %s must not contain '$'", id)
+		}
+		if len(pepper) < MinPepperSize {
+			return nil, fmt.Errorf("pepper %s must be at least %d bytes", id, MinPepperSize)
+		}
+	}
+	if _, ok := peppers[currentID]; len(peppers) > 0 && !ok {
+		return nil, fmt.Errorf("current pepper %s not found", currentID)
+	}
+	return &SecretHasher{currentID: currentID, peppers: peppers}, nil
+}
+
+// LoadSecretHasher reads the peppers from a keyfile. The first pepper is the
+// current one. To rotate, add a new pepper at the top and keep the old
+// peppers as long as hashes created with them are in use.
+func LoadSecretHasher(path string) (*SecretHasher, error) {
+	currentID, peppers, err := readKeyfile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretHasher(currentID, peppers)
+}
+
+// unkeyedSecretHasher creates unkeyed hashes for SecretValues created
+// without a hasher.
+var unkeyedSecretHasher = &SecretHasher{}
+
+// Secret wraps the value, so that it is hashed with the hasher.
+func (h *SecretHasher) Secret(value string) *SecretValue {
+	return &SecretValue{value: []byte(value), hasher: h}
+}
+
+func (h *SecretHasher) hash(value []byte) string {
+	if len(h.peppers) == 0 {
+		return legacySecretHash(value)
+	}
+	return h.keyedHash(h.currentID, value)
+}
+
+func (h *SecretHasher) keyedHash(pepperID string, value []byte) string {
+	mac := hmac.New(sha256.New, h.peppers[pepperID])
+	mac.Write(value)
+	return "$" + HMACSHA256SecretAlgorithm + "$" + pepperID + "$" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func legacySecretHash(value []byte) string {
+	return base64.StdEncoding.EncodeToString(utils.HashShake256(value))
+}
+
+// hashFor recreates the hash in the version of the given hash.
+func (h *SecretHasher) hashFor(value []byte, hash string) (string, bool) {
+	if !strings.HasPrefix(hash, "$") {
+		return legacySecretHash(value), true
+	}
+	parts := strings.SplitN(hash, "$", 4)
+	if len(parts) != 4 || parts[1] != HMACSHA256SecretAlgorithm {
+		return "", false
+	}
+	if _, ok := h.peppers[parts[2]]; !ok {
+		return "", false
+	}
+	return h.keyedHash(parts[2], value), true
+}
+
+// hashes returns the hash in every version the hasher can verify, the current
+// version first.
+func (h *SecretHasher) hashes(value []byte) []string {
+	hashes := []string{h.hash(value)}
+	for id := range h.peppers {
+		if id != h.currentID {
+			hashes = append(hashes, h.keyedHash(id, value))
+		}
+	}
+	if len(h.peppers) > 0 {
+		hashes = append(hashes, legacySecretHash(value))
+	}
+	return hashes
+}
+
 type SecretValue struct {
-	value []byte
+	value  []byte
+	hasher *SecretHasher
 }
 
+// NewSecretValue wraps the value, so that it is hashed without a pepper. Use
+// SecretHasher.Secret to hash it with the configured peppers.
 func NewSecretValue(value string) *SecretValue {
-	return &SecretValue{value: []byte(value)}
+	return unkeyedSecretHasher.Secret(value)
 }
 
 func (s *SecretValue) MarshalJSON() ([]byte, error) {
 	return []byte("\"" + string(s.String()) + "\""), nil
 }
 
+// String returns the hash of the secret in the current version.
 func (s *SecretValue) String() string {
-	hash := utils.HashShake256(s.value)
-	base64Encoded := base64.StdEncoding.EncodeToString(hash)
-	return base64Encoded
+	return s.hasher.hash(s.value)
+}
+
+// Verify reports whether the hash is a hash of the secret in any version
+// known to the hasher.
+func (s *SecretValue) Verify(hash string) bool {
+	expected, ok := s.hasher.hashFor(s.value, hash)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+// NeedsRehash reports whether the hash was not created in the current
+// version and should be replaced after verifying it.
+func (s *SecretValue) NeedsRehash(hash string) bool {
+	return s.String() != hash
+}
+
+// Hashes returns the hash of the secret in every version known to the
+// hasher, the current version first. It is used to look up values stored
+// by the hash of a secret, which may have been stored with an older version.
+func (s *SecretValue) Hashes() []string {
+	return s.hasher.hashes(s.value)
 }
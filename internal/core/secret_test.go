@@ -3,6 +3,7 @@ package core_test
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/Untanky/modern-auth/internal/core"
@@ -52,3 +53,139 @@ func TestSecretMarshalling(t *testing.T) {
 		})
 	}
 }
+
+func newSecretHasher(t *testing.T, currentID string, peppers map[string][]byte) *core.SecretHasher {
+	hasher, err := core.NewSecretHasher(currentID, peppers)
+	if err != nil {
+		t.Fatalf("NewSecretHasher() error = %v", err)
+	}
+	return hasher
+}
+
+var (
+	firstPepper  = []byte("first pepper of at least 32 bytes")
+	secondPepper = []byte("second pepper of at least 32 bytes")
+)
+
+func TestSecretHashing(t *testing.T) {
+	legacyHash := core.NewSecretValue("secret").String()
+
+	first := newSecretHasher(t, "1", map[string][]byte{"1": firstPepper})
+	firstHash := first.Secret("secret").String()
+	if !strings.HasPrefix(firstHash, "$hmac-sha256$1$") {
+		t.Errorf("String() = %v, want prefix %v", firstHash, "$hmac-sha256$1$")
+	}
+
+	hasher := newSecretHasher(t, "2", map[string][]byte{"1": firstPepper, "2": secondPepper})
+	secondHash := hasher.Secret("secret").String()
+
+	tests := []struct {
+		name       string
+		secret     string
+		hash       string
+		wantVerify bool
+		wantRehash bool
+	}{
+		{
+			name:       "Current version",
+			secret:     "secret",
+			hash:       secondHash,
+			wantVerify: true,
+		},
+		{
+			name:       "Previous pepper",
+			secret:     "secret",
+			hash:       firstHash,
+			wantVerify: true,
+			wantRehash: true,
+		},
+		{
+			name:       "Unkeyed hash",
+			secret:     "secret",
+			hash:       legacyHash,
+			wantVerify: true,
+			wantRehash: true,
+		},
+		{
+			name:       "Wrong secret",
+			secret:     "other",
+			hash:       secondHash,
+			wantVerify: false,
+			wantRehash: true,
+		},
+		{
+			name:       "Unknown pepper",
+			secret:     "secret",
+			hash:       strings.Replace(secondHash, "$2$", "$3$", 1),
+			wantVerify: false,
+			wantRehash: true,
+		},
+		{
+			name:       "Unknown algorithm",
+			secret:     "secret",
+			hash:       strings.Replace(secondHash, "hmac-sha256", "md5", 1),
+			wantVerify: false,
+			wantRehash: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := hasher.Secret(tt.secret)
+			if got := secret.Verify(tt.hash); got != tt.wantVerify {
+				t.Errorf("Verify() = %v, want %v", got, tt.wantVerify)
+			}
+			if got := secret.NeedsRehash(tt.hash); got != tt.wantRehash {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.wantRehash)
+			}
+		})
+	}
+
+	hashes := hasher.Secret("secret").Hashes()
+	if len(hashes) != 3 || hashes[0] != secondHash {
+		t.Errorf("Hashes() = %v, want current hash %v first and 3 hashes", hashes, secondHash)
+	}
+}
+
+func TestNewSecretHasher(t *testing.T) {
+	tests := []struct {
+		name      string
+		currentID string
+		peppers   map[string][]byte
+		wantErr   bool
+	}{
+		{
+			name: "Without peppers",
+		},
+		{
+			name:      "With peppers",
+			currentID: "2",
+			peppers:   map[string][]byte{"1": firstPepper, "2": secondPepper},
+		},
+		{
+			name:      "Current pepper missing",
+			currentID: "3",
+			peppers:   map[string][]byte{"1": firstPepper},
+			wantErr:   true,
+		},
+		{
+			name:      "Invalid pepper id",
+			currentID: "$1",
+			peppers:   map[string][]byte{"$1": firstPepper},
+			wantErr:   true,
+		},
+		{
+			name:      "Short pepper",
+			currentID: "2",
+			peppers:   map[string][]byte{"1": firstPepper, "2": []byte("short pepper")},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := core.NewSecretHasher(tt.currentID, tt.peppers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSecretHasher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"hash/crc32"
+	"math"
+	"strings"
+
+	"github.com/Untanky/modern-auth/internal/utils"
+)
+
+const tokenAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// tokenChecksumLength is the number of base62 characters that fit a CRC32.
+const tokenChecksumLength = 6
+
+// A TokenGenerator creates random tokens from a cryptographically secure
+// source. Tokens are made of base62 characters and may start with a prefix,
+// which makes them identifiable, and end with a CRC32 checksum, which allows
+// secret scanners to recognise leaked tokens without false positives.
+type TokenGenerator struct {
+	prefix   string
+	length   int
+	checksum bool
+}
+
+// NewTokenGenerator creates a generator of tokens with at least the given
+// entropy in bits. The prefix is separated from the random part by an
+// underscore; it is omitted if empty.
+func NewTokenGenerator(prefix string, entropy int, checksum bool) *TokenGenerator {
+	if prefix != "" {
+		prefix += "_"
+	}
+	return &TokenGenerator{
+		prefix:   prefix,
+		length:   int(math.Ceil(float64(entropy) / math.Log2(float64(len(tokenAlphabet))))),
+		checksum: checksum,
+	}
+}
+
+func (g *TokenGenerator) Generate() string {
+	token := g.prefix + utils.RandomStringFromAlphabet(tokenAlphabet, g.length)
+	if g.checksum {
+		token += tokenChecksum(token)
+	}
+	return token
+}
+
+// IsWellFormed reports whether the token could have been generated by the
+// generator. It allows to reject made up tokens before looking them up.
+func (g *TokenGenerator) IsWellFormed(token string) bool {
+	length := len(g.prefix) + g.length
+	if g.checksum {
+		length += tokenChecksumLength
+	}
+	if len(token) != length || !strings.HasPrefix(token, g.prefix) {
+		return false
+	}
+	if !g.checksum {
+		return true
+	}
+	return tokenChecksum(token[:len(token)-tokenChecksumLength]) == token[len(token)-tokenChecksumLength:]
+}
+
+// HasPrefix reports whether the token starts with the prefix of the
+// generator. Tokens issued before the generator was introduced do not, so
+// only tokens with the prefix can be required to be well formed.
+func (g *TokenGenerator) HasPrefix(token string) bool {
+	return g.prefix != "" && strings.HasPrefix(token, g.prefix)
+}
+
+func tokenChecksum(token string) string {
+	sum := crc32.ChecksumIEEE([]byte(token))
+	checksum := make([]byte, tokenChecksumLength)
+	for i := tokenChecksumLength - 1; i >= 0; i-- {
+		checksum[i] = tokenAlphabet[sum%uint32(len(tokenAlphabet))]
+		sum /= uint32(len(tokenAlphabet))
+	}
+	return string(checksum)
+}
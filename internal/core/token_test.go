@@ -0,0 +1,80 @@
+package core_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/core"
+)
+
+func TestTokenGenerator(t *testing.T) {
+	tests := []struct {
+		name       string
+		generator  *core.TokenGenerator
+		wantPrefix string
+		wantLength int
+	}{
+		{
+			name:       "Prefix and checksum",
+			generator:  core.NewTokenGenerator("mat", 256, true),
+			wantPrefix: "mat_",
+			wantLength: 4 + 43 + 6,
+		},
+		{
+			name:       "Plain",
+			generator:  core.NewTokenGenerator("", 128, false),
+			wantLength: 22,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.generator.Generate()
+			if !strings.HasPrefix(token, tt.wantPrefix) || len(token) != tt.wantLength {
+				t.Errorf("Generate() = %v, want prefix %q and length %d", token, tt.wantPrefix, tt.wantLength)
+			}
+			if !tt.generator.IsWellFormed(token) {
+				t.Errorf("IsWellFormed(%v) = false, want true", token)
+			}
+			if tt.generator.IsWellFormed(token[1:]) {
+				t.Errorf("IsWellFormed() of truncated token = true, want false")
+			}
+			if other := tt.generator.Generate(); other == token {
+				t.Errorf("Generate() returned %v twice", token)
+			}
+		})
+	}
+}
+
+func TestTokenGeneratorChecksum(t *testing.T) {
+	generator := core.NewTokenGenerator("mrt", 256, true)
+	token := generator.Generate()
+
+	// changing any character of the random part invalidates the checksum
+	for i := len("mrt_"); i < len(token); i++ {
+		replacement := byte('a')
+		if token[i] == replacement {
+			replacement = 'b'
+		}
+		tampered := token[:i] + string(replacement) + token[i+1:]
+		if generator.IsWellFormed(tampered) {
+			t.Errorf("IsWellFormed(%v) = true, want false", tampered)
+		}
+	}
+
+	if core.NewTokenGenerator("mat", 256, true).IsWellFormed(token) {
+		t.Errorf("IsWellFormed() with other prefix = true, want false")
+	}
+}
+
+func TestTokenGeneratorHasPrefix(t *testing.T) {
+	generator := core.NewTokenGenerator("mrt", 256, true)
+	if !generator.HasPrefix(generator.Generate()) {
+		t.Errorf("HasPrefix() of generated token = false, want true")
+	}
+	if generator.HasPrefix("mat_abc") || generator.HasPrefix("mrtabc") {
+		t.Errorf("HasPrefix() of token with other prefix = true, want false")
+	}
+	if core.NewTokenGenerator("", 256, true).HasPrefix("abc") {
+		t.Errorf("HasPrefix() without prefix = true, want false")
+	}
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/google/uuid"
+)
+
+// Authentication is handed from the authenticator to the authorization flow
+// once a user has successfully authenticated. It is stored under the
+// authorization id and can only be claimed with the matching verifier.
+type Authentication struct {
+	VerifierHash    []byte
+	SubjectID       uuid.UUID
+	AuthenticatedAt time.Time
+	// Methods are the authentication method references (RFC 8176)
+	Methods []string
+	// ContextClass is the authentication context class reference
+	ContextClass string
+}
+
+type AuthenticationStore = core.KeyValueStore[string, *Authentication]
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"errors"
 
 	"github.com/Untanky/modern-auth/internal/core"
 	"github.com/google/uuid"
@@ -11,14 +12,43 @@ type CredentialRepository interface {
 	core.Repository[string, *Credential]
 	FindByCredentialId(ctx context.Context, credentialId []byte) (*Credential, error)
 	FindByUserID(ctx context.Context, userID uuid.UUID) ([]*Credential, error)
+	// UpdateSignCount stores the sign count and backup flags of the
+	// credential, if the stored sign count is lower. It reports whether the
+	// credential was updated.
+	UpdateSignCount(ctx context.Context, credential *Credential) (bool, error)
 }
 
+// ErrSignCountNotIncreased is returned if the sign count of a credential did
+// not increase over the stored one, e.g. because a concurrent ceremony
+// stored a higher count first.
+var ErrSignCountNotIncreased = errors.New("sign count did not increase")
+
+const (
+	CredentialStatusActive = "active"
+	// CredentialStatusPossiblyCloned marks credentials whose sign count went
+	// backwards, which indicates that the authenticator was cloned.
+	CredentialStatusPossiblyCloned = "possibly_cloned"
+)
+
 type Credential struct {
 	ID           uuid.UUID
 	CredentialID []byte
 	Status       string
 	PublicKey    []byte
 	User         *User
+	// SignCount is the signature counter of the authenticator at the last
+	// ceremony. Authenticators without a counter always report 0.
+	SignCount uint32
+	// BackupEligible is nil for credentials registered before the flag was
+	// stored. It is recorded at the next ceremony.
+	BackupEligible *bool
+	BackupState    bool
+	// AttestationType describes how the authenticator attested the
+	// credential at registration, e.g. "self" or "basic".
+	AttestationType string
+	// AAGUID identifies the authenticator model. It is zero if the
+	// authenticator does not disclose its model.
+	AAGUID uuid.UUID
 }
 
 type CredentialService struct {
@@ -45,11 +75,29 @@ func (s *CredentialService) GetCredentialsByUserID(ctx context.Context, userId u
 
 func (s *CredentialService) CreateCredential(ctx context.Context, credential *Credential) error {
 	credential.ID = uuid.New()
-	credential.Status = "active"
+	credential.Status = CredentialStatusActive
 
 	return s.repo.Save(ctx, credential)
 }
 
+func (s *CredentialService) UpdateCredential(ctx context.Context, credential *Credential) error {
+	return s.repo.Update(ctx, credential)
+}
+
+// UpdateSignCount stores the sign count and backup flags after a ceremony.
+// The check and the update are atomic, so concurrent ceremonies cannot both
+// succeed with the same sign count.
+func (s *CredentialService) UpdateSignCount(ctx context.Context, credential *Credential) error {
+	updated, err := s.repo.UpdateSignCount(ctx, credential)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrSignCountNotIncreased
+	}
+	return nil
+}
+
 func (s *CredentialService) DeleteById(ctx context.Context, credentialId string) error {
 	return s.repo.DeleteById(ctx, credentialId)
 }
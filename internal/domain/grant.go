@@ -10,17 +10,22 @@ import (
 	"github.com/google/uuid"
 )
 
-type GrantRepo = core.Repository[uuid.UUID, *grant]
-type GrantStore = core.KeyValueStore[string, *grant]
+type GrantRepo = core.Repository[uuid.UUID, *Grant]
+type GrantStore = core.KeyValueStore[string, *Grant]
 
 var grantRepo GrantRepo
 var grantStore GrantStore
 
 func init() {
-	grantStore = core.NewInMemoryKeyValueStore[*grant]()
+	grantStore = core.NewInMemoryKeyValueStore[*Grant]()
 }
 
-type grant struct {
+// UseGrantRepo configures the repository grants are persisted in.
+func UseGrantRepo(repo GrantRepo) {
+	grantRepo = repo
+}
+
+type Grant struct {
 	ID                uuid.UUID
 	SubjectID         uuid.UUID
 	ClientID          string
@@ -31,8 +36,8 @@ type grant struct {
 	AllowRefreshToken bool
 }
 
-func NewGrant(subjectID uuid.UUID) *grant {
-	return &grant{
+func NewGrant(subjectID uuid.UUID) *Grant {
+	return &Grant{
 		ID:       uuid.New(),
 		IssuedAt: time.Now(),
 	}
@@ -63,7 +68,10 @@ func (token *RefreshToken) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("\"%s\"", utils.EncodeBase64(token[:]))), nil
 }
 
-func FindGrantByGrantID(grantID uuid.UUID) (*grant, error) {
+func FindGrantByGrantID(ctx context.Context, grantID uuid.UUID) (*Grant, error) {
+	if grantRepo != nil {
+		return grantRepo.FindById(ctx, grantID)
+	}
 	g, err := grantStore.Get(grantID.String())
 	if err != nil {
 		return nil, err
@@ -71,7 +79,7 @@ func FindGrantByGrantID(grantID uuid.UUID) (*grant, error) {
 	return g, nil
 }
 
-func FindGrantByToken(ctx context.Context, accessToken Token) (*grant, error) {
+func FindGrantByToken(ctx context.Context, accessToken Token) (*Grant, error) {
 	g, err := grantStore.Get(accessToken.Key())
 	if err != nil {
 		return nil, err
@@ -79,11 +87,13 @@ func FindGrantByToken(ctx context.Context, accessToken Token) (*grant, error) {
 	return g, nil
 }
 
-func RegisterGrant(ctx context.Context, g *grant) (*AccessToken, *RefreshToken, error) {
-	// err := grantRepo.Save(ctx, g)
-	// if err != nil {
-	// 	return nil, nil, err
-	// }
+func RegisterGrant(ctx context.Context, g *Grant) (*AccessToken, *RefreshToken, error) {
+	if grantRepo != nil {
+		err := grantRepo.Save(ctx, g)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	accessToken := createAccessToken()
 	err := storeToken(accessToken, g)
@@ -117,11 +127,11 @@ func createRefreshToken() *RefreshToken {
 	return &refreshToken
 }
 
-func storeToken(token Token, g *grant) error {
+func storeToken(token Token, g *Grant) error {
 	return grantStore.Set(token.Key(), g)
 }
 
-func LeaseGrant(ctx context.Context, refreshToken *RefreshToken) (*AccessToken, *grant, error) {
+func LeaseGrant(ctx context.Context, refreshToken *RefreshToken) (*AccessToken, *Grant, error) {
 	g, err := FindGrantByToken(ctx, refreshToken)
 	if err != nil {
 		return nil, nil, err
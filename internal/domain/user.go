@@ -13,12 +13,17 @@ import (
 type UserRepository interface {
 	core.Repository[string, *User]
 	FindByUserId(ctx context.Context, userId []byte) (*User, error)
+	FindByHandle(ctx context.Context, handle []byte) (*User, error)
 	ExistsUserId(ctx context.Context, userId []byte) (bool, error)
 }
 
 type User struct {
 	ID     uuid.UUID
 	UserID []byte
+	// Handle is the random WebAuthn user handle, which is stored on
+	// discoverable credentials in place of the user id. It is empty for
+	// users registered before user handles were introduced.
+	Handle []byte
 	Status string
 }
 
@@ -53,6 +58,12 @@ func (s *UserService) GetUserByUserID(ctx context.Context, userId []byte) (*User
 	return s.repo.FindByUserId(ctx, hashedUserId)
 }
 
+// GetUserByHandle finds the user by the WebAuthn user handle.
+func (s *UserService) GetUserByHandle(ctx context.Context, handle []byte) (*User, error) {
+	s.logger.InfoContext(ctx, "Finding user by handle")
+	return s.repo.FindByHandle(ctx, handle)
+}
+
 func (s *UserService) CreateUser(ctx context.Context, user *User) error {
 	user.ID = uuid.New()
 	user.UserID = s.hashUserId(user.UserID)
@@ -11,12 +11,17 @@ import (
 
 type Credential struct {
 	gorm.Model
-	ID           uuid.UUID `gorm:"primaryKey;type:uuid"`
-	CredentialID []byte    `gorm:"type:bytea;unique;index;not null"`
-	PublicKey    []byte    `gorm:"type:bytea;not null"`
-	UserID       uuid.UUID `gorm:"not null"`
-	User         *User     `gorm:"foreignKey:UserID`
-	Status       string    `gorm:"not null"`
+	ID              uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CredentialID    []byte    `gorm:"type:bytea;unique;index;not null"`
+	PublicKey       []byte    `gorm:"type:bytea;not null"`
+	UserID          uuid.UUID `gorm:"not null"`
+	User            *User     `gorm:"foreignKey:UserID"`
+	Status          string    `gorm:"not null"`
+	SignCount       uint32    `gorm:"not null;default:0"`
+	BackupEligible  *bool
+	BackupState     bool      `gorm:"not null;default:false"`
+	AttestationType string    `gorm:"not null;default:none"`
+	AAGUID          uuid.UUID `gorm:"type:uuid"`
 }
 
 type GormCredentialRepo struct {
@@ -29,11 +34,16 @@ func NewGormCredentialRepo(db *gorm.DB) *GormCredentialRepo {
 			db: db,
 			toGormModel: func(credential *domain.Credential) *Credential {
 				return &Credential{
-					ID:           credential.ID,
-					CredentialID: credential.CredentialID,
-					PublicKey:    credential.PublicKey,
-					UserID:       credential.User.ID,
-					Status:       credential.Status,
+					ID:              credential.ID,
+					CredentialID:    credential.CredentialID,
+					PublicKey:       credential.PublicKey,
+					UserID:          credential.User.ID,
+					Status:          credential.Status,
+					SignCount:       credential.SignCount,
+					BackupEligible:  credential.BackupEligible,
+					BackupState:     credential.BackupState,
+					AttestationType: credential.AttestationType,
+					AAGUID:          credential.AAGUID,
 				}
 			},
 			toModel: func(gormCredential *Credential) *domain.Credential {
@@ -44,7 +54,12 @@ func NewGormCredentialRepo(db *gorm.DB) *GormCredentialRepo {
 					User: &domain.User{
 						ID: gormCredential.UserID,
 					},
-					Status: gormCredential.Status,
+					Status:          gormCredential.Status,
+					SignCount:       gormCredential.SignCount,
+					BackupEligible:  gormCredential.BackupEligible,
+					BackupState:     gormCredential.BackupState,
+					AttestationType: gormCredential.AttestationType,
+					AAGUID:          gormCredential.AAGUID,
 				}
 			},
 		},
@@ -75,3 +90,21 @@ func (r *GormCredentialRepo) FindByUserID(ctx context.Context, userID uuid.UUID)
 
 	return domainCredentials, nil
 }
+
+// UpdateSignCount stores the sign count and backup flags, unless the stored
+// sign count is not lower anymore. Counters of authenticators without a
+// counter remain 0.
+func (r *GormCredentialRepo) UpdateSignCount(ctx context.Context, credential *domain.Credential) (bool, error) {
+	query := r.db.WithContext(ctx).Model(&Credential{}).Where("id = ?", credential.ID)
+	if credential.SignCount == 0 {
+		query = query.Where("sign_count = 0")
+	} else {
+		query = query.Where("sign_count < ?", credential.SignCount)
+	}
+	result := query.Updates(map[string]interface{}{
+		"sign_count":      credential.SignCount,
+		"backup_eligible": credential.BackupEligible,
+		"backup_state":    credential.BackupState,
+	})
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,138 @@
+package gorm_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/google/uuid"
+)
+
+func newTestCredential(t *testing.T, repo *gormLocal.GormCredentialRepo, signCount uint32) *domain.Credential {
+	t.Helper()
+	credential := &domain.Credential{
+		ID:           uuid.New(),
+		CredentialID: []byte(uuid.NewString()),
+		PublicKey:    []byte("public key"),
+		User:         &domain.User{ID: uuid.New()},
+		Status:       domain.CredentialStatusActive,
+		SignCount:    signCount,
+	}
+	if err := repo.Save(context.Background(), credential); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	return credential
+}
+
+func TestGormCredentialRepoUpdateSignCount(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &gormLocal.Credential{})
+	repo := gormLocal.NewGormCredentialRepo(db)
+
+	tests := []struct {
+		name        string
+		stored      uint32
+		signCount   uint32
+		wantUpdated bool
+	}{
+		{
+			name:        "Increased",
+			stored:      5,
+			signCount:   6,
+			wantUpdated: true,
+		},
+		{
+			name:      "Same",
+			stored:    5,
+			signCount: 5,
+		},
+		{
+			name:      "Decreased",
+			stored:    5,
+			signCount: 4,
+		},
+		{
+			name:        "Without counter",
+			stored:      0,
+			signCount:   0,
+			wantUpdated: true,
+		},
+		{
+			name:      "Counter reset",
+			stored:    5,
+			signCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential := newTestCredential(t, repo, tt.stored)
+			update := *credential
+			backupEligible := true
+			update.SignCount = tt.signCount
+			update.BackupEligible = &backupEligible
+			update.BackupState = true
+
+			updated, err := repo.UpdateSignCount(ctx, &update)
+			if err != nil {
+				t.Fatalf("UpdateSignCount() error = %v", err)
+			}
+			if updated != tt.wantUpdated {
+				t.Errorf("UpdateSignCount() = %v, want %v", updated, tt.wantUpdated)
+			}
+
+			found, err := repo.FindByCredentialId(ctx, credential.CredentialID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSignCount := tt.stored
+			if tt.wantUpdated {
+				wantSignCount = tt.signCount
+			}
+			if found.SignCount != wantSignCount || found.BackupState != tt.wantUpdated {
+				t.Errorf("stored sign count = %d, backup state = %v, want %d, %v", found.SignCount, found.BackupState, wantSignCount, tt.wantUpdated)
+			}
+			// the backup eligibility of credentials registered before it was
+			// stored is unknown until it is recorded
+			if (found.BackupEligible != nil) != tt.wantUpdated {
+				t.Errorf("stored backup eligibility = %v, want recorded %v", found.BackupEligible, tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestGormCredentialRepoConcurrentUpdateSignCount(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &gormLocal.Credential{})
+	repo := gormLocal.NewGormCredentialRepo(db)
+	credential := newTestCredential(t, repo, 5)
+
+	const workers = 8
+	var updates [workers]bool
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			update := *credential
+			update.SignCount = 6
+			updated, err := repo.UpdateSignCount(ctx, &update)
+			if err != nil {
+				t.Errorf("UpdateSignCount() error = %v", err)
+			}
+			updates[worker] = updated
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, updated := range updates {
+		if updated {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("UpdateSignCount() with the same sign count succeeded %d times, want 1", count)
+	}
+}
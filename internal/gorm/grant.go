@@ -0,0 +1,75 @@
+package gorm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	"github.com/google/uuid"
+
+	"gorm.io/gorm"
+)
+
+type Grant struct {
+	ID                uuid.UUID `gorm:"primaryKey;type:uuid"`
+	SubjectID         uuid.UUID `gorm:"type:uuid;index;not null"`
+	ClientID          string    `gorm:"index;not null"`
+	Scope             string
+	IssuedAt          time.Time `gorm:"not null"`
+	ExpiresAt         time.Time `gorm:"index;not null"`
+	NotBefore         time.Time
+	AllowRefreshToken bool `gorm:"not null"`
+}
+
+type GormGrantRepo struct {
+	GormRepository[uuid.UUID, *Grant, *domain.Grant]
+}
+
+func NewGormGrantRepo(db *gorm.DB) *GormGrantRepo {
+	return &GormGrantRepo{
+		GormRepository: GormRepository[uuid.UUID, *Grant, *domain.Grant]{
+			db: db,
+			toGormModel: func(grant *domain.Grant) *Grant {
+				return &Grant{
+					ID:                grant.ID,
+					SubjectID:         grant.SubjectID,
+					ClientID:          grant.ClientID,
+					Scope:             strings.Join(grant.Scope, " "),
+					IssuedAt:          grant.IssuedAt,
+					ExpiresAt:         grant.ExpiresAt,
+					NotBefore:         grant.NotBefore,
+					AllowRefreshToken: grant.AllowRefreshToken,
+				}
+			},
+			toModel: func(gormGrant *Grant) *domain.Grant {
+				return &domain.Grant{
+					ID:                gormGrant.ID,
+					SubjectID:         gormGrant.SubjectID,
+					ClientID:          gormGrant.ClientID,
+					Scope:             strings.Fields(gormGrant.Scope),
+					IssuedAt:          gormGrant.IssuedAt,
+					ExpiresAt:         gormGrant.ExpiresAt,
+					NotBefore:         gormGrant.NotBefore,
+					AllowRefreshToken: gormGrant.AllowRefreshToken,
+				}
+			},
+		},
+	}
+}
+
+// FindById finds the grant with the id. Expired grants are not found.
+func (r *GormGrantRepo) FindById(ctx context.Context, id uuid.UUID) (*domain.Grant, error) {
+	var gormGrant Grant
+	err := r.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now()).First(&gormGrant).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toModel(&gormGrant), nil
+}
+
+// DeleteExpired removes all grants that expired before now.
+func (r *GormGrantRepo) DeleteExpired(ctx context.Context) error {
+	return r.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&Grant{}).Error
+}
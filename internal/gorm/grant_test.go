@@ -0,0 +1,84 @@
+package gorm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/google/uuid"
+)
+
+func newTestGrant(expiresAt time.Time) *domain.Grant {
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	return &domain.Grant{
+		ID:                uuid.New(),
+		SubjectID:         uuid.New(),
+		ClientID:          "central",
+		Scope:             []string{"openid", "authorization"},
+		IssuedAt:          issuedAt,
+		ExpiresAt:         expiresAt.UTC().Truncate(time.Second),
+		NotBefore:         issuedAt,
+		AllowRefreshToken: true,
+	}
+}
+
+func TestGormGrantRepo(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &gormLocal.Grant{})
+	repo := gormLocal.NewGormGrantRepo(db)
+
+	grant := newTestGrant(time.Now().Add(time.Hour))
+	expired := newTestGrant(time.Now().Add(-time.Hour))
+	for _, g := range []*domain.Grant{grant, expired} {
+		if err := repo.Save(ctx, g); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	found, err := repo.FindById(ctx, grant.ID)
+	if err != nil {
+		t.Fatalf("FindById() error = %v", err)
+	}
+	if found.SubjectID != grant.SubjectID || found.ClientID != grant.ClientID || !found.AllowRefreshToken ||
+		len(found.Scope) != 2 || found.Scope[1] != "authorization" || !found.ExpiresAt.Equal(grant.ExpiresAt) {
+		t.Errorf("FindById() = %+v, want %+v", found, grant)
+	}
+
+	if _, err := repo.FindById(ctx, expired.ID); err == nil {
+		t.Errorf("FindById() of expired grant succeeded")
+	}
+	if _, err := repo.FindById(ctx, uuid.New()); err == nil {
+		t.Errorf("FindById() of unknown grant succeeded")
+	}
+
+	if err := repo.DeleteExpired(ctx); err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	var count int64
+	db.Model(&gormLocal.Grant{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d grants left after DeleteExpired(), want 1", count)
+	}
+}
+
+func TestGrantRepoInUse(t *testing.T) {
+	ctx := context.Background()
+	domain.UseGrantRepo(gormLocal.NewGormGrantRepo(newTestDB(t, &gormLocal.Grant{})))
+	t.Cleanup(func() {
+		domain.UseGrantRepo(nil)
+	})
+
+	grant := newTestGrant(time.Now().Add(time.Hour))
+	if _, _, err := domain.RegisterGrant(ctx, grant); err != nil {
+		t.Fatalf("RegisterGrant() error = %v", err)
+	}
+	found, err := domain.FindGrantByGrantID(ctx, grant.ID)
+	if err != nil {
+		t.Fatalf("FindGrantByGrantID() error = %v", err)
+	}
+	if found.SubjectID != grant.SubjectID {
+		t.Errorf("FindGrantByGrantID() = %+v, want %+v", found, grant)
+	}
+}
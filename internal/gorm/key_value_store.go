@@ -0,0 +1,164 @@
+package gorm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KeyValueModel is an encoded value of a GormKeyValueStore.
+type KeyValueModel struct {
+	Kind    string `gorm:"primaryKey"`
+	KeyHash string `gorm:"primaryKey"`
+	Value   []byte `gorm:"type:bytea;not null"`
+	// ExpiresAt is nil for values without a ttl
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+func (KeyValueModel) TableName() string {
+	return "key_values"
+}
+
+// GormKeyValueStore is a KeyValueStore persisting its values in the
+// database. Stores of different kinds share one table. Keys are stored
+// hashed, so the table does not contain usable codes, and expired values are
+// treated as not found until DeleteExpired removes them.
+type GormKeyValueStore[Type interface{}] struct {
+	db    *gorm.DB
+	kind  string
+	codec core.Codec[Type]
+}
+
+func NewGormKeyValueStore[Type interface{}](db *gorm.DB, kind string, codec core.Codec[Type]) *GormKeyValueStore[Type] {
+	return &GormKeyValueStore[Type]{db: db, kind: kind, codec: codec}
+}
+
+func (store *GormKeyValueStore[Type]) Get(key string) (Type, error) {
+	var empty Type
+	var model KeyValueModel
+	err := store.current(store.db, key).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return empty, fmt.Errorf("key not found")
+	}
+	if err != nil {
+		return empty, err
+	}
+	return store.codec.Decode(model.Value)
+}
+
+func (store *GormKeyValueStore[Type]) Set(key string, value Type) error {
+	return store.save(key, value, nil)
+}
+
+func (store *GormKeyValueStore[Type]) SetWithTTL(key string, value Type, ttl time.Duration) error {
+	if ttl <= 0 {
+		// the value would be expired right away
+		return store.Delete(key)
+	}
+	expiresAt := time.Now().Add(ttl)
+	return store.save(key, value, &expiresAt)
+}
+
+func (store *GormKeyValueStore[Type]) save(key string, value Type, expiresAt *time.Time) error {
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return store.db.Save(&KeyValueModel{Kind: store.kind, KeyHash: hashKey(key), Value: data, ExpiresAt: expiresAt}).Error
+}
+
+func (store *GormKeyValueStore[Type]) Delete(key string) error {
+	return store.db.Where("kind = ? AND key_hash = ?", store.kind, hashKey(key)).Delete(&KeyValueModel{}).Error
+}
+
+func (store *GormKeyValueStore[Type]) GetAndDelete(key string) (Type, error) {
+	var empty Type
+	var model KeyValueModel
+	result := store.current(store.db.Clauses(clause.Returning{}), key).Delete(&model)
+	if result.Error != nil {
+		return empty, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return empty, fmt.Errorf("key not found")
+	}
+	return store.codec.Decode(model.Value)
+}
+
+// CompareAndSet compares the encoded values, so the codec must encode equal
+// values to the same bytes.
+func (store *GormKeyValueStore[Type]) CompareAndSet(key string, expected Type, value Type) (bool, error) {
+	expectedData, err := store.codec.Encode(expected)
+	if err != nil {
+		return false, err
+	}
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	swapped := false
+	err = store.db.Transaction(func(tx *gorm.DB) error {
+		var current KeyValueModel
+		err := store.current(tx.Clauses(clause.Locking{Strength: "UPDATE"}), key).First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current.Value, expectedData) {
+			return nil
+		}
+		swapped = true
+		current.Value = data
+		return tx.Save(&current).Error
+	})
+	return swapped, err
+}
+
+// SetIfAbsent replaces an expired value, but not an existing one. The insert
+// fails on the primary key if a concurrent caller set the value first.
+func (store *GormKeyValueStore[Type]) SetIfAbsent(key string, value Type, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return true, nil
+	}
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	err = store.db.Where("kind = ? AND key_hash = ? AND expires_at <= ?", store.kind, hashKey(key), now).Delete(&KeyValueModel{}).Error
+	if err != nil {
+		return false, err
+	}
+	expiresAt := now.Add(ttl)
+	result := store.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&KeyValueModel{Kind: store.kind, KeyHash: hashKey(key), Value: data, ExpiresAt: &expiresAt})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (store *GormKeyValueStore[Type]) WithContext(ctx context.Context) core.KeyValueStore[string, Type] {
+	return &GormKeyValueStore[Type]{db: store.db.WithContext(ctx), kind: store.kind, codec: store.codec}
+}
+
+// DeleteExpired removes all expired values of the store.
+func (store *GormKeyValueStore[Type]) DeleteExpired(ctx context.Context) error {
+	return store.db.WithContext(ctx).Where("kind = ? AND expires_at <= ?", store.kind, time.Now()).Delete(&KeyValueModel{}).Error
+}
+
+// current selects the value of the key, unless it has expired.
+func (store *GormKeyValueStore[Type]) current(db *gorm.DB, key string) *gorm.DB {
+	return db.Where("kind = ? AND key_hash = ? AND (expires_at IS NULL OR expires_at > ?)", store.kind, hashKey(key), time.Now())
+}
+
+// hashKey hashes keys without a pepper, as values must be found by their key
+// regardless of the configured peppers. Keys are either random or short-lived.
+func hashKey(key string) string {
+	return core.HashKey(key)
+}
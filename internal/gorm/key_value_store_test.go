@@ -0,0 +1,178 @@
+package gorm_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Person struct {
+	Name string
+}
+
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	// a shared cache lets all connections of the pool see the same database
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared&_busy_timeout=5000"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestStore(t *testing.T) (*gorm.DB, *gormLocal.GormKeyValueStore[*Person]) {
+	db := newTestDB(t, &gormLocal.KeyValueModel{})
+	return db, gormLocal.NewGormKeyValueStore[*Person](db, "person", core.JSONCodec[*Person]{})
+}
+
+func TestGormKeyValueStoreFullFlow(t *testing.T) {
+	db, store := newTestStore(t)
+	other := gormLocal.NewGormKeyValueStore[*Person](db, "other", core.JSONCodec[*Person]{})
+
+	if err := store.Set("1", &Person{Name: "John"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	value, err := store.Get("1")
+	if err != nil || value.Name != "John" {
+		t.Errorf("Get() = %v, error = %v, want John", value, err)
+	}
+	if _, err := other.Get("1"); err == nil {
+		t.Errorf("Get() from store of other kind succeeded")
+	}
+
+	var model gormLocal.KeyValueModel
+	db.First(&model)
+	if model.KeyHash == "1" {
+		t.Errorf("key stored unhashed")
+	}
+
+	if err := store.Set("1", &Person{Name: "Paul"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, _ := store.Get("1"); value == nil || value.Name != "Paul" {
+		t.Errorf("Get() after overwrite = %v, want Paul", value)
+	}
+
+	if err := store.Delete("1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("1"); err == nil {
+		t.Errorf("Get() after Delete() succeeded")
+	}
+}
+
+func TestGormKeyValueStoreTTL(t *testing.T) {
+	db, store := newTestStore(t)
+
+	store.SetWithTTL("1", &Person{Name: "John"}, time.Hour)
+	store.SetWithTTL("2", &Person{Name: "Paul"}, time.Millisecond)
+	store.SetWithTTL("3", &Person{Name: "Peter"}, -time.Second)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := store.Get("1"); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+	if _, err := store.Get("2"); err == nil {
+		t.Errorf("Get() of expired value succeeded")
+	}
+	if _, err := store.GetAndDelete("2"); err == nil {
+		t.Errorf("GetAndDelete() of expired value succeeded")
+	}
+	if _, err := store.Get("3"); err == nil {
+		t.Errorf("Get() of value with negative ttl succeeded")
+	}
+
+	if err := store.DeleteExpired(context.Background()); err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	var count int64
+	db.Model(&gormLocal.KeyValueModel{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d values left after DeleteExpired(), want 1", count)
+	}
+}
+
+func TestGormKeyValueStoreAtomicOperations(t *testing.T) {
+	_, store := newTestStore(t)
+
+	store.SetWithTTL("1", &Person{Name: "John"}, time.Hour)
+	swapped, err := store.CompareAndSet("1", &Person{Name: "Peter"}, &Person{Name: "Paul"})
+	if err != nil || swapped {
+		t.Errorf("CompareAndSet() with wrong expected value = %v, error = %v, want false", swapped, err)
+	}
+	swapped, err = store.CompareAndSet("1", &Person{Name: "John"}, &Person{Name: "Paul"})
+	if err != nil || !swapped {
+		t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+	}
+	swapped, err = store.CompareAndSet("2", &Person{Name: "John"}, &Person{Name: "Paul"})
+	if err != nil || swapped {
+		t.Errorf("CompareAndSet() on missing key = %v, error = %v, want false", swapped, err)
+	}
+
+	value, err := store.GetAndDelete("1")
+	if err != nil || value == nil || value.Name != "Paul" {
+		t.Errorf("GetAndDelete() value = %v, error = %v, want Paul", value, err)
+	}
+	if _, err := store.GetAndDelete("1"); err == nil {
+		t.Errorf("second GetAndDelete() error = nil, want error")
+	}
+
+	set, err := store.SetIfAbsent("3", &Person{Name: "John"}, time.Hour)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() = %v, error = %v, want true", set, err)
+	}
+	set, err = store.SetIfAbsent("3", &Person{Name: "Paul"}, time.Hour)
+	if err != nil || set {
+		t.Errorf("SetIfAbsent() on existing key = %v, error = %v, want false", set, err)
+	}
+	store.SetWithTTL("4", &Person{Name: "John"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	set, err = store.SetIfAbsent("4", &Person{Name: "Paul"}, time.Hour)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() on expired key = %v, error = %v, want true", set, err)
+	}
+}
+
+func TestGormKeyValueStoreConcurrentGetAndDelete(t *testing.T) {
+	_, store := newTestStore(t)
+	store.Set("1", &Person{Name: "John"})
+
+	const workers = 8
+	var received [workers]bool
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			_, err := store.GetAndDelete("1")
+			received[worker] = err == nil
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, ok := range received {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("GetAndDelete() returned the value %d times, want 1", count)
+	}
+}
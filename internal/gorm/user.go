@@ -13,6 +13,7 @@ type User struct {
 	gorm.Model
 	ID     uuid.UUID `gorm:"primaryKey;type:uuid"`
 	UserID []byte    `gorm:"type:bytea;unique;index;not null"`
+	Handle []byte    `gorm:"type:bytea;unique;index"`
 	Status string    `gorm:"not null"`
 }
 
@@ -28,6 +29,7 @@ func NewGormUserRepo(db *gorm.DB) *GormUserRepo {
 				return &User{
 					ID:     user.ID,
 					UserID: user.UserID,
+					Handle: user.Handle,
 					Status: user.Status,
 				}
 			},
@@ -35,6 +37,7 @@ func NewGormUserRepo(db *gorm.DB) *GormUserRepo {
 				return &domain.User{
 					ID:     gormUser.ID,
 					UserID: gormUser.UserID,
+					Handle: gormUser.Handle,
 					Status: gormUser.Status,
 				}
 			},
@@ -52,6 +55,16 @@ func (r *GormUserRepo) FindByUserId(ctx context.Context, userId []byte) (*domain
 	return r.toModel(&gormUser), nil
 }
 
+func (r *GormUserRepo) FindByHandle(ctx context.Context, handle []byte) (*domain.User, error) {
+	var gormUser User
+	err := r.db.WithContext(ctx).Where("handle = ?", handle).First(&gormUser).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return r.toModel(&gormUser), nil
+}
+
 func (r *GormUserRepo) ExistsUserId(ctx context.Context, userId []byte) (bool, error) {
 	var gormUser User
 	err := r.db.WithContext(ctx).Where("user_id = ?", userId).First(&gormUser).Error
@@ -0,0 +1,41 @@
+package gorm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/domain"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestGormUserRepoFindByHandle(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &gormLocal.User{})
+	repo := gormLocal.NewGormUserRepo(db)
+
+	user := &domain.User{ID: uuid.New(), UserID: []byte("alice"), Handle: []byte("handle of alice"), Status: "active"}
+	if err := repo.Save(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	// users registered before user handles were introduced have none
+	legacy := &domain.User{ID: uuid.New(), UserID: []byte("bob"), Status: "active"}
+	if err := repo.Save(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.FindByHandle(ctx, user.Handle)
+	if err != nil {
+		t.Fatalf("FindByHandle() error = %v", err)
+	}
+	if got.ID != user.ID || string(got.Handle) != string(user.Handle) {
+		t.Errorf("FindByHandle() = %v, want %v", got, user)
+	}
+
+	_, err = repo.FindByHandle(ctx, legacy.UserID)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("FindByHandle() error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
@@ -0,0 +1,122 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public part of a key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKSet is a set of keys as published on a JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public part of the key in the JSON Web Key format.
+func (k *Key) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Algorithm,
+	}
+
+	switch publicKey := k.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = encoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+		jwk.Y = encoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = encoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = encoding.EncodeToString(publicKey)
+	}
+
+	return jwk
+}
+
+// Key converts the JSON Web Key into a verification key.
+func (j *JWK) Key() (*Key, error) {
+	key, err := j.key()
+	if err != nil {
+		return nil, err
+	}
+	if j.Algorithm != "" && j.Algorithm != key.Algorithm {
+		return nil, fmt.Errorf("jwt: algorithm %s does not match key type %s", j.Algorithm, j.KeyType)
+	}
+	return key, nil
+}
+
+func (j *JWK) key() (*Key, error) {
+	switch j.KeyType {
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, fmt.Errorf("jwt: unsupported curve %s", j.Curve)
+		}
+		x, err := encoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := encoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     big.NewInt(0).SetBytes(x),
+			Y:     big.NewInt(0).SetBytes(y),
+		}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, fmt.Errorf("jwt: point is not on curve")
+		}
+		return NewVerificationKey(j.KeyID, ES256, publicKey)
+	case "RSA":
+		n, err := encoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := encoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		publicKey := &rsa.PublicKey{
+			N: big.NewInt(0).SetBytes(n),
+			E: int(big.NewInt(0).SetBytes(e).Int64()),
+		}
+		return NewVerificationKey(j.KeyID, RS256, publicKey)
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("jwt: unsupported curve %s", j.Curve)
+		}
+		x, err := encoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwt: invalid Ed25519 key size")
+		}
+		return NewVerificationKey(j.KeyID, EdDSA, ed25519.PublicKey(x))
+	default:
+		return nil, fmt.Errorf("jwt: unsupported key type %s", j.KeyType)
+	}
+}
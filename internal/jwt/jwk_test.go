@@ -0,0 +1,135 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name       string
+		algorithm  string
+		privateKey crypto.Signer
+		keyType    string
+	}{
+		{
+			name:       "ES256",
+			algorithm:  jwt.ES256,
+			privateKey: ecdsaKey,
+			keyType:    "EC",
+		},
+		{
+			name:       "RS256",
+			algorithm:  jwt.RS256,
+			privateKey: rsaKey,
+			keyType:    "RSA",
+		},
+		{
+			name:       "EdDSA",
+			algorithm:  jwt.EdDSA,
+			privateKey: ed25519Key,
+			keyType:    "OKP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := jwt.NewSigningKey("kid", tt.algorithm, tt.privateKey)
+			if err != nil {
+				t.Fatalf("NewSigningKey() error = %v", err)
+			}
+
+			data, err := json.Marshal(jwt.JWKSet{Keys: []jwt.JWK{key.JWK()}})
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			set := jwt.JWKSet{}
+			if err := json.Unmarshal(data, &set); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if len(set.Keys) != 1 || set.Keys[0].KeyType != tt.keyType || set.Keys[0].KeyID != "kid" || set.Keys[0].Algorithm != tt.algorithm {
+				t.Fatalf("unexpected key set %s", data)
+			}
+
+			verificationKey, err := set.Keys[0].Key()
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			if verificationKey.CanSign() {
+				t.Errorf("key from JWK can sign")
+			}
+			if verificationKey.Algorithm != tt.algorithm {
+				t.Errorf("Algorithm = %s, want %s", verificationKey.Algorithm, tt.algorithm)
+			}
+
+			token, err := jwt.Sign(key, "JWT", &jwt.RegisteredClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()})
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			_, err = jwt.Parse(token, &jwt.RegisteredClaims{}, func(header *jwt.Header) (*jwt.Key, error) {
+				return verificationKey, nil
+			})
+			if err != nil {
+				t.Errorf("Parse() with key from JWK error = %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKInvalid(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := jwt.NewSigningKey("kid", jwt.ES256, ecdsaKey)
+	valid := key.JWK()
+
+	notOnCurve := valid
+	notOnCurve.Y = valid.X
+	otherCurve := valid
+	otherCurve.Curve = "P-384"
+	algorithmMismatch := valid
+	algorithmMismatch.Algorithm = jwt.RS256
+
+	tests := []struct {
+		name string
+		jwk  jwt.JWK
+	}{
+		{
+			name: "Point not on curve",
+			jwk:  notOnCurve,
+		},
+		{
+			name: "Unsupported curve",
+			jwk:  otherCurve,
+		},
+		{
+			name: "Algorithm does not match key type",
+			jwk:  algorithmMismatch,
+		},
+		{
+			name: "Unsupported key type",
+			jwk:  jwt.JWK{KeyType: "oct"},
+		},
+		{
+			name: "Short Ed25519 key",
+			jwk:  jwt.JWK{KeyType: "OKP", Curve: "Ed25519", X: "AAAA"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.jwk.Key(); err == nil {
+				t.Errorf("Key() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token expired")
+	ErrMissingExpiry    = errors.New("jwt: token has no expiry")
+	ErrNotYetValid      = errors.New("jwt: token not yet valid")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Header is the JOSE header of a signed token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	// X509CertificateChain contains the base64 encoded DER certificates of
+	// the signing key, the certificate of the key first.
+	X509CertificateChain []string `json:"x5c,omitempty"`
+}
+
+// Audience is the "aud" claim, which may be encoded either as a single string
+// or as an array of strings.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisteredClaims are the claims registered in RFC 7519, section 4.1.
+type RegisteredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// ValidateTime checks the "exp" and "nbf" claims against the given time.
+// Tokens without "exp" are rejected, as they would be valid forever.
+func (c *RegisteredClaims) ValidateTime(now time.Time) error {
+	if c.ExpiresAt == 0 {
+		return ErrMissingExpiry
+	}
+	if !now.Before(time.Unix(c.ExpiresAt, 0)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	return nil
+}
+
+// Sign serializes the claims and signs them with the given key using the
+// compact serialization.
+func Sign(key *Key, tokenType string, claims interface{}) (string, error) {
+	header, err := json.Marshal(&Header{
+		Algorithm: key.Algorithm,
+		Type:      tokenType,
+		KeyID:     key.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	signature, err := key.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// KeyFunc resolves the key used to verify a token from its header.
+type KeyFunc func(header *Header) (*Key, error)
+
+// Parse verifies the signature of the token and decodes its payload into
+// claims. Validating the claims themselves is left to the caller.
+func Parse(token string, claims interface{}, keyFunc KeyFunc) (*Header, error) {
+	parts, header, err := split(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("jwt: algorithm %s does not match key", header.Algorithm)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := key.Verify([]byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	if err := decodePayload(parts[1], claims); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// ParseUnverified decodes the token without verifying its signature. The
+// claims must not be trusted.
+func ParseUnverified(token string, claims interface{}) (*Header, error) {
+	parts, header, err := split(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodePayload(parts[1], claims); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func split(token string) ([]string, *Header, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrMalformed
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+	header := &Header{}
+	if err := json.Unmarshal(rawHeader, header); err != nil {
+		return nil, nil, ErrMalformed
+	}
+	return parts, header, nil
+}
+
+func decodePayload(encoded string, claims interface{}) error {
+	payload, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
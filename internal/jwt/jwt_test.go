@@ -0,0 +1,121 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/jwt"
+)
+
+func TestSignAndParse(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name       string
+		algorithm  string
+		privateKey crypto.Signer
+	}{
+		{
+			name:       "ES256",
+			algorithm:  jwt.ES256,
+			privateKey: ecdsaKey,
+		},
+		{
+			name:       "RS256",
+			algorithm:  jwt.RS256,
+			privateKey: rsaKey,
+		},
+		{
+			name:       "EdDSA",
+			algorithm:  jwt.EdDSA,
+			privateKey: ed25519Key,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := jwt.NewSigningKey("key-1", tt.algorithm, tt.privateKey)
+			if err != nil {
+				t.Fatalf("NewSigningKey() error = %v", err)
+			}
+
+			claims := &jwt.RegisteredClaims{
+				Subject:   "john",
+				Audience:  jwt.Audience{"resource-server"},
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			}
+			token, err := jwt.Sign(key, "JWT", claims)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+
+			parsed := &jwt.RegisteredClaims{}
+			header, err := jwt.Parse(token, parsed, func(header *jwt.Header) (*jwt.Key, error) {
+				return key, nil
+			})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if header.KeyID != "key-1" || header.Algorithm != tt.algorithm {
+				t.Errorf("Parse() header = %v", header)
+			}
+			if parsed.Subject != "john" || !parsed.Audience.Contains("resource-server") {
+				t.Errorf("Parse() claims = %v", parsed)
+			}
+			if err := parsed.ValidateTime(time.Now()); err != nil {
+				t.Errorf("ValidateTime() error = %v", err)
+			}
+
+			tampered := token[:len(token)-4] + "AAAA"
+			_, err = jwt.Parse(tampered, &jwt.RegisteredClaims{}, func(header *jwt.Header) (*jwt.Key, error) {
+				return key, nil
+			})
+			if err == nil {
+				t.Errorf("Parse() expected error for tampered token, but got nil")
+			}
+		})
+	}
+}
+
+func TestValidateTime(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		claims  jwt.RegisteredClaims
+		wantErr error
+	}{
+		{
+			name:   "Valid",
+			claims: jwt.RegisteredClaims{ExpiresAt: now.Add(time.Minute).Unix(), NotBefore: now.Add(-time.Minute).Unix()},
+		},
+		{
+			name:    "Missing expiry",
+			claims:  jwt.RegisteredClaims{NotBefore: now.Add(-time.Minute).Unix()},
+			wantErr: jwt.ErrMissingExpiry,
+		},
+		{
+			name:    "Expired",
+			claims:  jwt.RegisteredClaims{ExpiresAt: now.Add(-time.Second).Unix()},
+			wantErr: jwt.ErrExpired,
+		},
+		{
+			name:    "Not yet valid",
+			claims:  jwt.RegisteredClaims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()},
+			wantErr: jwt.ErrNotYetValid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.claims.ValidateTime(now); err != tt.wantErr {
+				t.Errorf("ValidateTime() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+const (
+	ES256 = "ES256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// A Key is used to sign or verify tokens with a single algorithm.
+type Key struct {
+	ID         string
+	Algorithm  string
+	PublicKey  crypto.PublicKey
+	privateKey crypto.Signer
+}
+
+// NewSigningKey creates a key able to sign and verify tokens.
+func NewSigningKey(id string, algorithm string, privateKey crypto.Signer) (*Key, error) {
+	key, err := NewVerificationKey(id, algorithm, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.privateKey = privateKey
+	return key, nil
+}
+
+// NewVerificationKey creates a key only able to verify tokens.
+func NewVerificationKey(id string, algorithm string, publicKey crypto.PublicKey) (*Key, error) {
+	switch algorithm {
+	case ES256:
+		ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok || ecdsaKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("jwt: %s requires a P-256 key", algorithm)
+		}
+	case RS256:
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok || rsaKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("jwt: %s requires an RSA key of at least 2048 bits", algorithm)
+		}
+	case EdDSA:
+		if _, ok := publicKey.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("jwt: %s requires an Ed25519 key", algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %s", algorithm)
+	}
+
+	return &Key{
+		ID:        id,
+		Algorithm: algorithm,
+		PublicKey: publicKey,
+	}, nil
+}
+
+func (k *Key) CanSign() bool {
+	return k.privateKey != nil
+}
+
+func (k *Key) Sign(data []byte) ([]byte, error) {
+	if k.privateKey == nil {
+		return nil, fmt.Errorf("jwt: key %s cannot sign", k.ID)
+	}
+
+	switch k.Algorithm {
+	case ES256:
+		digest := crypto.SHA256.New()
+		digest.Write(data)
+		der, err := k.privateKey.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		var signature struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(der, &signature); err != nil {
+			return nil, err
+		}
+		raw := make([]byte, 64)
+		signature.R.FillBytes(raw[:32])
+		signature.S.FillBytes(raw[32:])
+		return raw, nil
+	case RS256:
+		digest := crypto.SHA256.New()
+		digest.Write(data)
+		return k.privateKey.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+	case EdDSA:
+		return k.privateKey.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %s", k.Algorithm)
+	}
+}
+
+func (k *Key) Verify(data []byte, signature []byte) error {
+	ok := false
+	switch k.Algorithm {
+	case ES256:
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		digest := crypto.SHA256.New()
+		digest.Write(data)
+		r := big.NewInt(0).SetBytes(signature[:32])
+		s := big.NewInt(0).SetBytes(signature[32:])
+		ok = ecdsa.Verify(k.PublicKey.(*ecdsa.PublicKey), digest.Sum(nil), r, s)
+	case RS256:
+		digest := crypto.SHA256.New()
+		digest.Write(data)
+		ok = rsa.VerifyPKCS1v15(k.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest.Sum(nil), signature) == nil
+	case EdDSA:
+		ok = ed25519.Verify(k.PublicKey.(ed25519.PublicKey), data, signature)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// HashFunc returns the hash function associated with an algorithm, as used
+// for example by the "at_hash" claim of OpenID Connect.
+func HashFunc(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case ES256, RS256:
+		return crypto.SHA256, nil
+	case EdDSA:
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("jwt: unsupported algorithm %s", algorithm)
+	}
+}
+
+// A KeySet provides the key to sign new tokens with and resolves the keys
+// to verify existing tokens.
+type KeySet interface {
+	SigningKey(ctx context.Context) (*Key, error)
+	VerificationKey(ctx context.Context, keyID string) (*Key, error)
+}
+
+// StaticKeySet is a KeySet consisting of a single key.
+type StaticKeySet struct {
+	key *Key
+}
+
+func NewStaticKeySet(key *Key) *StaticKeySet {
+	return &StaticKeySet{key: key}
+}
+
+func (s *StaticKeySet) SigningKey(ctx context.Context) (*Key, error) {
+	return s.key, nil
+}
+
+func (s *StaticKeySet) VerificationKey(ctx context.Context, keyID string) (*Key, error) {
+	if keyID != s.key.ID {
+		return nil, fmt.Errorf("jwt: key %s not found", keyID)
+	}
+	return s.key, nil
+}
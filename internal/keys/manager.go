@@ -0,0 +1,312 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/google/uuid"
+)
+
+// SigningKeyModel is the persisted form of a signing key.
+type SigningKeyModel struct {
+	ID        string `gorm:"primaryKey"`
+	Algorithm string `gorm:"not null"`
+	// PrivateKey is the PKCS #8 encoded private key, encrypted if
+	// EncryptionKeyID is set.
+	PrivateKey []byte `gorm:"type:bytea;not null"`
+	// EncryptionKeyID is the id of the key-encryption key, which encrypts the
+	// data key the private key is encrypted with.
+	EncryptionKeyID  string
+	EncryptedDataKey []byte    `gorm:"type:bytea"`
+	CreatedAt        time.Time `gorm:"not null"`
+	// RotatesAt is the time after which the key is no longer used for
+	// signing new tokens.
+	RotatesAt time.Time `gorm:"not null"`
+	// ExpiresAt is the time after which the key is no longer used for
+	// verifying tokens. It is RotatesAt plus the overlap window.
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+type Repository = core.Repository[string, *SigningKeyModel]
+
+type managedKey struct {
+	key   *jwt.Key
+	model *SigningKeyModel
+}
+
+// Manager holds the signing keys of the authorization server. The newest key
+// is used for signing until it is rotated, while older keys stay available
+// for verification during an overlap window.
+//
+// Private keys are encrypted before they are stored if key-encryption keys
+// are configured. Without them, the private keys are only as safe as the
+// database.
+type Manager struct {
+	repo           Repository
+	algorithm      string
+	rotation       time.Duration
+	overlap        time.Duration
+	encryptionKeys *core.KeyEncryptionKeys
+	mutex          sync.RWMutex
+	keys           []*managedKey
+	logger         *slog.Logger
+}
+
+func NewManager(repo Repository, algorithm string, rotation time.Duration, overlap time.Duration, encryptionKeys *core.KeyEncryptionKeys) *Manager {
+	logger := slog.Default().With(slog.String("service", "key-manager"))
+
+	return &Manager{
+		repo:           repo,
+		algorithm:      algorithm,
+		rotation:       rotation,
+		overlap:        overlap,
+		encryptionKeys: encryptionKeys,
+		logger:         logger,
+	}
+}
+
+// Load reads all keys from the repository, deletes expired keys and rotates
+// if there is no key currently valid for signing.
+func (m *Manager) Load(ctx context.Context) error {
+	models, err := m.repo.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var keys []*managedKey
+	for _, model := range models {
+		if !now.Before(model.ExpiresAt) {
+			if err := m.repo.DeleteById(ctx, model.ID); err != nil {
+				return err
+			}
+			m.logger.InfoContext(ctx, "Deleted expired signing key", "kid", model.ID)
+			continue
+		}
+
+		key, err := m.fromModel(model)
+		if err != nil {
+			return err
+		}
+		if m.encryptionKeys != nil && model.EncryptionKeyID == "" {
+			if err := m.encryptStoredKey(ctx, key); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].model.CreatedAt.After(keys[j].model.CreatedAt)
+	})
+
+	m.mutex.Lock()
+	m.keys = keys
+	m.mutex.Unlock()
+
+	if len(keys) == 0 || !now.Before(keys[0].model.RotatesAt) {
+		return m.Rotate(ctx)
+	}
+	return nil
+}
+
+// Rotate generates a new signing key. Previous keys remain available for
+// verification until they expire.
+func (m *Manager) Rotate(ctx context.Context) error {
+	privateKey, err := generateKey(m.algorithm)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	model := &SigningKeyModel{
+		ID:         uuid.New().String(),
+		Algorithm:  m.algorithm,
+		PrivateKey: der,
+		CreatedAt:  now,
+		RotatesAt:  now.Add(m.rotation),
+		ExpiresAt:  now.Add(m.rotation + m.overlap),
+	}
+	if err := m.encrypt(model); err != nil {
+		return err
+	}
+	if err := m.repo.Save(ctx, model); err != nil {
+		return err
+	}
+
+	key, err := m.fromModel(model)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// the previous signing key is retired now and stays valid for the overlap window
+	if len(m.keys) > 0 && now.Before(m.keys[0].model.RotatesAt) {
+		previous := m.keys[0].model
+		previous.RotatesAt = now
+		previous.ExpiresAt = now.Add(m.overlap)
+		if err := m.repo.Update(ctx, previous); err != nil {
+			return err
+		}
+	}
+	m.keys = append([]*managedKey{key}, m.keys...)
+
+	m.logger.InfoContext(ctx, "Rotated signing key", "kid", model.ID, "alg", m.algorithm)
+	return nil
+}
+
+// Start periodically reloads the keys and rotates them when due until the
+// context is cancelled.
+func (m *Manager) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Load(ctx); err != nil {
+					m.logger.ErrorContext(ctx, "Reloading signing keys failed", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+func (m *Manager) SigningKey(ctx context.Context) (*jwt.Key, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if len(m.keys) == 0 {
+		return nil, fmt.Errorf("no signing key available")
+	}
+	return m.keys[0].key, nil
+}
+
+func (m *Manager) VerificationKey(ctx context.Context, keyID string) (*jwt.Key, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	now := time.Now()
+	for _, key := range m.keys {
+		if key.key.ID == keyID && now.Before(key.model.ExpiresAt) {
+			return key.key, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s not found", keyID)
+}
+
+// PublicKeys returns all keys currently valid for verification.
+func (m *Manager) PublicKeys(ctx context.Context) *jwt.JWKSet {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	now := time.Now()
+	set := &jwt.JWKSet{Keys: []jwt.JWK{}}
+	for _, key := range m.keys {
+		if now.Before(key.model.ExpiresAt) {
+			set.Keys = append(set.Keys, key.key.JWK())
+		}
+	}
+	return set
+}
+
+// encrypt replaces the private key of the model with its encryption, if
+// key-encryption keys are configured. The key id is authenticated with the
+// private key, so encrypted keys cannot be swapped.
+func (m *Manager) encrypt(model *SigningKeyModel) error {
+	if m.encryptionKeys == nil {
+		return nil
+	}
+	encrypted, err := m.encryptionKeys.Encrypt(model.PrivateKey, []byte(model.ID))
+	if err != nil {
+		return err
+	}
+	model.PrivateKey = encrypted.Ciphertext
+	model.EncryptionKeyID = encrypted.KeyID
+	model.EncryptedDataKey = encrypted.EncryptedKey
+	return nil
+}
+
+// encryptStoredKey encrypts a key stored before encryption was configured.
+func (m *Manager) encryptStoredKey(ctx context.Context, key *managedKey) error {
+	if err := m.encrypt(key.model); err != nil {
+		return err
+	}
+	if err := m.repo.Update(ctx, key.model); err != nil {
+		return err
+	}
+	m.logger.InfoContext(ctx, "Encrypted stored signing key", "kid", key.model.ID)
+	return nil
+}
+
+func (m *Manager) privateKey(model *SigningKeyModel) ([]byte, error) {
+	if model.EncryptionKeyID == "" {
+		return model.PrivateKey, nil
+	}
+	if m.encryptionKeys == nil {
+		return nil, fmt.Errorf("key %s is encrypted, but no key-encryption keys are configured", model.ID)
+	}
+	return m.encryptionKeys.Decrypt(&core.EncryptedValue{
+		KeyID:        model.EncryptionKeyID,
+		EncryptedKey: model.EncryptedDataKey,
+		Ciphertext:   model.PrivateKey,
+	}, []byte(model.ID))
+}
+
+func (m *Manager) fromModel(model *SigningKeyModel) (*managedKey, error) {
+	der, err := m.privateKey(model)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not a signing key", model.ID)
+	}
+	key, err := jwt.NewSigningKey(model.ID, model.Algorithm, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &managedKey{
+		key:   key,
+		model: model,
+	}, nil
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case jwt.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.RS256:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case jwt.EdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+}
@@ -0,0 +1,233 @@
+package keys_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/Untanky/modern-auth/internal/jwt"
+	"github.com/Untanky/modern-auth/internal/keys"
+)
+
+type memoryRepository struct {
+	mutex  sync.Mutex
+	models map[string]keys.SigningKeyModel
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{models: make(map[string]keys.SigningKeyModel)}
+}
+
+func (r *memoryRepository) FindAll(ctx context.Context) ([]*keys.SigningKeyModel, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var models []*keys.SigningKeyModel
+	for _, model := range r.models {
+		model := model
+		models = append(models, &model)
+	}
+	return models, nil
+}
+
+func (r *memoryRepository) FindById(ctx context.Context, id string) (*keys.SigningKeyModel, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	model, ok := r.models[id]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	return &model, nil
+}
+
+func (r *memoryRepository) Save(ctx context.Context, model *keys.SigningKeyModel) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.models[model.ID] = *model
+	return nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, model *keys.SigningKeyModel) error {
+	return r.Save(ctx, model)
+}
+
+func (r *memoryRepository) DeleteById(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.models, id)
+	return nil
+}
+
+func signAndVerify(t *testing.T, manager *keys.Manager) string {
+	t.Helper()
+	ctx := context.Background()
+	key, err := manager.SigningKey(ctx)
+	if err != nil {
+		t.Fatalf("SigningKey() error = %v", err)
+	}
+	token, err := jwt.Sign(key, "JWT", &jwt.RegisteredClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	_, err = jwt.Parse(token, &jwt.RegisteredClaims{}, func(header *jwt.Header) (*jwt.Key, error) {
+		return manager.VerificationKey(ctx, header.KeyID)
+	})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return key.ID
+}
+
+func TestManagerRotation(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	manager := keys.NewManager(repo, jwt.ES256, time.Hour, 10*time.Minute, nil)
+
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	first := signAndVerify(t, manager)
+
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	second := signAndVerify(t, manager)
+	if first == second {
+		t.Fatalf("signing key not rotated")
+	}
+
+	// the previous key remains valid for verification during the overlap window
+	if _, err := manager.VerificationKey(ctx, first); err != nil {
+		t.Errorf("VerificationKey() of previous key error = %v", err)
+	}
+	if got := len(manager.PublicKeys(ctx).Keys); got != 2 {
+		t.Errorf("PublicKeys() returned %d keys, want 2", got)
+	}
+	previous, _ := repo.FindById(ctx, first)
+	if time.Until(previous.ExpiresAt) > 10*time.Minute {
+		t.Errorf("previous key expires at %v, want within the overlap window", previous.ExpiresAt)
+	}
+
+	// a new manager loads the current signing key instead of rotating
+	reloaded := keys.NewManager(repo, jwt.ES256, time.Hour, 10*time.Minute, nil)
+	if err := reloaded.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := signAndVerify(t, reloaded); got != second {
+		t.Errorf("signing key after reload = %s, want %s", got, second)
+	}
+}
+
+func TestManagerOverlapExpiry(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	manager := keys.NewManager(repo, jwt.EdDSA, time.Hour, time.Hour, nil)
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	first := signAndVerify(t, manager)
+
+	// let the key pass its rotation and overlap window
+	model, _ := repo.FindById(ctx, first)
+	model.RotatesAt = time.Now().Add(-2 * time.Hour)
+	model.ExpiresAt = time.Now().Add(-time.Hour)
+	repo.Update(ctx, model)
+
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if second := signAndVerify(t, manager); second == first {
+		t.Errorf("expired key still used for signing")
+	}
+	if _, err := manager.VerificationKey(ctx, first); err == nil {
+		t.Errorf("VerificationKey() of expired key succeeded")
+	}
+	if _, err := repo.FindById(ctx, first); err == nil {
+		t.Errorf("expired key not deleted")
+	}
+	if got := len(manager.PublicKeys(ctx).Keys); got != 1 {
+		t.Errorf("PublicKeys() returned %d keys, want 1", got)
+	}
+}
+
+func TestManagerRotationDue(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	manager := keys.NewManager(repo, jwt.ES256, time.Hour, time.Hour, nil)
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	first := signAndVerify(t, manager)
+
+	model, _ := repo.FindById(ctx, first)
+	model.RotatesAt = time.Now().Add(-time.Minute)
+	repo.Update(ctx, model)
+
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if second := signAndVerify(t, manager); second == first {
+		t.Errorf("key due for rotation still used for signing")
+	}
+	if _, err := manager.VerificationKey(ctx, first); err != nil {
+		t.Errorf("VerificationKey() of rotated key error = %v", err)
+	}
+}
+
+func newTestKeys(t *testing.T) *core.KeyEncryptionKeys {
+	t.Helper()
+	kek, err := core.NewKeyEncryptionKeys("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kek
+}
+
+func TestManagerEncryption(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+
+	// keys stored before encryption was configured are encrypted on load
+	plain := keys.NewManager(repo, jwt.ES256, time.Hour, time.Hour, nil)
+	if err := plain.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	first := signAndVerify(t, plain)
+
+	kek := newTestKeys(t)
+	manager := keys.NewManager(repo, jwt.ES256, time.Hour, time.Hour, kek)
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := manager.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	second := signAndVerify(t, manager)
+
+	for _, id := range []string{first, second} {
+		model, _ := repo.FindById(ctx, id)
+		if model.EncryptionKeyID != "1" {
+			t.Errorf("key %s encrypted with %q, want 1", id, model.EncryptionKeyID)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(model.PrivateKey); err == nil {
+			t.Errorf("key %s stored unencrypted", id)
+		}
+	}
+
+	reloaded := keys.NewManager(repo, jwt.ES256, time.Hour, time.Hour, kek)
+	if err := reloaded.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := signAndVerify(t, reloaded); got != second {
+		t.Errorf("signing key after reload = %s, want %s", got, second)
+	}
+
+	withoutKeys := keys.NewManager(repo, jwt.ES256, time.Hour, time.Hour, nil)
+	if err := withoutKeys.Load(ctx); err == nil {
+		t.Errorf("Load() of encrypted keys without key-encryption keys succeeded")
+	}
+}
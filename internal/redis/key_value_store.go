@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisKeyValueStore is a KeyValueStore persisting its values in Redis. TTLs
+// are handled by Redis itself.
+type RedisKeyValueStore[Type interface{}] struct {
+	client redis.Cmdable
+	prefix string
+	codec  core.Codec[Type]
+	ctx    context.Context
+}
+
+// NewRedisKeyValueStore creates a store, whose keys are prefixed with the
+// given prefix. Stores sharing a Redis database need different prefixes.
+func NewRedisKeyValueStore[Type interface{}](client redis.Cmdable, prefix string, codec core.Codec[Type]) *RedisKeyValueStore[Type] {
+	return &RedisKeyValueStore[Type]{
+		client: client,
+		prefix: prefix,
+		codec:  codec,
+		ctx:    context.Background(),
+	}
+}
+
+func (store *RedisKeyValueStore[Type]) Get(key string) (Type, error) {
+	var empty Type
+	data, err := store.client.Get(store.ctx, store.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return empty, fmt.Errorf("key not found")
+	}
+	if err != nil {
+		return empty, err
+	}
+	return store.codec.Decode(data)
+}
+
+func (store *RedisKeyValueStore[Type]) Set(key string, value Type) error {
+	// a zero expiration means the key does not expire
+	return store.set(key, value, 0)
+}
+
+func (store *RedisKeyValueStore[Type]) SetWithTTL(key string, value Type, ttl time.Duration) error {
+	if ttl <= 0 {
+		// the value would be expired right away
+		return store.Delete(key)
+	}
+	return store.set(key, value, ttl)
+}
+
+func (store *RedisKeyValueStore[Type]) set(key string, value Type, ttl time.Duration) error {
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return store.client.Set(store.ctx, store.prefix+key, data, ttl).Err()
+}
+
+func (store *RedisKeyValueStore[Type]) Delete(key string) error {
+	return store.client.Del(store.ctx, store.prefix+key).Err()
+}
+
+func (store *RedisKeyValueStore[Type]) GetAndDelete(key string) (Type, error) {
+	var empty Type
+	data, err := store.client.GetDel(store.ctx, store.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return empty, fmt.Errorf("key not found")
+	}
+	if err != nil {
+		return empty, err
+	}
+	return store.codec.Decode(data)
+}
+
+// compareAndSetScript replaces the value only if the stored value matches
+// the expected one, keeping the ttl of the key.
+var compareAndSetScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+// CompareAndSet compares the encoded values, so the codec must encode equal
+// values to the same bytes.
+func (store *RedisKeyValueStore[Type]) CompareAndSet(key string, expected Type, value Type) (bool, error) {
+	expectedData, err := store.codec.Encode(expected)
+	if err != nil {
+		return false, err
+	}
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return false, err
+	}
+	swapped, err := compareAndSetScript.Run(store.ctx, store.client, []string{store.prefix + key}, expectedData, data).Int()
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
+
+func (store *RedisKeyValueStore[Type]) SetIfAbsent(key string, value Type, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		// a zero expiration would keep the value forever
+		return true, nil
+	}
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return false, err
+	}
+	return store.client.SetNX(store.ctx, store.prefix+key, data, ttl).Result()
+}
+
+func (store *RedisKeyValueStore[Type]) WithContext(ctx context.Context) core.KeyValueStore[string, Type] {
+	return &RedisKeyValueStore[Type]{
+		client: store.client,
+		prefix: store.prefix,
+		codec:  store.codec,
+		ctx:    ctx,
+	}
+}
@@ -0,0 +1,187 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	redisLocal "github.com/Untanky/modern-auth/internal/redis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type Person struct {
+	Name     string
+	Birthday time.Time
+	Tags     []string
+}
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return server, client
+}
+
+func TestRedisKeyValueStoreFullFlow(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{
+			name:   "JSON codec",
+			format: core.JSONCodecFormat,
+		},
+		{
+			name:   "Gob codec",
+			format: core.GobCodecFormat,
+		},
+		{
+			name:   "CBOR codec",
+			format: core.CBORCodecFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newTestClient(t)
+			codec, err := core.NewCodec[*Person](tt.format)
+			if err != nil {
+				t.Fatalf("NewCodec() error = %v", err)
+			}
+			store := redisLocal.NewRedisKeyValueStore[*Person](client, "person:", codec)
+
+			birthday := time.Date(1990, time.May, 17, 0, 0, 0, 0, time.UTC)
+			err = store.Set("1", &Person{Name: "John", Birthday: birthday, Tags: []string{"a", "b"}})
+			if err != nil {
+				t.Errorf("Set() error = %v", err)
+			}
+
+			value, err := store.Get("1")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+			if value == nil || value.Name != "John" || !value.Birthday.Equal(birthday) || len(value.Tags) != 2 {
+				t.Errorf("Get() value = %v, want %v", value, "John")
+			}
+
+			err = store.Delete("1")
+			if err != nil {
+				t.Errorf("Delete() error = %v", err)
+			}
+
+			_, err = store.Get("1")
+			if err == nil {
+				t.Errorf("Get() of deleted key error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRedisKeyValueStoreTTL(t *testing.T) {
+	server, client := newTestClient(t)
+	store := redisLocal.NewRedisKeyValueStore[*Person](client, "person:", core.JSONCodec[*Person]{})
+
+	err := store.SetWithTTL("short", &Person{Name: "John"}, time.Minute)
+	if err != nil {
+		t.Errorf("SetWithTTL() error = %v", err)
+	}
+	err = store.Set("forever", &Person{Name: "Peter"})
+	if err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+
+	if ttl := server.TTL("person:short"); ttl != time.Minute {
+		t.Errorf("TTL() = %v, want %v", ttl, time.Minute)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	_, err = store.Get("short")
+	if err == nil {
+		t.Errorf("Get() of expired key error = nil, want error")
+	}
+	value, err := store.Get("forever")
+	if err != nil || value == nil || value.Name != "Peter" {
+		t.Errorf("Get() value = %v, error = %v, want %v", value, err, "Peter")
+	}
+}
+
+func TestRedisKeyValueStoreKeyPrefix(t *testing.T) {
+	_, client := newTestClient(t)
+	first := redisLocal.NewRedisKeyValueStore[*Person](client, "first:", core.JSONCodec[*Person]{})
+	second := redisLocal.NewRedisKeyValueStore[*Person](client, "second:", core.JSONCodec[*Person]{})
+
+	err := first.Set("1", &Person{Name: "John"})
+	if err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+
+	_, err = second.Get("1")
+	if err == nil {
+		t.Errorf("Get() from store with other prefix error = nil, want error")
+	}
+}
+
+func TestRedisKeyValueStoreAtomicOperations(t *testing.T) {
+	server, client := newTestClient(t)
+	store := redisLocal.NewRedisKeyValueStore[*Person](client, "person:", core.JSONCodec[*Person]{})
+
+	err := store.SetWithTTL("1", &Person{Name: "John"}, time.Minute)
+	if err != nil {
+		t.Errorf("SetWithTTL() error = %v", err)
+	}
+
+	swapped, err := store.CompareAndSet("1", &Person{Name: "Peter"}, &Person{Name: "Paul"})
+	if err != nil || swapped {
+		t.Errorf("CompareAndSet() with wrong expected value = %v, error = %v, want false", swapped, err)
+	}
+	swapped, err = store.CompareAndSet("1", &Person{Name: "John"}, &Person{Name: "Paul"})
+	if err != nil || !swapped {
+		t.Errorf("CompareAndSet() = %v, error = %v, want true", swapped, err)
+	}
+	if ttl := server.TTL("person:1"); ttl != time.Minute {
+		t.Errorf("TTL() after CompareAndSet() = %v, want %v", ttl, time.Minute)
+	}
+
+	value, err := store.GetAndDelete("1")
+	if err != nil || value == nil || value.Name != "Paul" {
+		t.Errorf("GetAndDelete() value = %v, error = %v, want %v", value, err, "Paul")
+	}
+	_, err = store.GetAndDelete("1")
+	if err == nil {
+		t.Errorf("second GetAndDelete() error = nil, want error")
+	}
+}
+
+func TestRedisKeyValueStoreSetIfAbsent(t *testing.T) {
+	server, client := newTestClient(t)
+	store := redisLocal.NewRedisKeyValueStore[*Person](client, "person:", core.JSONCodec[*Person]{})
+
+	set, err := store.SetIfAbsent("1", &Person{Name: "John"}, time.Minute)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() = %v, error = %v, want true", set, err)
+	}
+	if ttl := server.TTL("person:1"); ttl != time.Minute {
+		t.Errorf("TTL() after SetIfAbsent() = %v, want %v", ttl, time.Minute)
+	}
+	set, err = store.SetIfAbsent("1", &Person{Name: "Paul"}, time.Minute)
+	if err != nil || set {
+		t.Errorf("SetIfAbsent() on existing key = %v, error = %v, want false", set, err)
+	}
+	if value, _ := store.Get("1"); value == nil || value.Name != "John" {
+		t.Errorf("Get() = %v, want John", value)
+	}
+
+	server.FastForward(2 * time.Minute)
+	set, err = store.SetIfAbsent("1", &Person{Name: "Paul"}, time.Minute)
+	if err != nil || !set {
+		t.Errorf("SetIfAbsent() on expired key = %v, error = %v, want true", set, err)
+	}
+
+	set, err = store.SetIfAbsent("2", &Person{Name: "John"}, 0)
+	if err != nil || !set || server.Exists("person:2") {
+		t.Errorf("SetIfAbsent() with zero ttl = %v, error = %v, want true and not stored", set, err)
+	}
+}
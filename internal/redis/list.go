@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisList is a List persisting its values in a Redis list. All operations
+// are atomic, so the list may be shared by multiple instances.
+type RedisList[Type interface{}] struct {
+	client redis.Cmdable
+	key    string
+	codec  core.Codec[Type]
+	ctx    context.Context
+}
+
+func NewRedisList[Type interface{}](client redis.Cmdable, key string, codec core.Codec[Type]) *RedisList[Type] {
+	return &RedisList[Type]{
+		client: client,
+		key:    key,
+		codec:  codec,
+		ctx:    context.Background(),
+	}
+}
+
+// NewRedisListFactory returns a ListFactory creating lists, whose keys are
+// the name prefixed with the given prefix.
+func NewRedisListFactory[Type interface{}](client redis.Cmdable, prefix string, codec core.Codec[Type]) core.ListFactory[Type] {
+	return func(name string) core.List[Type] {
+		return NewRedisList[Type](client, prefix+name, codec)
+	}
+}
+
+// Index compares the encoded values, so the codec must encode equal values
+// to the same bytes.
+func (list *RedisList[Type]) Index(value Type) (int64, error) {
+	data, err := list.codec.Encode(value)
+	if err != nil {
+		return -1, err
+	}
+	index, err := list.client.LPos(list.ctx, list.key, string(data), redis.LPosArgs{}).Result()
+	if errors.Is(err, redis.Nil) {
+		return -1, fmt.Errorf("value %v not found", value)
+	}
+	if err != nil {
+		return -1, err
+	}
+	return index, nil
+}
+
+// Len returns 0 if the length cannot be read.
+func (list *RedisList[Type]) Len() int64 {
+	length, err := list.client.LLen(list.ctx, list.key).Result()
+	if err != nil {
+		return 0
+	}
+	return length
+}
+
+func (list *RedisList[Type]) Append(value Type) (int64, error) {
+	data, err := list.codec.Encode(value)
+	if err != nil {
+		return -1, err
+	}
+	length, err := list.client.RPush(list.ctx, list.key, data).Result()
+	if err != nil {
+		return -1, err
+	}
+	return length - 1, nil
+}
+
+// removeScript removes the element at the given index. Redis can only
+// remove elements by value, so the element is replaced by a marker first,
+// which is then removed. Redis counts negative indices from the end of the
+// list, so they are rejected.
+var removeScript = redis.NewScript(`
+if tonumber(ARGV[1]) < 0 or not redis.call("LINDEX", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("LSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("LREM", KEYS[1], 1, ARGV[2])
+return 1
+`)
+
+// removedMarker is not a complete encoding in any of the codecs, so it
+// does not collide with stored values.
+const removedMarker = "\x00removed\x00"
+
+func (list *RedisList[Type]) Remove(index int64) error {
+	removed, err := removeScript.Run(list.ctx, list.client, []string{list.key}, index, removedMarker).Int()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("index %d out of range", index)
+	}
+	return nil
+}
+
+// RemoveValue compares the encoded values, so the codec must encode equal
+// values to the same bytes.
+func (list *RedisList[Type]) RemoveValue(value Type) error {
+	data, err := list.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	removed, err := list.client.LRem(list.ctx, list.key, 1, data).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("value %v not found", value)
+	}
+	return nil
+}
+
+func (list *RedisList[Type]) WithContext(ctx context.Context) core.List[Type] {
+	return &RedisList[Type]{
+		client: list.client,
+		key:    list.key,
+		codec:  list.codec,
+		ctx:    ctx,
+	}
+}
+
+// Slice returns nil if the values cannot be read. Values that cannot be
+// decoded are skipped.
+func (list *RedisList[Type]) Slice() []Type {
+	data, err := list.client.LRange(list.ctx, list.key, 0, -1).Result()
+	if err != nil {
+		return nil
+	}
+	values := make([]Type, 0, len(data))
+	for _, d := range data {
+		value, err := list.codec.Decode([]byte(d))
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,66 @@
+package redis_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	redisLocal "github.com/Untanky/modern-auth/internal/redis"
+)
+
+func TestRedisList(t *testing.T) {
+	_, client := newTestClient(t)
+	newList := redisLocal.NewRedisListFactory[string](client, "list:", core.JSONCodec[string]{})
+	list := newList("service")
+
+	for i, value := range []string{"a", "b", "c"} {
+		index, err := list.Append(value)
+		if err != nil || index != int64(i) {
+			t.Errorf("Append() = %v, error = %v, want %v", index, err, i)
+		}
+	}
+
+	index, err := list.Index("b")
+	if err != nil || index != 1 {
+		t.Errorf("Index() = %v, error = %v, want %v", index, err, 1)
+	}
+	_, err = list.Index("d")
+	if err == nil {
+		t.Errorf("Index() of missing value error = nil, want error")
+	}
+
+	err = list.Remove(index)
+	if err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	err = list.Remove(2)
+	if err == nil {
+		t.Errorf("Remove() out of range error = nil, want error")
+	}
+	err = list.Remove(-1)
+	if err == nil {
+		t.Errorf("Remove() with negative index error = nil, want error")
+	}
+
+	list.Append("b")
+	err = list.RemoveValue("b")
+	if err != nil {
+		t.Errorf("RemoveValue() error = %v", err)
+	}
+	err = list.RemoveValue("b")
+	if err == nil {
+		t.Errorf("RemoveValue() of missing value error = nil, want error")
+	}
+
+	// lists of the same name share their values
+	same := newList("service")
+	if got := same.Slice(); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Slice() = %v, want %v", got, []string{"a", "c"})
+	}
+	if got := same.Len(); got != 2 {
+		t.Errorf("Len() = %v, want %v", got, 2)
+	}
+	if got := newList("other").Len(); got != 0 {
+		t.Errorf("Len() of other list = %v, want %v", got, 0)
+	}
+}
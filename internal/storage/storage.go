@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	redisLocal "github.com/Untanky/modern-auth/internal/redis"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Stores creates the key-value stores of an app in Redis, if configured,
+// and in the database otherwise. Values are encrypted if key-encryption keys
+// are configured. The stores of an app are separated by its namespace, so
+// apps sharing a store must create it in the same namespace.
+type Stores struct {
+	db             *gorm.DB
+	redisClient    *redis.Client
+	encryptionKeys *core.KeyEncryptionKeys
+	namespace      string
+}
+
+// NewStores creates the stores of the app with the namespace. The Redis
+// client and the key-encryption keys are optional.
+func NewStores(namespace string, db *gorm.DB, redisClient *redis.Client, encryptionKeys *core.KeyEncryptionKeys) *Stores {
+	return &Stores{
+		db:             db,
+		redisClient:    redisClient,
+		encryptionKeys: encryptionKeys,
+		namespace:      namespace,
+	}
+}
+
+// Namespace returns the stores of another app, to share entries with it.
+func (s *Stores) Namespace(namespace string) *Stores {
+	stores := *s
+	stores.namespace = namespace
+	return &stores
+}
+
+// NewKeyValueStore creates a store, that stores its keys hashed.
+func NewKeyValueStore[Type interface{}](stores *Stores, kind string, codec core.Codec[Type]) core.KeyValueStore[string, Type] {
+	store := NewEncodedStore[Type](stores, kind, codec)
+	if stores.redisClient != nil {
+		// the database hashes keys itself, Redis stores keys as given
+		return core.WithHashedKeys(store, core.HashKey)
+	}
+	return store
+}
+
+// NewEncodedStore creates a store of encoded values, for callers that hash
+// the keys themselves, e.g. with a pepper.
+func NewEncodedStore[Type interface{}](stores *Stores, kind string, codec core.Codec[Type]) core.KeyValueStore[string, Type] {
+	if stores.encryptionKeys == nil {
+		return newStore[Type](stores, kind, codec)
+	}
+
+	store := newStore[*core.EncryptedValue](stores, kind, core.JSONCodec[*core.EncryptedValue]{})
+	return core.NewEncryptedKeyValueStore[Type](store, codec, stores.encryptionKeys)
+}
+
+func newStore[Type interface{}](stores *Stores, kind string, codec core.Codec[Type]) core.KeyValueStore[string, Type] {
+	if stores.redisClient != nil {
+		return redisLocal.NewRedisKeyValueStore[Type](stores.redisClient, stores.namespace+":"+kind+":", codec)
+	}
+
+	store := gormLocal.NewGormKeyValueStore[Type](stores.db, stores.namespace+"_"+kind, codec)
+	go DeleteExpired(context.Background(), store, time.Minute)
+	return store
+}
+
+// ExpiringStore is a store in the database, whose expired entries must be
+// removed.
+type ExpiringStore interface {
+	DeleteExpired(ctx context.Context) error
+}
+
+// DeleteExpired periodically removes expired entries from the store. Lookups
+// ignore expired entries anyway, this only keeps the tables small.
+func DeleteExpired(ctx context.Context, store ExpiringStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.DeleteExpired(ctx); err != nil {
+				slog.Warn("Failed to delete expired entries", "err", err)
+			}
+		}
+	}
+}
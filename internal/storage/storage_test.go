@@ -0,0 +1,95 @@
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	gormLocal "github.com/Untanky/modern-auth/internal/gorm"
+	"github.com/Untanky/modern-auth/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	if err := db.AutoMigrate(&gormLocal.KeyValueModel{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestClient(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func TestStoresShareNamespace(t *testing.T) {
+	keys, err := core.NewKeyEncryptionKeys("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		redisClient    func(t *testing.T) *redis.Client
+		encryptionKeys *core.KeyEncryptionKeys
+	}{
+		{
+			name:        "Database",
+			redisClient: func(t *testing.T) *redis.Client { return nil },
+		},
+		{
+			name:           "Encrypted database",
+			redisClient:    func(t *testing.T) *redis.Client { return nil },
+			encryptionKeys: keys,
+		},
+		{
+			name:        "Redis",
+			redisClient: newTestClient,
+		},
+		{
+			name:           "Encrypted Redis",
+			redisClient:    newTestClient,
+			encryptionKeys: keys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			redisClient := tt.redisClient(t)
+			writer := storage.NewKeyValueStore[string](storage.NewStores("writer", db, redisClient, tt.encryptionKeys), "value", core.JSONCodec[string]{})
+			if err := writer.SetWithTTL("key", "value", time.Minute); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+
+			reader := storage.NewStores("reader", db, redisClient, tt.encryptionKeys)
+			value, err := storage.NewKeyValueStore[string](reader.Namespace("writer"), "value", core.JSONCodec[string]{}).Get("key")
+			if err != nil || value != "value" {
+				t.Errorf("Get() in shared namespace = %v, error = %v", value, err)
+			}
+			_, err = storage.NewKeyValueStore[string](reader, "value", core.JSONCodec[string]{}).Get("key")
+			if err == nil {
+				t.Errorf("Get() in other namespace error = nil, want error")
+			}
+		})
+	}
+}
@@ -1,18 +1,45 @@
 package utils
 
-import "math/rand"
+import (
+	"crypto/rand"
+)
 
-var alphabet = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+const alphanumericAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// RandomBytes fills the slice with cryptographically secure random bytes. It
+// panics if the system's random source fails, as nothing secure can be
+// generated without it.
 func RandomBytes(bytes []byte) {
-	for i := range bytes {
-		bytes[i] = alphabet[rand.Intn(len(alphabet))]
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
 	}
 }
 
-// function to generate a random string
+// RandomString returns a cryptographically secure random alphanumeric string.
 func RandomString(size int) string {
-	data := make([]byte, size)
-	RandomBytes(data)
-	return string(data)
+	return RandomStringFromAlphabet(alphanumericAlphabet, size)
+}
+
+// RandomStringFromAlphabet returns a cryptographically secure random string
+// of characters from the alphabet, which must have at most 256 characters.
+// Every character is equally likely.
+func RandomStringFromAlphabet(alphabet string, size int) string {
+	// bytes beyond the largest multiple of the alphabet size are rejected,
+	// as they would favor the first characters of the alphabet
+	limit := 256 - 256%len(alphabet)
+	result := make([]byte, 0, size)
+	buffer := make([]byte, size)
+	for len(result) < size {
+		RandomBytes(buffer)
+		for _, b := range buffer {
+			if int(b) >= limit {
+				continue
+			}
+			result = append(result, alphabet[int(b)%len(alphabet)])
+			if len(result) == size {
+				break
+			}
+		}
+	}
+	return string(result)
 }
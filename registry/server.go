@@ -2,6 +2,8 @@ package registry
 
 import (
 	"context"
+	"sync"
+
 	"github.com/Untanky/modern-auth/internal/core"
 	"github.com/google/uuid"
 )
@@ -9,6 +11,10 @@ import (
 type registryServer struct {
 	store core.KeyValueStore[string, *RegistrationInfo]
 	index core.KeyValueStore[string, core.List[string]]
+	// newList creates the list of a service name registered for the first time
+	newList core.ListFactory[string]
+	// indexMutex guards the creation of lists
+	indexMutex sync.Mutex
 
 	registerChan   chan<- *RegistrationInfo
 	unregisterChan chan<- *RegistrationInfo
@@ -17,29 +23,31 @@ type registryServer struct {
 func NewRegistryServer(
 	store core.KeyValueStore[string, *RegistrationInfo],
 	index core.KeyValueStore[string, core.List[string]],
+	newList core.ListFactory[string],
 	registerChan chan<- *RegistrationInfo,
 	unregisterChan chan<- *RegistrationInfo,
 ) RegistryServer {
 	return &registryServer{
 		store:          store,
 		index:          index,
+		newList:        newList,
 		registerChan:   registerChan,
 		unregisterChan: unregisterChan,
 	}
 }
 
-func (r registryServer) Register(ctx context.Context, info *RegistrationInfo) (*RegistrationResponse, error) {
+func (r *registryServer) Register(ctx context.Context, info *RegistrationInfo) (*RegistrationResponse, error) {
 	id := uuid.New().String()
 	info.Id = id
 	err := r.store.WithContext(ctx).Set(id, info)
 	if err != nil {
 		return nil, err
 	}
-	list, err := r.index.WithContext(ctx).Get(info.GetName())
+	list, err := r.list(ctx, info.GetName())
 	if err != nil {
 		return nil, err
 	}
-	_, err = list.Append(id)
+	_, err = list.WithContext(ctx).Append(id)
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +61,7 @@ func (r registryServer) Register(ctx context.Context, info *RegistrationInfo) (*
 	return response, nil
 }
 
-func (r registryServer) Unregister(ctx context.Context, response *RegistrationResponse) (*Empty, error) {
+func (r *registryServer) Unregister(ctx context.Context, response *RegistrationResponse) (*Empty, error) {
 	store := r.store.WithContext(ctx)
 	info, err := store.Get(response.Id)
 	if err != nil {
@@ -65,17 +73,13 @@ func (r registryServer) Unregister(ctx context.Context, response *RegistrationRe
 		return nil, err
 	}
 
-	list, err := r.index.WithContext(ctx).Get(info.GetName())
+	list, err := r.list(ctx, info.GetName())
 	if err != nil {
 		return nil, err
 	}
-
-	index, err := list.Index(response.Id)
-	if err != nil {
-		return nil, err
-	}
-
-	err = list.Remove(index)
+	// removing by value is atomic, even if other replicas modify the
+	// list at the same time
+	err = list.WithContext(ctx).RemoveValue(response.Id)
 	if err != nil {
 		return nil, err
 	}
@@ -85,12 +89,36 @@ func (r registryServer) Unregister(ctx context.Context, response *RegistrationRe
 	return &Empty{}, nil
 }
 
-func (r registryServer) Subscribe(request *EndpointRequest, server Registry_SubscribeServer) error {
+// list returns the list of registrations for the service name, creating an
+// empty list if the name was not registered before.
+func (r *registryServer) list(ctx context.Context, name string) (core.List[string], error) {
+	index := r.index.WithContext(ctx)
+	list, err := index.Get(name)
+	if err == nil {
+		return list, nil
+	}
+
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	// another request may have created the list in the meantime
+	list, err = index.Get(name)
+	if err == nil {
+		return list, nil
+	}
+	list = r.newList(name)
+	err = index.Set(name, list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *registryServer) Subscribe(request *EndpointRequest, server Registry_SubscribeServer) error {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (r registryServer) mustEmbedUnimplementedRegistryServer() {
+func (r *registryServer) mustEmbedUnimplementedRegistryServer() {
 	//TODO implement me
 	panic("implement me")
 }
@@ -0,0 +1,131 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Untanky/modern-auth/internal/core"
+	redisLocal "github.com/Untanky/modern-auth/internal/redis"
+	"github.com/Untanky/modern-auth/registry"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type testServer struct {
+	registry.RegistryServer
+	store core.KeyValueStore[string, *registry.RegistrationInfo]
+	index core.KeyValueStore[string, core.List[string]]
+}
+
+func newTestServer(t *testing.T, store *core.InMemoryKeyValueStore[*registry.RegistrationInfo], newList core.ListFactory[string]) *testServer {
+	t.Helper()
+	index := core.NewInMemoryKeyValueStore[core.List[string]]()
+	t.Cleanup(index.Close)
+	// the channels are buffered, as nothing consumes them in the tests
+	registerChan := make(chan *registry.RegistrationInfo, 100)
+	unregisterChan := make(chan *registry.RegistrationInfo, 100)
+	return &testServer{
+		RegistryServer: registry.NewRegistryServer(store, index, newList, registerChan, unregisterChan),
+		store:          store,
+		index:          index,
+	}
+}
+
+func (s *testServer) registrations(name string) []string {
+	list, err := s.index.Get(name)
+	if err != nil {
+		return nil
+	}
+	return list.Slice()
+}
+
+func TestRegistryServer(t *testing.T) {
+	ctx := context.Background()
+	store := core.NewInMemoryKeyValueStore[*registry.RegistrationInfo]()
+	t.Cleanup(store.Close)
+	server := newTestServer(t, store, core.NewInMemoryListFactory[string]())
+
+	first, err := server.Register(ctx, &registry.RegistrationInfo{Name: "oauth2", Url: "http://first"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	second, err := server.Register(ctx, &registry.RegistrationInfo{Name: "oauth2", Url: "http://second"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if first.Id == second.Id {
+		t.Errorf("Register() returned the same id twice")
+	}
+	info, err := server.store.Get(first.Id)
+	if err != nil || info.Url != "http://first" {
+		t.Errorf("stored registration = %v, error = %v", info, err)
+	}
+	if got := server.registrations("oauth2"); !reflect.DeepEqual(got, []string{first.Id, second.Id}) {
+		t.Errorf("registrations = %v, want %v", got, []string{first.Id, second.Id})
+	}
+
+	if _, err := server.Unregister(ctx, first); err != nil {
+		t.Fatalf("Unregister() error = %v", err)
+	}
+	if _, err := server.store.Get(first.Id); err == nil {
+		t.Errorf("registration stored after Unregister()")
+	}
+	if got := server.registrations("oauth2"); !reflect.DeepEqual(got, []string{second.Id}) {
+		t.Errorf("registrations = %v, want %v", got, []string{second.Id})
+	}
+
+	if _, err := server.Unregister(ctx, first); err == nil {
+		t.Errorf("second Unregister() error = nil, want error")
+	}
+	if _, err := server.Unregister(ctx, &registry.RegistrationResponse{Id: "unknown"}); err == nil {
+		t.Errorf("Unregister() of unknown id error = nil, want error")
+	}
+}
+
+func TestRegistryServerConcurrentUnregister(t *testing.T) {
+	ctx := context.Background()
+	redisServer := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	// replicas share the registrations and the lists persisted in redis, but
+	// each one caches the lists in its own index
+	store := core.NewInMemoryKeyValueStore[*registry.RegistrationInfo]()
+	t.Cleanup(store.Close)
+	newList := redisLocal.NewRedisListFactory[string](client, "registry:index:", core.JSONCodec[string]{})
+	replicas := []*testServer{newTestServer(t, store, newList), newTestServer(t, store, newList)}
+
+	const registrations = 20
+	responses := make([]*registry.RegistrationResponse, registrations)
+	for i := range responses {
+		response, err := replicas[i%2].Register(ctx, &registry.RegistrationInfo{Name: "oauth2", Url: fmt.Sprintf("http://%d", i)})
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		responses[i] = response
+	}
+
+	// keep one registration, so a wrong removal shows up in the list
+	kept := responses[0]
+	var wg sync.WaitGroup
+	for i, response := range responses[1:] {
+		wg.Add(1)
+		go func(replica *testServer, response *registry.RegistrationResponse) {
+			defer wg.Done()
+			if _, err := replica.Unregister(ctx, response); err != nil {
+				t.Errorf("Unregister() error = %v", err)
+			}
+		}(replicas[i%2], response)
+	}
+	wg.Wait()
+
+	for _, replica := range replicas {
+		if got := replica.registrations("oauth2"); !reflect.DeepEqual(got, []string{kept.Id}) {
+			t.Errorf("registrations = %v, want %v", got, []string{kept.Id})
+		}
+	}
+}